@@ -0,0 +1,224 @@
+package appleii
+
+/* acia.go -- The 6551 Asynchronous Communications Interface Adapter
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//6551 registers
+const (
+	aciaData    = 0x0 //Receive data (read), transmit data (write)
+	aciaStatus  = 0x1 //Status (read), programmed reset (write)
+	aciaCommand = 0x2
+	aciaControl = 0x3
+)
+
+//6551 status bits
+const (
+	aciaOverrun uint8 = 0x04
+	aciaRDRF    uint8 = 0x08 //Receive data register full
+	aciaTDRE    uint8 = 0x10 //Transmit data register empty
+	aciaDCD     uint8 = 0x20 //Data carrier detect, 0 is active
+	aciaDSR     uint8 = 0x40 //Data set ready, 0 is active
+	aciaIRQ     uint8 = 0x80
+)
+
+//6551 command bits
+const (
+	aciaDTR       uint8 = 0x01 //Data terminal ready, the receiver only runs with it on
+	aciaRxIRQOff  uint8 = 0x02 //No receive interrupts
+	aciaTxControl uint8 = 0x0C //Transmit interrupts, RTS and break
+	aciaTxIRQ     uint8 = 0x04 //Transmit control value with transmit interrupts on
+	aciaParity    uint8 = 0x20 //Send and check a parity bit
+)
+
+//aciaBaud the rate for each baud setting of the control register, 0 is the 16x external clock
+var aciaBaud = [16]uint64{115200, 50, 75, 110, 135, 150, 300, 600, 1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200}
+
+//SerialLine what is plugged into a serial port
+type SerialLine interface {
+	//Send a byte down the line
+	Send(b uint8)
+	//Receive the next byte that has come in, false when nothing is waiting
+	Receive() (uint8, bool)
+}
+
+//ACIA a 6551, characters take as long to send and receive as the baud rate says. The receiver only
+//takes a character from the line when the last one has been read, so nothing is overrun
+type ACIA struct {
+	regs   ACIAState
+	bus    *Bus
+	tx, rx *Timer
+	//Line what the port is connected to, nil for nothing
+	Line SerialLine
+	//IRQ is called when the interrupt output changes, optional
+	IRQ func(asserted bool)
+}
+
+//NewACIA a 6551 timed by the bus
+func NewACIA(b *Bus) *ACIA {
+	a := ACIA{bus: b}
+	a.tx = b.NewTimer(a.sent)
+	a.rx = b.NewTimer(a.receive)
+	a.Reset()
+	return &a
+}
+
+//Reset the hardware reset, the transmitter is idle and interrupts are off
+func (a *ACIA) Reset() {
+	a.regs = ACIAState{Status: aciaTDRE, Command: aciaRxIRQOff}
+	a.tx.Cancel()
+	a.rx.Cancel()
+	a.setIRQ(false)
+}
+
+//Read a register
+func (a *ACIA) Read(reg uint8) uint8 {
+	r := &a.regs
+	switch reg & 3 {
+	case aciaData:
+		r.Status &^= aciaRDRF | aciaOverrun
+		return r.RDR
+	case aciaStatus:
+		status := a.Peek(reg)
+		if r.Status&aciaIRQ != 0 {
+			r.Status &^= aciaIRQ
+			a.setIRQ(false)
+		}
+		return status
+	case aciaCommand:
+		return r.Command
+	default: //aciaControl
+		return r.Control
+	}
+}
+
+//Peek what Read would return without acknowledging anything
+func (a *ACIA) Peek(reg uint8) uint8 {
+	r := &a.regs
+	switch reg & 3 {
+	case aciaData:
+		return r.RDR
+	case aciaStatus:
+		status := r.Status
+		if a.Line == nil {
+			status |= aciaDCD | aciaDSR
+		}
+		return status
+	case aciaCommand:
+		return r.Command
+	default: //aciaControl
+		return r.Control
+	}
+}
+
+//Write a register
+func (a *ACIA) Write(reg uint8, data uint8) {
+	r := &a.regs
+	switch reg & 3 {
+	case aciaData:
+		r.TDR = data
+		r.Status &^= aciaTDRE
+		if !r.Sending {
+			a.startSending()
+		}
+	case aciaStatus:
+		//Programmed reset
+		r.Command &= 0xE0
+		r.Status &^= aciaOverrun
+		a.rx.Cancel()
+	case aciaCommand:
+		r.Command = data
+		a.startReceiving()
+		if r.Status&aciaTDRE != 0 && r.Command&aciaTxControl == aciaTxIRQ {
+			a.interrupt()
+		}
+	default: //aciaControl
+		r.Control = data
+	}
+}
+
+//charCycles how long a character takes with the current settings
+func (a *ACIA) charCycles() uint64 {
+	r := &a.regs
+	bits := uint64(1 + 8 - (r.Control>>5)&3 + 1) //Start, data and stop
+	if r.Control&0x80 != 0 {
+		bits++
+	}
+	if r.Command&aciaParity != 0 {
+		bits++
+	}
+	return ClockHz * bits / aciaBaud[r.Control&0x0F]
+}
+
+//startSending move the transmit data register to the shift register and start shifting it out
+func (a *ACIA) startSending() {
+	r := &a.regs
+	r.Shift, r.Sending = r.TDR, true
+	r.Status |= aciaTDRE
+	a.tx.Schedule(a.bus.Cycles() + a.charCycles())
+	if r.Command&aciaTxControl == aciaTxIRQ {
+		a.interrupt()
+	}
+}
+
+//sent the character has gone down the line, the next one starts if it's waiting
+func (a *ACIA) sent() {
+	r := &a.regs
+	if a.Line != nil {
+		a.Line.Send(r.Shift)
+	}
+	r.Sending = false
+	if r.Status&aciaTDRE == 0 {
+		a.startSending()
+	}
+}
+
+//startReceiving run the receiver while DTR is on and something is plugged in
+func (a *ACIA) startReceiving() {
+	if a.regs.Command&aciaDTR == 0 || a.Line == nil {
+		a.rx.Cancel()
+	} else if !a.rx.Pending() {
+		a.rx.Schedule(a.bus.Cycles() + a.charCycles())
+	}
+}
+
+//receive a character time has passed, take the next character if there's room for it
+func (a *ACIA) receive() {
+	r := &a.regs
+	a.rx.Schedule(a.rx.Cycle() + a.charCycles())
+	if r.Status&aciaRDRF != 0 {
+		return
+	}
+	if b, ok := a.Line.Receive(); ok {
+		r.RDR = b
+		r.Status |= aciaRDRF
+		if r.Command&aciaRxIRQOff == 0 {
+			a.interrupt()
+		}
+	}
+}
+
+func (a *ACIA) interrupt() {
+	a.regs.Status |= aciaIRQ
+	a.setIRQ(true)
+}
+
+func (a *ACIA) setIRQ(asserted bool) {
+	if a.IRQ != nil {
+		a.IRQ(asserted)
+	}
+}
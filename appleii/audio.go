@@ -0,0 +1,96 @@
+package appleii
+
+/* audio.go -- Mixes the sound of every device into one stream of samples
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+const (
+	//ClockHz the CPU clock, 14.31818MHz / 14 with every 65th cycle stretched
+	ClockHz = 1020484
+	//SampleRate audio samples per second on each side
+	SampleRate = 44100
+)
+
+//AudioSource a device that makes sound
+type AudioSource interface {
+	//Sample the output at cycle, each side is -32768 to 32767 at full volume
+	Sample(cycle uint64) (left, right int)
+}
+
+//Mixer adds up every source SampleRate times a second, the stereo samples wait until Samples takes them
+type Mixer struct {
+	bus       *Bus
+	sources   []AudioSource
+	timer     *Timer
+	listening bool    //Something takes the samples, otherwise there's no point making them
+	rem       uint64  //Fraction of a cycle carried over to the next sample, in 1/SampleRate
+	buf       []int16 //Samples, left then right
+}
+
+//NewMixer a mixer with nothing to mix, it stays idle until a source is added. Without anything
+//listening it never samples at all
+func NewMixer(b *Bus, listening bool) *Mixer {
+	m := Mixer{bus: b, listening: listening}
+	m.timer = b.NewTimer(m.sample)
+	return &m
+}
+
+//Listening is anything taking the samples, sources can stay quiet when nothing is
+func (m *Mixer) Listening() bool {
+	return m.listening
+}
+
+//AddSource mix s into the output
+func (m *Mixer) AddSource(s AudioSource) {
+	m.sources = append(m.sources, s)
+	if m.listening && !m.timer.Pending() {
+		m.timer.Schedule(m.bus.Cycles() + 1)
+	}
+}
+
+//Samples take the samples made since the last call, left then right
+func (m *Mixer) Samples() []int16 {
+	buf := m.buf
+	m.buf = nil
+	return buf
+}
+
+//sample mix the sources at this cycle
+func (m *Mixer) sample() {
+	cycle := m.timer.Cycle()
+	left, right := 0, 0
+	for _, s := range m.sources {
+		l, r := s.Sample(cycle)
+		left += l
+		right += r
+	}
+	m.buf = append(m.buf, clip16(left), clip16(right))
+
+	step := ClockHz + m.rem
+	m.rem = step % SampleRate
+	m.timer.Schedule(cycle + step/SampleRate)
+}
+
+//clip16 limit a mixed sample to what 16 bits can hold
+func clip16(v int) int16 {
+	if v > 32767 {
+		return 32767
+	} else if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
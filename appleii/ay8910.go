@@ -0,0 +1,215 @@
+package appleii
+
+/* ay8910.go -- The General Instrument AY-3-8910 Programmable Sound Generator
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//Bus control functions, BDIR BC1 on the chip's pins (BC2 is tied high)
+const (
+	ayInactive = 0 //The chip ignores the bus
+	ayRead     = 1 //The chip puts the latched register on the bus
+	ayWrite    = 2 //The bus is written to the latched register
+	ayLatch    = 3 //The bus selects a register
+)
+
+//AY-3-8910 registers
+const (
+	ayNoisePeriod = 0x6
+	ayMixer       = 0x7 //Tone (bits 0-2) and noise (bits 3-5) off for each channel
+	ayAmplitude   = 0x8 //Three registers, bit 4 uses the envelope
+	ayEnvPeriod   = 0xB //Two registers, low then high
+	ayEnvShape    = 0xD
+)
+
+//Envelope shape bits
+const (
+	ayHold      = 0x1
+	ayAlternate = 0x2
+	ayAttack    = 0x4
+	ayContinue  = 0x8
+)
+
+//ayClocksPerTick the tone counters count every 8 clocks, a tone period of P is a square wave of 16P clocks
+const ayClocksPerTick = 8
+
+//ayVolume the output of a channel at each amplitude, the steps are logarithmic, 3 channels together reach 32767
+var ayVolume = [16]int{0, 150, 224, 318, 463, 676, 926, 1497, 1849, 2894, 3856, 4919, 6236, 7514, 9273, 10922}
+
+//AY8910 a PSG clocked at the CPU clock. It only runs when it's sampled or written, catching up on
+//the clocks it missed
+type AY8910 struct {
+	//Quiet the tone, noise and envelope counters aren't run because nobody is listening
+	Quiet bool
+	regs  AYState
+}
+
+//NewAY8910 a PSG that has just been reset
+func NewAY8910() *AY8910 {
+	ay := AY8910{}
+	ay.Reset()
+	return &ay
+}
+
+//Reset clear every register, which silences the chip
+func (ay *AY8910) Reset() {
+	last, sub := ay.regs.Last, ay.regs.Sub
+	ay.regs = AYState{Last: last, Sub: sub, LFSR: 1}
+}
+
+//Control the BDIR and BC1 pins (function 0-3) with data on the bus, at cycle
+func (ay *AY8910) Control(function uint8, data uint8, cycle uint64) {
+	r := &ay.regs
+	r.Function = function
+	switch function {
+	case ayLatch:
+		if data < 0x10 {
+			r.Addr = data
+		}
+	case ayWrite:
+		ay.run(cycle)
+		r.Regs[r.Addr] = data
+		if r.Addr == ayEnvShape {
+			r.EnvStep, r.EnvCount, r.EnvHolding = 0, 0, false
+			r.EnvAttack = data&ayAttack != 0
+		}
+	}
+}
+
+//Data what the chip drives the bus with, nothing (high) unless it's being read
+func (ay *AY8910) Data() uint8 {
+	if ay.regs.Function != ayRead {
+		return 0xFF
+	}
+	return ay.regs.Regs[ay.regs.Addr]
+}
+
+//Output the three channels added up at cycle, averaged since the last call
+func (ay *AY8910) Output(cycle uint64) int {
+	ay.run(cycle)
+	r := &ay.regs
+	out := 0
+	for ch := range r.Sum {
+		if r.Ticks > 0 {
+			out += r.Sum[ch] / r.Ticks
+		} else {
+			out += ay.level(ch)
+		}
+		r.Sum[ch] = 0
+	}
+	r.Ticks = 0
+	return out
+}
+
+//run catch up to cycle
+func (ay *AY8910) run(cycle uint64) {
+	r := &ay.regs
+	if cycle <= r.Last {
+		return
+	}
+	if ay.Quiet {
+		r.Last, r.Sub = cycle, 0
+		return
+	}
+	clocks := cycle - r.Last + r.Sub
+	r.Last = cycle
+	r.Sub = clocks % ayClocksPerTick
+	for ticks := clocks / ayClocksPerTick; ticks > 0; ticks-- {
+		ay.tick()
+	}
+}
+
+//tick the counters move on 8 clocks
+func (ay *AY8910) tick() {
+	r := &ay.regs
+	for ch := range r.ToneCount {
+		r.ToneCount[ch]++
+		if r.ToneCount[ch] >= ay.period(uint16(r.Regs[ch*2])|uint16(r.Regs[ch*2+1]&0x0F)<<8) {
+			r.ToneCount[ch] = 0
+			r.ToneOut[ch] = !r.ToneOut[ch]
+		}
+	}
+	//Noise and the envelope step at half the rate of the tones
+	r.NoiseCount++
+	if r.NoiseCount >= 2*ay.period(uint16(r.Regs[ayNoisePeriod]&0x1F)) {
+		r.NoiseCount = 0
+		r.LFSR = r.LFSR>>1 | ((r.LFSR^r.LFSR>>3)&1)<<16
+	}
+	r.EnvCount++
+	if r.EnvCount >= 2*ay.period(uint16(r.Regs[ayEnvPeriod])|uint16(r.Regs[ayEnvPeriod+1])<<8) {
+		r.EnvCount = 0
+		ay.stepEnvelope()
+	}
+	r.Ticks++
+	for ch := range r.Sum {
+		r.Sum[ch] += ay.level(ch)
+	}
+}
+
+//period a period register, 0 counts as 1
+func (ay *AY8910) period(p uint16) uint32 {
+	if p == 0 {
+		return 1
+	}
+	return uint32(p)
+}
+
+//stepEnvelope move the envelope on, at the end of a ramp the shape says what happens next
+func (ay *AY8910) stepEnvelope() {
+	r := &ay.regs
+	if r.EnvHolding {
+		return
+	}
+	r.EnvStep++
+	if r.EnvStep < 16 {
+		return
+	}
+	shape := r.Regs[ayEnvShape]
+	switch {
+	case shape&ayContinue == 0:
+		//One ramp then silence
+		r.EnvStep, r.EnvAttack, r.EnvHolding = 15, false, true
+	case shape&ayHold != 0:
+		r.EnvStep, r.EnvHolding = 15, true
+		if shape&ayAlternate != 0 {
+			r.EnvAttack = !r.EnvAttack
+		}
+	default:
+		r.EnvStep = 0
+		if shape&ayAlternate != 0 {
+			r.EnvAttack = !r.EnvAttack
+		}
+	}
+}
+
+//level the output of channel ch right now
+func (ay *AY8910) level(ch int) int {
+	r := &ay.regs
+	mixer := r.Regs[ayMixer]
+	tone := r.ToneOut[ch] || mixer&(1<<uint(ch)) != 0
+	noise := r.LFSR&1 != 0 || mixer&(8<<uint(ch)) != 0
+	if !tone || !noise {
+		return 0
+	}
+	amp := r.Regs[ayAmplitude+ch]
+	if amp&0x10 == 0 {
+		return ayVolume[amp&0x0F]
+	}
+	if r.EnvAttack {
+		return ayVolume[r.EnvStep]
+	}
+	return ayVolume[15-r.EnvStep]
+}
@@ -24,19 +24,38 @@ import (
 
 //Buser describes a device that sits on the bus in a specified address range
 type Buser interface {
-	busUpdate() //Called when the bus updates in an address range this object cares about
+	Read(addr uint16) uint8        //The CPU reads from the device, this may have side effects
+	Write(addr uint16, data uint8) //The CPU writes to the device
+	Peek(addr uint16) uint8        //What Read would return but without any side effects (for debuggers)
 	Reset()
 }
 
 //Bus holds the current state of the BUS
 type Bus struct {
-	addr         uint16
-	data         uint8
-	cpuIRQ       bool
-	cpuNMI       bool
-	cpuReadWrite bool //True is read, False is write
-	objects      []*BusObject
-	fastMode     bool
+	addr     uint16
+	data     uint8
+	irq      uint32 //Interrupt sources holding the IRQ line, one bit each (see IRQSlot)
+	cpuNMI   bool
+	objects  []*BusObject
+	fastMode bool
+	master   BusMaster //Card that has taken the bus from the 6502, nil while the 6502 has it
+	//Cycle scheduler
+	cpu       *CPU       //The clock, set by NewCPU
+	timers    []*Timer   //Every timer made, by number
+	queue     timerQueue //Scheduled timers
+	nextTimer uint64     //Cycle the soonest timer is due
+	//Trace is called for every read and write when set, it slows everything down (for debuggers)
+	Trace func(addr uint16, data uint8, write bool)
+	//Address decoding by page, memory on a direct page is used without calling its device
+	pages      [256][]*BusObject //Devices on each page, in the same order as objects
+	readPages  [256][]uint8      //Memory read directly, nil goes through the devices
+	writePages [256][]uint8      //Memory written directly, nil goes through the devices
+}
+
+//BusMaster a processor on a card that can take the bus away from the 6502 (DMA)
+type BusMaster interface {
+	//Step run one instruction and give the 6502 cycles it took
+	Step() int
 }
 
 //BusObject is an actual IC on the bus
@@ -48,38 +67,90 @@ type BusObject struct {
 
 //NewBus creates and initializes a new Bus
 func NewBus() *Bus {
-	var bus Bus
+	bus := Bus{nextTimer: noTimer}
 	return &bus
 }
 
 //Add a new BusObject to this bus at specified address range...
-//Order is important here! Objects added to the bus later will override those added earlier
+//Order is important here! Objects added to the bus later will override those added earlier,
+//every object in range sees a read or write but a read returns what the latest one says
 func (b *Bus) Add(o Buser, startAddress uint16, endAddress uint16) {
 	if endAddress < startAddress {
 		log.Fatal("Object added to BUS must have an end address > start address")
 	}
 	obj := BusObject{object: o, start: startAddress, end: endAddress}
 	b.objects = append([]*BusObject{&obj}, b.objects...)
+	for page := startAddress >> 8; page <= endAddress>>8; page++ {
+		b.pages[page] = append([]*BusObject{&obj}, b.pages[page]...)
+		if len(b.pages[page]) > 1 {
+			//Devices share the page now, they all have to see every access
+			b.readPages[page], b.writePages[page] = nil, nil
+		}
+	}
+}
+
+//MapPage let the CPU read and/or write the 256 bytes of a page directly, nil sends accesses to the
+//device. Pages shared by more than one device are never direct
+func (b *Bus) MapPage(page uint8, read []uint8, write []uint8) {
+	if len(b.pages[page]) > 1 {
+		read, write = nil, nil
+	}
+	b.readPages[page], b.writePages[page] = read, write
+}
+
+//Read a byte from the bus
+func (b *Bus) Read(addr uint16) uint8 {
+	b.addr = addr
+	if p := b.readPages[addr>>8]; p != nil && b.Trace == nil {
+		b.data = p[addr&0xFF]
+		return b.data
+	}
+	//Every device hears the read, going oldest to newest so the newest has the last word
+	objects := b.pages[addr>>8]
+	for i := len(objects) - 1; i >= 0; i-- {
+		if o := objects[i]; addr >= o.start && addr <= o.end {
+			b.data = o.object.Read(addr)
+		}
+	}
+	if b.Trace != nil {
+		b.Trace(addr, b.data, false)
+	}
+	return b.data
 }
 
-//Set sets the lines on the bus, pass nil to a parameter to leave it as is
-func (b *Bus) Set(aAddr *uint16, aData *uint8, aReadWrite *bool) {
-	if aData != nil {
-		b.data = *aData
+//Write a byte to the bus
+func (b *Bus) Write(addr uint16, data uint8) {
+	b.addr, b.data = addr, data
+	if b.Trace != nil {
+		b.Trace(addr, data, true)
 	}
-	if aReadWrite != nil {
-		b.cpuReadWrite = *aReadWrite
+	if p := b.writePages[addr>>8]; p != nil {
+		p[addr&0xFF] = data
+		return
 	}
-	if aAddr != nil {
-		b.addr = *aAddr
-		for _, o := range b.objects {
-			if *aAddr >= o.start && *aAddr <= o.end {
-				o.object.busUpdate()
-			}
+	objects := b.pages[addr>>8]
+	for i := len(objects) - 1; i >= 0; i-- {
+		if o := objects[i]; addr >= o.start && addr <= o.end {
+			o.object.Write(addr, data)
 		}
 	}
 }
 
+//Peek what a read would return without any side effects (for debuggers)
+func (b *Bus) Peek(addr uint16) uint8 {
+	for _, o := range b.pages[addr>>8] {
+		if addr >= o.start && addr <= o.end {
+			return o.object.Peek(addr)
+		}
+	}
+	return 0
+}
+
+//Cycles the CPU cycle count, the time timers are scheduled in
+func (b *Bus) Cycles() uint64 {
+	return b.cpu.GetCycleCount()
+}
+
 //Data gets the data currently on the bus
 func (b *Bus) Data() uint8 {
 	return b.data
@@ -88,16 +159,37 @@ func (b *Bus) Data() uint8 {
 //Reset the bus object when the CPU resets
 func (b *Bus) Reset() {
 	b.fastMode = false
+	b.master = nil
 	for _, o := range b.objects {
 		o.object.Reset()
 	}
 }
 
+//SetIRQ assert or release the IRQ line for one interrupt source, the line stays asserted while any source holds it
+func (b *Bus) SetIRQ(source uint32, asserted bool) {
+	if asserted {
+		b.irq |= source
+	} else {
+		b.irq &= ^source
+	}
+}
+
+//IRQ is any source asserting the IRQ line
+func (b *Bus) IRQ() bool {
+	return b.irq != 0
+}
+
 //SetFastMode disable emulation throttles and go full speed (Used for speeding up diskette loading)
 func (b *Bus) SetFastMode(mode bool) {
 	b.fastMode = mode
 }
 
+//SetMaster give the bus to m, nil gives it back to the 6502. The 6502 stops after the instruction
+//it's running and carries on from there when it gets the bus back
+func (b *Bus) SetMaster(m BusMaster) {
+	b.master = m
+}
+
 //GetFastMode return the status of Fast Mode
 func (b *Bus) GetFastMode() bool {
 	return b.fastMode
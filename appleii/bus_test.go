@@ -0,0 +1,144 @@
+package appleii
+
+/* bus_test.go -- Bus address decoding tests and benchmarks
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"os"
+	"testing"
+)
+
+//testMachine a reset Apple IIe with nothing in the slots. The ROMs are loaded from ./data so the
+//tests run from the top of the repository
+func testMachine(tb testing.TB) (*Bus, *CPU, *Mem) {
+	tb.Helper()
+	if _, err := os.Stat("data/system.bin"); err != nil {
+		if err := os.Chdir(".."); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	b := NewBus()
+	c := NewCPU(b)
+	m := NewMem(b, c)
+	b.Add(m, 0, 0xFFFF)
+	c.Reset()
+	return b, c, m
+}
+
+func TestBusDirectPagesMatchDevice(t *testing.T) {
+	b, _, m := testMachine(t)
+	for addr := 0; addr < 0xC000; addr += 7 {
+		b.Write(uint16(addr), uint8(addr*13))
+	}
+	//Switch in the language card RAM for reading and writing ($C083 twice)
+	b.Read(0xC083)
+	b.Read(0xC083)
+	for addr := 0xD000; addr <= 0xFFFF; addr += 5 {
+		b.Write(uint16(addr), uint8(addr*7))
+	}
+	for addr := 0; addr <= 0xFFFF; addr++ {
+		if addr >= 0xC000 && addr < 0xD000 {
+			continue
+		}
+		if got, want := b.Read(uint16(addr)), m.Read(uint16(addr)); got != want {
+			t.Fatalf("$%04X reads %02X from the page table, %02X from memory", addr, got, want)
+		}
+	}
+}
+
+func TestBusSharedPagesAreNotDirect(t *testing.T) {
+	b, _, _ := testMachine(t)
+	s := &busSpy{}
+	b.Add(s, 0x0300, 0x03FF)
+	b.Write(0x0300, 0x42)
+	b.Read(0x0300)
+	if s.reads != 1 || s.writes != 1 {
+		t.Errorf("a device sharing page 3 saw %d reads and %d writes, want 1 and 1", s.reads, s.writes)
+	}
+}
+
+//busSpy counts the accesses it sees
+type busSpy struct {
+	reads, writes int
+}
+
+func (s *busSpy) Read(addr uint16) uint8 {
+	s.reads++
+	return 0
+}
+
+func (s *busSpy) Write(addr uint16, data uint8) {
+	s.writes++
+}
+
+func (s *busSpy) Peek(addr uint16) uint8 {
+	return 0
+}
+
+func (s *busSpy) Reset() {
+}
+
+//BenchmarkBusReadRAM main RAM read straight from the page table
+func BenchmarkBusReadRAM(b *testing.B) {
+	bus, _, _ := testMachine(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Read(0x0300)
+	}
+}
+
+//BenchmarkBusReadRAMDispatch the same read with the page taken out of the page table, so it goes
+//through device dispatch and Mem.Read the way every read used to
+func BenchmarkBusReadRAMDispatch(b *testing.B) {
+	bus, _, _ := testMachine(b)
+	bus.MapPage(0x03, nil, nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Read(0x0300)
+	}
+}
+
+//BenchmarkBusReadIO the keyboard, the I/O page always goes through Mem
+func BenchmarkBusReadIO(b *testing.B) {
+	bus, _, _ := testMachine(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Read(0xC000)
+	}
+}
+
+//BenchmarkBusWriteRAM main RAM written straight through the page table
+func BenchmarkBusWriteRAM(b *testing.B) {
+	bus, _, _ := testMachine(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Write(0x0300, uint8(i))
+	}
+}
+
+//BenchmarkRunFrame a frame of the ROM running with the slots empty
+func BenchmarkRunFrame(b *testing.B) {
+	_, _, m := testMachine(b)
+	for i := 0; i < 120; i++ {
+		m.RunFrame()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.RunFrame()
+	}
+}
@@ -0,0 +1,144 @@
+package appleii
+
+/* card.go -- Peripheral cards for slots 1-7
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "log"
+
+//Card is a peripheral card in one of the slots, Mem decides which card (or the internal ROM)
+//answers each access. A card raises an interrupt with Bus.SetIRQ(IRQSlot(slot), true)
+type Card interface {
+	//IO the 16 I/O locations at $C0n0-$C0nF (reg 0-15), the return value is ignored for writes
+	IO(reg uint8, data uint8, read bool) uint8
+	//ROM the 256 bytes at $Cn00-$CnFF, accessing them gives the card the expansion ROM
+	ROM(offset uint8, data uint8, read bool) uint8
+	//ExpansionROM the 2k at $C800-$CFFF (offset 0-$7FF) while the card has it, $CFFF takes it away
+	ExpansionROM(offset uint16, data uint8, read bool) uint8
+	//PeekIO, PeekROM and PeekExpansionROM what a read would return without the card doing
+	//anything about it (for debuggers)
+	PeekIO(reg uint8) uint8
+	PeekROM(offset uint8) uint8
+	PeekExpansionROM(offset uint16) uint8
+	//Reset the card when the Apple is reset
+	Reset()
+}
+
+//StateCard a card with state of its own to keep in save states
+type StateCard interface {
+	CardState() ([]byte, error)
+	SetCardState(data []byte) error
+}
+
+//NoExpansionROM embed in cards that don't have an expansion ROM
+type NoExpansionROM struct{}
+
+//ExpansionROM nothing is there
+func (NoExpansionROM) ExpansionROM(offset uint16, data uint8, read bool) uint8 {
+	return 0
+}
+
+//PeekExpansionROM nothing is there
+func (NoExpansionROM) PeekExpansionROM(offset uint16) uint8 {
+	return 0
+}
+
+//IRQSlot the interrupt source bit of the card in slot
+func IRQSlot(slot int) uint32 {
+	return 1 << uint(slot)
+}
+
+//InsertCard plug c into slot 1-7, nil empties the slot
+func (m *Mem) InsertCard(slot int, c Card) {
+	if slot < 1 || slot > 7 {
+		log.Fatalf("There is no slot %d", slot)
+	}
+	m.slots[slot] = c
+}
+
+//Card the card in slot, nil if the slot is empty
+func (m *Mem) Card(slot int) Card {
+	return m.slots[slot]
+}
+
+//slotIO an access to $C090-$C0FF, the I/O locations of slots 1-7
+func (m *Mem) slotIO(addr uint16, data uint8, read bool) uint8 {
+	slot := (addr - 0xC080) >> 4
+	if c := m.slots[slot]; c != nil {
+		return c.IO(uint8(addr&0xF), data, read)
+	}
+	return 0
+}
+
+//slotROM an access to $C100-$CFFF, this is the one place the internal ROM and the cards are sorted out
+func (m *Mem) slotROM(addr uint16, data uint8, read bool) uint8 {
+	if addr >= 0xC800 {
+		result := uint8(0)
+		if m.INTCXROM || m.intC8ROM {
+			result = m.rom[addr-0xC000]
+		} else if c := m.slots[m.expansion]; c != nil {
+			result = c.ExpansionROM(addr-0xC800, data, read)
+		}
+		if addr == 0xCFFF {
+			//Every card lets go of the expansion ROM
+			m.expansion = 0
+			m.intC8ROM = false
+		}
+		return result
+	}
+
+	slot := int(addr>>8) & 0x7
+	if m.INTCXROM || (slot == 3 && !m.SLOTC3ROM) {
+		if slot == 3 {
+			//The 80 column firmware carries on into the internal $C800 ROM
+			m.intC8ROM = true
+		}
+		return m.rom[addr-0xC000]
+	}
+	c := m.slots[slot]
+	if c == nil {
+		return 0 //No card in this slot
+	}
+	m.expansion = slot
+	return c.ROM(uint8(addr), data, read)
+}
+
+//peekSlotIO what a read of $C090-$C0FF would give, the card doesn't see it
+func (m *Mem) peekSlotIO(addr uint16) uint8 {
+	slot := (addr - 0xC080) >> 4
+	if c := m.slots[slot]; c != nil {
+		return c.PeekIO(uint8(addr & 0xF))
+	}
+	return 0
+}
+
+//peekSlotROM what a read of $C100-$CFFF would give without changing who has the expansion ROM,
+//the cards don't see it
+func (m *Mem) peekSlotROM(addr uint16) uint8 {
+	slot := int(addr>>8) & 0x7
+	switch {
+	case m.INTCXROM, addr < 0xC800 && slot == 3 && !m.SLOTC3ROM, addr >= 0xC800 && m.intC8ROM:
+		return m.rom[addr-0xC000]
+	case addr >= 0xC800:
+		if c := m.slots[m.expansion]; c != nil {
+			return c.PeekExpansionROM(addr - 0xC800)
+		}
+	case m.slots[slot] != nil:
+		return m.slots[slot].PeekROM(uint8(addr))
+	}
+	return 0
+}
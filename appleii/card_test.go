@@ -0,0 +1,98 @@
+package appleii
+
+/* card_test.go -- Peeking at the slots leaves the cards alone
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "testing"
+
+//peekSpy a card that fails the test if it's accessed, its peeks return where they were asked for
+type peekSpy struct {
+	NoExpansionROM
+	t *testing.T
+}
+
+func (s *peekSpy) IO(reg uint8, data uint8, read bool) uint8 {
+	s.t.Errorf("IO %X was accessed by a peek", reg)
+	return 0
+}
+
+func (s *peekSpy) ROM(offset uint8, data uint8, read bool) uint8 {
+	s.t.Errorf("ROM %02X was accessed by a peek", offset)
+	return 0
+}
+
+func (s *peekSpy) PeekIO(reg uint8) uint8 {
+	return 0x10 | reg
+}
+
+func (s *peekSpy) PeekROM(offset uint8) uint8 {
+	return offset
+}
+
+func (s *peekSpy) Reset() {
+}
+
+func TestPeekSlots(t *testing.T) {
+	b, _, m := testMachine(t)
+	m.InsertCard(4, &peekSpy{t: t})
+	if got := b.Peek(0xC0C5); got != 0x15 {
+		t.Errorf("$C0C5 peeks as %02X, want 15", got)
+	}
+	if got := b.Peek(0xC47A); got != 0x7A {
+		t.Errorf("$C47A peeks as %02X, want 7A", got)
+	}
+	if m.expansion != 0 {
+		t.Errorf("peeking the slot ROM gave slot %d the expansion ROM", m.expansion)
+	}
+}
+
+func TestPeekDiskLatch(t *testing.T) {
+	b, _, m := testMachine(t)
+	//NewDsk wants a disk image, an empty controller is enough here
+	d := &Dsk{bus: b, boot: make([]byte, 256)}
+	d.nibble = b.NewTimer(d.nextNibble)
+	m.InsertCard(6, d)
+	d.dataLatch = 0xD5
+	for i := 0; i < 2; i++ {
+		if got := b.Peek(0xC0EC); got != 0xD5 {
+			t.Errorf("peek %d of the data latch is %02X, want D5", i, got)
+		}
+	}
+	if got := b.Peek(0xC0E9); got != 0 || d.motorOn {
+		t.Errorf("peeking $C0E9 read %02X and turned the motor on", got)
+	}
+}
+
+func TestPeekMockingboardIRQ(t *testing.T) {
+	b, _, m := testMachine(t)
+	mb := NewMockingboard(b, NewMixer(b, false), 4, false)
+	m.InsertCard(4, mb)
+	//Timer 1 of the first VIA has run out with its interrupt enabled
+	b.Write(0xC40E, 0x80|viaIntT1)
+	mb.via[0].setIRQ(viaIntT1)
+	for i := 0; i < 2; i++ {
+		b.Peek(0xC404)
+		if got := b.Peek(0xC40D); got != viaIntAny|viaIntT1 {
+			t.Errorf("peek %d of the timer left IFR %02X, want %02X", i, got, viaIntAny|viaIntT1)
+		}
+	}
+	b.Read(0xC404)
+	if got := b.Peek(0xC40D); got != 0 {
+		t.Errorf("reading the timer left IFR %02X, want 0", got)
+	}
+}
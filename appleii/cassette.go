@@ -0,0 +1,158 @@
+package appleii
+
+/* cassette.go -- The cassette port, $C020 out and $C060 in
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "sort"
+
+//tapeHysteresis the input has to swing past 1/tapeHysteresis of the loudest sample to change level
+const tapeHysteresis = 8
+
+//CassetteRecorder hears the cassette output
+type CassetteRecorder interface {
+	//Output the output went high or low at cycle
+	Output(cycle uint64, high bool)
+}
+
+//Tape a recording for the cassette input, kept as the cycles where the signal crosses zero
+type Tape struct {
+	crossings []uint64 //Cycles from the start of the tape, in order
+	high      bool     //Level before the first crossing
+	length    uint64   //Cycles the whole tape runs for
+}
+
+//NewTape a tape made of samples recorded rate times a second. The ROM only times the gaps between
+//zero crossings, a little hysteresis stops noise around zero adding crossings of its own
+func NewTape(samples []int, rate int) *Tape {
+	peak := 0
+	for _, s := range samples {
+		if s > peak {
+			peak = s
+		} else if -s > peak {
+			peak = -s
+		}
+	}
+	threshold := peak / tapeHysteresis
+	t := Tape{length: uint64(len(samples)) * ClockHz / uint64(rate)}
+	started, high := false, false
+	for i, s := range samples {
+		switch {
+		case s > threshold:
+			high = true
+		case s < -threshold:
+			high = false
+		default:
+			continue
+		}
+		if !started {
+			started, t.high = true, high
+		} else if high != t.level(len(t.crossings)) {
+			t.crossings = append(t.crossings, uint64(i)*ClockHz/uint64(rate))
+		}
+	}
+	return &t
+}
+
+//level the input after n crossings
+func (t *Tape) level(n int) bool {
+	return t.high != (n%2 == 1)
+}
+
+//Length how many cycles the tape runs for
+func (t *Tape) Length() uint64 {
+	return t.length
+}
+
+//Cassette the cassette port. Touching $C020 flips the output and $C060 bit 7 reads the input, the
+//input comes from a Tape played against the cycle count so a load runs the same every time
+type Cassette struct {
+	cpu *CPU
+	//Recorder hears every flip of the output, optional
+	Recorder CassetteRecorder
+	tape     *Tape
+	regs     CassetteState
+}
+
+//NewCassette a cassette port with no tape in it
+func NewCassette(c *CPU) *Cassette {
+	return &Cassette{cpu: c}
+}
+
+//Toggle flip the output
+func (c *Cassette) Toggle() {
+	c.regs.Output = !c.regs.Output
+	if c.Recorder != nil {
+		c.Recorder.Output(c.cpu.GetCycleCount(), c.regs.Output)
+	}
+}
+
+//Input the level on the input, low with no tape or past the end of it
+func (c *Cassette) Input() bool {
+	if c.tape == nil {
+		return false
+	}
+	pos := c.Position()
+	if pos >= c.tape.length {
+		return false
+	}
+	crossings := c.tape.crossings
+	n := sort.Search(len(crossings), func(i int) bool { return crossings[i] > pos })
+	return c.tape.level(n)
+}
+
+//Insert put t in the player stopped at the start, nil takes the tape out
+func (c *Cassette) Insert(t *Tape) {
+	c.tape = t
+	c.regs.Playing, c.regs.Position = false, 0
+}
+
+//Tape the tape in the player, nil if there isn't one
+func (c *Cassette) Tape() *Tape {
+	return c.tape
+}
+
+//Position how many cycles into the tape the player is
+func (c *Cassette) Position() uint64 {
+	if c.regs.Playing {
+		return c.regs.Position + c.cpu.GetCycleCount() - c.regs.Start
+	}
+	return c.regs.Position
+}
+
+//Playing is the tape running
+func (c *Cassette) Playing() bool {
+	return c.regs.Playing
+}
+
+//Play start the tape from where it is
+func (c *Cassette) Play() {
+	if !c.regs.Playing {
+		c.regs.Playing, c.regs.Start = true, c.cpu.GetCycleCount()
+	}
+}
+
+//Stop stop the tape where it is
+func (c *Cassette) Stop() {
+	c.regs.Position = c.Position()
+	c.regs.Playing = false
+}
+
+//Rewind go back to the start of the tape, it keeps playing if it was
+func (c *Cassette) Rewind() {
+	c.regs.Position, c.regs.Start = 0, c.cpu.GetCycleCount()
+}
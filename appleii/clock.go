@@ -0,0 +1,147 @@
+package appleii
+
+/* clock.go -- A ThunderClock compatible clock card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+//Clock card I/O registers, each is a trap from the ROM
+const (
+	clockMode   = 0x0 //Writing sets the mode the time is read in
+	clockRead   = 0x1 //Writing puts the time in the input buffer
+	clockLength = 0x2 //Reading gives the length of the time, the X register for the ROM
+)
+
+//Where things are in the firmware, the entry points at $Cn08 (read) and $Cn0B (write) jump to them
+const (
+	clockReadCode  = 0x10
+	clockWriteCode = 0x18
+)
+
+//clockProDOS the mode ProDOS reads the time in, numbers only
+const clockProDOS = '#' | 0x80
+
+//clockBuffer the GETLN input buffer the time is written to
+const clockBuffer = 0x200
+
+//ClockCard a ThunderClock compatible clock. The time is the Epoch plus the cycles the Apple has run,
+//so it stays in step with the emulation however fast that runs and comes out the same on replays.
+//In ProDOS mode ('#') reading the clock gives "mo,da,dt,hr,mn" (month, day of the week from
+//Sunday, date, hour and minute) at $200, in any other mode "MM/DD/YY HH:MM:SS"
+type ClockCard struct {
+	NoExpansionROM
+	bus  *Bus
+	rom  [256]uint8
+	regs ClockState
+}
+
+//NewClockCard a clock card for slot that reads epoch (seconds since 1970, local time) at cycle 0
+func NewClockCard(b *Bus, slot int, epoch int64) *ClockCard {
+	c := ClockCard{bus: b, regs: ClockState{Epoch: epoch, Mode: clockProDOS}}
+	n := uint8(slot)
+	io := 0x80 + n<<4 //Low byte of $C0n0
+	cn := 0xC0 + n    //High byte of $Cn00
+	rom := c.rom[:0]
+	//$Cn00=$08, $Cn02=$28, $Cn04=$58 and $Cn06=$70 is the signature ProDOS looks for
+	rom = append(rom,
+		0x08,       //PHP
+		0xA9, 0x28, //LDA #$28
+		0xA9, 0x58, //LDA #$58
+		0xA9, 0x70, //LDA #$70
+		0x28,                    //PLP
+		0x4C, clockReadCode, cn, //Read entry: JMP $Cn10
+		0x4C, clockWriteCode, cn) //Write entry: JMP $Cn18
+	for len(rom) < clockReadCode {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x8D, io+clockRead, 0xC0, //STA $C0n1
+		0xAE, io+clockLength, 0xC0, //LDX $C0n2
+		0x60) //RTS
+	for len(rom) < clockWriteCode {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x8D, io+clockMode, 0xC0, //STA $C0n0
+		0x60) //RTS
+	return &c
+}
+
+//Now the time on the clock
+func (c *ClockCard) Now() time.Time {
+	return time.Unix(c.regs.Epoch+int64(c.bus.Cycles()/ClockHz), 0).UTC()
+}
+
+//SetEpoch start the clock at epoch (seconds since 1970, local time) at cycle 0
+func (c *ClockCard) SetEpoch(epoch int64) {
+	c.regs.Epoch = epoch
+}
+
+//read put the time in the input buffer as high ASCII with a return on the end
+func (c *ClockCard) read() {
+	t := c.Now()
+	var s string
+	if c.regs.Mode == clockProDOS {
+		s = fmt.Sprintf("%02d,%02d,%02d,%02d,%02d", int(t.Month()), int(t.Weekday()), t.Day(), t.Hour(), t.Minute())
+	} else {
+		s = fmt.Sprintf("%02d/%02d/%02d %02d:%02d:%02d", int(t.Month()), t.Day(), t.Year()%100, t.Hour(), t.Minute(), t.Second())
+	}
+	for i := 0; i < len(s); i++ {
+		c.bus.Write(clockBuffer+uint16(i), s[i]|0x80)
+	}
+	c.bus.Write(clockBuffer+uint16(len(s)), 0x8D)
+	c.regs.Length = uint8(len(s))
+}
+
+//IO the ROM's traps
+func (c *ClockCard) IO(reg uint8, data uint8, read bool) uint8 {
+	switch {
+	case reg == clockLength && read:
+		return c.regs.Length
+	case reg == clockMode && !read:
+		c.regs.Mode = data | 0x80
+	case reg == clockRead && !read:
+		c.read()
+	}
+	return 0
+}
+
+//PeekIO the length of the time string, the traps only act on writes
+func (c *ClockCard) PeekIO(reg uint8) uint8 {
+	if reg == clockLength {
+		return c.regs.Length
+	}
+	return 0
+}
+
+//ROM the firmware
+func (c *ClockCard) ROM(offset uint8, data uint8, read bool) uint8 {
+	return c.rom[offset]
+}
+
+//PeekROM the firmware
+func (c *ClockCard) PeekROM(offset uint8) uint8 {
+	return c.rom[offset]
+}
+
+//Reset nothing to do, the clock keeps running
+func (c *ClockCard) Reset() {
+}
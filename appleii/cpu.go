@@ -199,6 +199,7 @@ type CPU struct {
 //NewCPU Creates a new 6502 and resets it
 func NewCPU(b *Bus) *CPU {
 	cpu := CPU{bus: b}
+	b.cpu = &cpu
 	cpu.jumpTable = [256]func(){
 		// 0        1        2        3        4        5        6         7       8        9        A        B        C        D        E        F
 		cpu.brk, cpu.ora, cpu.err, cpu.err, cpu.err, cpu.ora, cpu.asl, cpu.err, cpu.php, cpu.ora, cpu.aslA, cpu.err, cpu.err, cpu.ora, cpu.asl, cpu.err, //0
@@ -249,6 +250,25 @@ var savedOpcode uint8 = 0
 
 //Tick should be called for every clock cycle
 func (c *CPU) Tick() int {
+	//A card with the bus runs in the 6502's place, the 6502's interrupts wait for it to come back
+	if m := c.bus.master; m != nil {
+		cycles := m.Step()
+		c.cycleCount += uint64(cycles)
+		if c.cycleCount >= c.bus.nextTimer {
+			c.bus.runTimers(c.cycleCount)
+		}
+		return cycles
+	}
+
+	//An interrupt is taken between instructions
+	if c.bus.irq != 0 && c.regs.SR&flagI == 0 {
+		cycles := c.interrupt(vecIRQ)
+		if c.cycleCount >= c.bus.nextTimer {
+			c.bus.runTimers(c.cycleCount)
+		}
+		return cycles
+	}
+
 	//Fetch the next instruction
 	if doPrint {
 		c.printInstruction(c.regs.PC)
@@ -302,9 +322,23 @@ func (c *CPU) Tick() int {
 	savedOpcode = opcode
 	c.jumpTable[opcode]()
 
+	if c.cycleCount >= c.bus.nextTimer {
+		c.bus.runTimers(c.cycleCount)
+	}
+
 	return int(c.cycleCount - cycles)
 }
 
+//interrupt push the state and jump through vector, it takes 7 cycles like a BRK
+func (c *CPU) interrupt(vector uint16) int {
+	c.push16(c.regs.PC)
+	c.push8((c.regs.SR | flagUnused) & ^flagB)
+	c.regs.SR |= flagI
+	c.regs.PC = c.read16(vector)
+	c.cycleCount += 7
+	return 7
+}
+
 func pagesDiffer(a, b uint16) bool {
 	return a&0xFF00 != b&0xFF00
 }
@@ -333,13 +367,8 @@ func (c *CPU) printInstruction(aPC uint16) {
 
 func (c *CPU) read16(aAddr uint16) uint16 {
 	//Read 16bits from the BUS
-	addr := aAddr
-	read := true
-	c.bus.Set(&addr, nil, &read)
-	lo := uint16(c.bus.data)
-	addr++
-	c.bus.Set(&addr, nil, &read)
-	hi := uint16(c.bus.data)
+	lo := uint16(c.bus.Read(aAddr))
+	hi := uint16(c.bus.Read(aAddr + 1))
 	return hi<<8 | lo
 }
 
@@ -353,26 +382,18 @@ func (c *CPU) read16nowrap(aAddr uint16) uint16 {
 	}
 
 	//Read 16bits from the BUS
-	addr := aAddr
-	read := true
-	c.bus.Set(&addr, nil, &read)
-	lo := uint16(c.bus.data)
-	addr &= 0xFF00
-	c.bus.Set(&addr, nil, &read)
-	hi := uint16(c.bus.data)
+	lo := uint16(c.bus.Read(aAddr))
+	hi := uint16(c.bus.Read(aAddr & 0xFF00))
 	return hi<<8 | lo
 }
 
 func (c *CPU) read8(aAddr uint16) uint8 {
 	//Read 8bits from the BUS
-	read := true
-	c.bus.Set(&aAddr, nil, &read)
-	return c.bus.data
+	return c.bus.Read(aAddr)
 }
 
 func (c *CPU) write8(aAddr uint16, aData uint8) {
-	read := false
-	c.bus.Set(&aAddr, &aData, &read)
+	c.bus.Write(aAddr, aData)
 }
 
 func (c *CPU) write16(aAddr uint16, aData uint16) {
@@ -382,10 +403,7 @@ func (c *CPU) write16(aAddr uint16, aData uint16) {
 
 func (c *CPU) pull8() uint8 {
 	c.regs.SP++
-	addr := 0x100 | uint16(c.regs.SP)
-	read := true
-	c.bus.Set(&addr, nil, &read)
-	return c.bus.data
+	return c.bus.Read(0x100 | uint16(c.regs.SP))
 }
 
 func (c *CPU) pull16() uint16 {
@@ -400,9 +418,7 @@ func (c *CPU) push16(aVal uint16) {
 }
 
 func (c *CPU) push8(aVal uint8) {
-	addr := 0x100 | uint16(c.regs.SP)
-	read := false
-	c.bus.Set(&addr, &aVal, &read)
+	c.bus.Write(0x100|uint16(c.regs.SP), aVal)
 	c.regs.SP--
 }
 
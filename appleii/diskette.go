@@ -19,12 +19,15 @@ package appleii
 */
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 )
 
 //Diskette DOS 3.3 -- 35 Tracks/16 Sector
 type Diskette struct {
+	//Filename is the image file the diskette was loaded from
+	Filename string
 	//Tracks is the binary track data
 	Tracks [35][]uint8
 }
@@ -49,13 +52,22 @@ var volume uint8 = 254
 
 //NewDiskette loads a diskette into one of the drives
 func NewDiskette(filename string) *Diskette {
+	d, err := LoadDiskette(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d
+}
+
+//LoadDiskette loads a diskette image, returning an error instead of stopping the emulator
+func LoadDiskette(filename string) (*Diskette, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatal("Failed to load diskette!")
+		return nil, fmt.Errorf("Failed to load diskette: %v", err)
 	}
 
 	if len(data) != 143360 {
-		log.Fatalf("%s is not a valid Apple IIe diskette image", filename)
+		return nil, fmt.Errorf("%s is not a valid Apple IIe diskette image", filename)
 	}
 
 	gap1 := make([]byte, 0x30)
@@ -67,7 +79,7 @@ func NewDiskette(filename string) *Diskette {
 		gap2[i] = 0xff
 	}
 
-	d := Diskette{}
+	d := Diskette{Filename: filename}
 	for t := uint8(0); t < 35; t++ { //35 Tracks
 		for s := 15; s >= 0; s-- { //16 Sectors
 			_s := writeSectorOrder[s]
@@ -110,7 +122,7 @@ func NewDiskette(filename string) *Diskette {
 			d.Tracks[t] = append(d.Tracks[t], 0xDE, 0xAA, 0xEB)
 		}
 	}
-	return &d
+	return &d, nil
 }
 
 //Odd/Even encode a byte into two bytes
@@ -18,11 +18,18 @@ package appleii
    Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 */
 
-//Dsk ][ Controller
+import (
+	"io/ioutil"
+	"log"
+)
+
+//Dsk ][ Controller, a Card that normally goes in slot 6
 type Dsk struct {
+	NoExpansionROM
 	bus       *Bus
-	motorOn   bool //Get your motor running
-	drive2    bool //Selected drive
+	boot      []byte //Boot ROM at $Cn00
+	motorOn   bool   //Get your motor running
+	drive2    bool   //Selected drive
 	q6        bool
 	q7        bool
 	dataLatch uint8
@@ -31,12 +38,22 @@ type Dsk struct {
 	phase     int       //Current stepper motor phase
 	track     int       //Current track the head is on
 	pos       int       //Current position on the track
+	nibble    *Timer    //Next nibble coming off the diskette
 }
 
+//CyclesPerNibble a nibble passes under the head every 32 cycles (4us a bit)
+const CyclesPerNibble = 32
+
 //NewDsk create a new Disk ][ controller
 func NewDsk(b *Bus) *Dsk {
 	d := Dsk{bus: b}
-	d.bus.Add(&d, 0xC0E0, 0xC0EF)
+	d.nibble = b.NewTimer(d.nextNibble)
+
+	data, err := ioutil.ReadFile("./data/boot.bin")
+	if err != nil {
+		log.Fatal("Failed to load boot ROM")
+	}
+	d.boot = data
 
 	d.disk1 = NewDiskette("./disks/4.dsk")
 
@@ -50,6 +67,7 @@ func (d *Dsk) Reset() {
 	d.q7 = false
 	d.drive2 = false
 	d.motorOn = false
+	d.nibble.Cancel()
 	d.bus.SetFastMode(false)
 }
 
@@ -62,6 +80,40 @@ func (d *Dsk) GetLEDStatus(disk2 bool) bool {
 	return d.motorOn
 }
 
+//DiskPath the image file of the diskette in drive 1 (or drive 2 if drive 1 is empty)
+func (d *Dsk) DiskPath() string {
+	if d.disk1 != nil {
+		return d.disk1.Filename
+	}
+	if d.disk2 != nil {
+		return d.disk2.Filename
+	}
+	return ""
+}
+
+//Insert load the image filename into drive 1 or 2, replacing the diskette that was there
+func (d *Dsk) Insert(drive int, filename string) error {
+	disk, err := LoadDiskette(filename)
+	if err != nil {
+		return err
+	}
+	if drive == 2 {
+		d.disk2 = disk
+	} else {
+		d.disk1 = disk
+	}
+	return nil
+}
+
+//Eject take the diskette out of drive 1 or 2
+func (d *Dsk) Eject(drive int) {
+	if drive == 2 {
+		d.disk2 = nil
+	} else {
+		d.disk1 = nil
+	}
+}
+
 func (d *Dsk) phaseChange(newPhase int) {
 	if (d.phase == 1 && newPhase == 2) || (d.phase == 3 && newPhase == 0) {
 		//When we move from phase 1 to phase 2 we go up one track
@@ -79,11 +131,23 @@ func (d *Dsk) phaseChange(newPhase int) {
 	d.phase = newPhase
 }
 
+//nextNibble the diskette turns one nibble further while the motor runs
+func (d *Dsk) nextNibble() {
+	if !d.motorOn {
+		return
+	}
+	d.nibble.Schedule(d.nibble.Cycle() + CyclesPerNibble)
+	d.updateData()
+}
+
 func (d *Dsk) updateData() {
-	d.dataLatch = 0
-	if !d.q6 && !d.q7 && d.motorOn { //READ mode, fill the data latch
-		if !d.drive2 && d.disk1 != nil {
-			d.dataLatch = d.disk1.Tracks[d.track][d.pos]
+	if !d.q6 && !d.q7 { //READ mode, fill the data latch
+		disk := d.disk1
+		if d.drive2 {
+			disk = d.disk2
+		}
+		if disk != nil {
+			d.dataLatch = disk.Tracks[d.track][d.pos]
 			d.pos++
 			if d.pos == 6656 {
 				d.pos = 0
@@ -92,56 +156,82 @@ func (d *Dsk) updateData() {
 	}
 }
 
-func (d *Dsk) busUpdate() {
-	d.bus.data = 0
-	switch d.bus.addr {
-	case 0xC0E0:
+//ROM the boot ROM
+func (d *Dsk) ROM(offset uint8, data uint8, read bool) uint8 {
+	return d.boot[offset]
+}
+
+//PeekROM the boot ROM
+func (d *Dsk) PeekROM(offset uint8) uint8 {
+	return d.boot[offset]
+}
+
+//PeekIO the data latch where IO reads it, without moving the head, starting the motor or taking the nibble
+func (d *Dsk) PeekIO(reg uint8) uint8 {
+	switch reg {
+	case 0x2, 0x4, 0x6, 0x8, 0xA, 0xC:
+		return d.dataLatch
+	}
+	return 0
+}
+
+//IO the controller's soft switches
+func (d *Dsk) IO(reg uint8, data uint8, read bool) uint8 {
+	result := uint8(0)
+	switch reg {
+	case 0x0:
 		//I don't think I care about phases being turned off?
-	case 0xC0E1:
+	case 0x1:
 		d.phaseChange(0)
-	case 0xC0E2:
-		d.bus.data = d.dataLatch
+	case 0x2:
+		result = d.dataLatch
 		//I don't think I care about phases being turned off?
-	case 0xC0E3:
+	case 0x3:
 		d.phaseChange(1)
-	case 0xC0E4:
-		d.bus.data = d.dataLatch
+	case 0x4:
+		result = d.dataLatch
 		//I don't think I care about phases being turned off?
-	case 0xC0E5:
+	case 0x5:
 		d.phaseChange(2)
-	case 0xC0E6:
-		d.bus.data = d.dataLatch
+	case 0x6:
+		result = d.dataLatch
 		//I don't think I care about phases being turned off?
-	case 0xC0E7:
+	case 0x7:
 		d.phaseChange(3)
-	case 0xC0E8:
+	case 0x8:
 		//fmt.Printf("MOTOR IS OFF (T:%d S:%d P:%d)\n", d.track, GetSector(d.pos), d.pos)
 		d.bus.SetFastMode(false)
-		d.bus.data = d.dataLatch
+		result = d.dataLatch
 		d.motorOn = false
-	case 0xC0E9:
+		d.nibble.Cancel()
+	case 0x9:
 		//fmt.Println("TURN THAT MOTOR ON!")
 		if d.disk1 != nil || d.disk2 != nil {
 			d.bus.SetFastMode(true)
 		}
 		d.motorOn = true
-	case 0xC0EA:
+		if !d.nibble.Pending() {
+			d.nibble.Schedule(d.bus.Cycles() + CyclesPerNibble)
+		}
+	case 0xA:
 		//fmt.Println("SLECT DRIVE 1")
-		d.bus.data = d.dataLatch
+		result = d.dataLatch
 		d.drive2 = false
-	case 0xC0EB:
+	case 0xB:
 		//fmt.Println("SLECT DRIVE 2")
 		d.drive2 = true
-	case 0xC0EC:
+	case 0xC:
 		//fmt.Printf("READ BYTE 0x%x\n", d.dataLatch)
-		d.bus.data = d.dataLatch
-		d.updateData()
+		//Reading takes the nibble, the latch reads clear until the next one is shifted in
+		result = d.dataLatch
+		d.dataLatch = 0
 		d.q6 = false
-	case 0xC0ED:
+	case 0xD:
 		d.q6 = true
-	case 0xC0EE:
+	case 0xE:
 		d.q7 = false
-	case 0xC0EF:
+	case 0xF:
 		d.q7 = true
 	}
+	return result
 }
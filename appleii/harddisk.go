@@ -0,0 +1,342 @@
+package appleii
+
+/* harddisk.go -- A ProDOS block device and SmartPort card for hard disk volumes
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "log"
+
+//Where things are in the card's ROM
+const (
+	hdBoot        = 0x08 //Boot code, after the signature
+	hdProDOSEntry = 0x30 //ProDOS block driver, $CnFF points here
+	hdSmartPort   = 0x33 //SmartPort dispatch, always 3 bytes after the ProDOS entry
+	hdCall        = 0x40 //Hands a ProDOS call to the card
+	hdReturn      = 0x43 //Picks up the result
+)
+
+//Card I/O registers
+const (
+	hdRegProDOS    = 0x0 //Writing runs the ProDOS call in $42-$47
+	hdRegError     = 0x1 //Error code of the last call, 0 is success
+	hdRegX         = 0x2 //X to return with
+	hdRegY         = 0x3 //Y to return with
+	hdRegSmartPort = 0x5 //Writing the stack pointer runs the SmartPort call after the JSR
+)
+
+//ProDOS block device commands, SmartPort calls use the same numbers for the first four
+const (
+	hdStatus = iota
+	hdRead
+	hdWrite
+	hdFormat
+	hdControl
+	hdInit
+)
+
+//ProDOS and SmartPort error codes
+const (
+	hdOK          = 0x00
+	hdBadCommand  = 0x01
+	hdBadParams   = 0x04 //Wrong parameter count
+	hdBadUnit     = 0x11
+	hdBadCode     = 0x21 //Unknown status or control code
+	hdIOError     = 0x27
+	hdNoDevice    = 0x28
+	hdWriteProt   = 0x2B
+	hdBadBlock    = 0x2D
+	hdExtended    = 0x40 //SmartPort command bit for calls with 4 byte pointers
+	hdNoInterrupt = 0x40 //SmartPort status, the card doesn't interrupt
+)
+
+//hdParams the parameter count each SmartPort call must have
+var hdParams = map[uint8]uint8{hdStatus: 3, hdRead: 3, hdWrite: 3, hdFormat: 1, hdControl: 3, hdInit: 1}
+
+//HardDisk a block device card with one volume per unit. ProDOS sees the first two units as drives 1
+//and 2, SmartPort calls reach all of them. The ROM traps each call to the card by writing one of
+//its I/O registers, the card does the work and the ROM returns what it says
+type HardDisk struct {
+	NoExpansionROM
+	bus   *Bus
+	slot  int
+	rom   [256]uint8
+	units []*Volume
+	regs  HardDiskState
+}
+
+//NewHardDisk a hard disk card for slot with no volumes
+func NewHardDisk(b *Bus, slot int) *HardDisk {
+	hd := HardDisk{bus: b, slot: slot}
+	hd.makeROM()
+	return &hd
+}
+
+//Attach add a volume as the next unit
+func (hd *HardDisk) Attach(v *Volume) {
+	hd.units = append(hd.units, v)
+	hd.makeROM()
+}
+
+//Units the volumes on the card, unit 1 first
+func (hd *HardDisk) Units() []*Volume {
+	return hd.units
+}
+
+//makeROM write the firmware for the card's slot
+func (hd *HardDisk) makeROM() {
+	n := uint8(hd.slot)
+	io := 0x80 + n<<4 //Low byte of $C0n0
+	cn := 0xC0 + n    //High byte of $Cn00
+	rom := hd.rom[:0]
+	//Signature, read by the autostart ROM and ProDOS, $Cn07=$00 is a SmartPort
+	rom = append(rom, 0xA2, 0x20, 0xA0, 0x00, 0xA2, 0x03, 0xA9, 0x00)
+	//Boot: read block 0 of drive 1 into $800 and run it with X=$n0
+	rom = append(rom,
+		0xA9, hdRead, 0x85, 0x42, //LDA #READ, STA $42
+		0xA9, n<<4, 0x85, 0x43, //LDA #$n0, STA $43
+		0xA9, 0x00, 0x85, 0x44, //LDA #$00, STA $44
+		0x85, 0x46, 0x85, 0x47, //STA $46, STA $47
+		0xA9, 0x08, 0x85, 0x45, //LDA #$08, STA $45
+		0x20, hdProDOSEntry, cn, //JSR $Cn30
+		0xB0, 0x05, //BCS fail
+		0xA2, n<<4, //LDX #$n0
+		0x4C, 0x01, 0x08, //JMP $0801
+		0x4C, 0x00, 0xE0) //fail: JMP $E000
+	for len(rom) < hdProDOSEntry {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x4C, hdCall, cn, //JMP $Cn40
+		0xBA,                          //SmartPort: TSX
+		0x8E, io+hdRegSmartPort, 0xC0, //STX $C0n5
+		0x4C, hdReturn, cn) //JMP $Cn43
+	for len(rom) < hdCall {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x8D, io+hdRegProDOS, 0xC0, //STA $C0n0
+		0xAE, io+hdRegX, 0xC0, //LDX $C0n2
+		0xAC, io+hdRegY, 0xC0, //LDY $C0n3
+		0xAD, io+hdRegError, 0xC0, //LDA $C0n1
+		0xC9, 0x01, //CMP #$01, carry set on an error
+		0x60) //RTS
+	for len(rom) < 0xFC {
+		rom = append(rom, 0)
+	}
+	//Block count (0 asks ProDOS to use STATUS), status/read/write/format and the number of volumes
+	status := uint8(0x0F)
+	if len(hd.units) > 1 {
+		status |= 0x10
+	}
+	rom = append(rom, 0x00, 0x00, status, hdProDOSEntry)
+	if len(rom) != len(hd.rom) {
+		log.Fatalf("Hard disk ROM is %d bytes", len(rom))
+	}
+}
+
+//Reset nothing happens to the card
+func (hd *HardDisk) Reset() {
+}
+
+//ROM the firmware
+func (hd *HardDisk) ROM(offset uint8, data uint8, read bool) uint8 {
+	return hd.rom[offset]
+}
+
+//PeekROM the firmware
+func (hd *HardDisk) PeekROM(offset uint8) uint8 {
+	return hd.rom[offset]
+}
+
+//PeekIO the results of the last call, calls are only made by writes
+func (hd *HardDisk) PeekIO(reg uint8) uint8 {
+	return hd.IO(reg, 0, true)
+}
+
+//IO the registers the firmware traps calls with
+func (hd *HardDisk) IO(reg uint8, data uint8, read bool) uint8 {
+	switch {
+	case reg == hdRegProDOS && !read:
+		hd.prodos()
+	case reg == hdRegSmartPort && !read:
+		hd.smartPort(data)
+	case reg == hdRegError:
+		return hd.regs.Error
+	case reg == hdRegX:
+		return hd.regs.X
+	case reg == hdRegY:
+		return hd.regs.Y
+	}
+	return 0
+}
+
+//prodos a ProDOS block device call, the command, unit, buffer and block are at $42-$47
+func (hd *HardDisk) prodos() {
+	cmd, unit := hd.bus.Peek(0x42), hd.bus.Peek(0x43)
+	buf, block := hd.peek16(0x44), int(hd.peek16(0x46))
+	hd.regs = HardDiskState{}
+	drive := int(unit >> 7)
+	if drive >= len(hd.units) {
+		hd.regs.Error = hdNoDevice
+		return
+	}
+	v := hd.units[drive]
+	switch cmd {
+	case hdStatus:
+		if v.ReadOnly {
+			hd.regs.Error = hdWriteProt
+		}
+		hd.regs.X, hd.regs.Y = uint8(v.Blocks()), uint8(v.Blocks()>>8)
+	case hdRead, hdWrite:
+		hd.regs.Error = hd.transfer(v, cmd, buf, block)
+	case hdFormat:
+		if v.ReadOnly {
+			hd.regs.Error = hdWriteProt
+		}
+	default:
+		hd.regs.Error = hdIOError
+	}
+}
+
+//smartPort a SmartPort call, the JSR's return address on the stack at sp points just before the
+//command byte and the parameter list pointer. The return address is moved past them
+func (hd *HardDisk) smartPort(sp uint8) {
+	stack := 0x100 + uint16(sp)
+	ret := hd.peek16(stack + 1)
+	cmd := hd.bus.Peek(ret + 1)
+	params := hd.peek16(ret + 2)
+	skip := uint16(3)
+	if cmd&hdExtended != 0 {
+		skip = 5 //4 byte pointer, the top half is 0 on a IIe
+	}
+	ret += skip
+	hd.bus.Write(stack+1, uint8(ret))
+	hd.bus.Write(stack+2, uint8(ret>>8))
+
+	hd.regs = HardDiskState{}
+	hd.regs.Error = hd.smartPortCall(cmd, params)
+}
+
+//smartPortCall run a SmartPort command with its parameter list, returning the error code
+func (hd *HardDisk) smartPortCall(cmd uint8, params uint16) uint8 {
+	ext := cmd&hdExtended != 0
+	cmd &^= hdExtended
+	count, ok := hdParams[cmd]
+	if !ok {
+		return hdBadCommand
+	}
+	if hd.bus.Peek(params) != count {
+		return hdBadParams
+	}
+	unit := int(hd.bus.Peek(params + 1))
+	if unit == 0 && cmd == hdStatus {
+		//The SmartPort itself, the only status it has is how many units there are
+		return hd.statusList(params, []uint8{uint8(len(hd.units)), hdNoInterrupt, 0, 0, 0, 0, 0, 0})
+	}
+	if unit < 1 || unit > len(hd.units) {
+		return hdBadUnit
+	}
+	v := hd.units[unit-1]
+
+	//Pointers are 2 bytes, or 4 in extended calls
+	ptr, block := hd.peek16(params+2), int(hd.peek16(params+4))|int(hd.bus.Peek(params+6))<<16
+	if ext {
+		block = int(hd.peek16(params+6)) | int(hd.peek16(params+8))<<16
+	}
+	switch cmd {
+	case hdStatus:
+		code := hd.bus.Peek(params + 4)
+		if ext {
+			code = hd.bus.Peek(params + 6)
+		}
+		return hd.status(v, params, code)
+	case hdRead, hdWrite:
+		return hd.transfer(v, cmd, ptr, block)
+	case hdFormat:
+		if v.ReadOnly {
+			return hdWriteProt
+		}
+	}
+	//Control and init have nothing to do
+	return hdOK
+}
+
+//status answer a SmartPort status call for v, code 0 is the device status and 3 the device information block
+func (hd *HardDisk) status(v *Volume, params uint16, code uint8) uint8 {
+	flags := uint8(0xF8) //Block device, writable, readable, online, formattable
+	if v.ReadOnly {
+		flags = 0xB4 //Write protected
+	}
+	blocks := []uint8{uint8(v.Blocks()), uint8(v.Blocks() >> 8), uint8(v.Blocks() >> 16)}
+	switch code {
+	case 0:
+		return hd.statusList(params, append([]uint8{flags}, blocks...))
+	case 3:
+		dib := append([]uint8{flags}, blocks...)
+		name := []byte("HARD DISK       ")
+		dib = append(dib, uint8(len("HARD DISK")))
+		dib = append(dib, name...)
+		dib = append(dib, 0x02, 0x00, 0x01, 0x00) //Hard disk, not removable, version 1.0
+		return hd.statusList(params, dib)
+	}
+	return hdBadCode
+}
+
+//statusList copy a status call's answer to its status list, X and Y say how long it is
+func (hd *HardDisk) statusList(params uint16, list []uint8) uint8 {
+	addr := hd.peek16(params + 2)
+	for i, b := range list {
+		hd.bus.Write(addr+uint16(i), b)
+	}
+	hd.regs.X, hd.regs.Y = uint8(len(list)), uint8(len(list)>>8)
+	return hdOK
+}
+
+//transfer read or write one block between v and memory at buf
+func (hd *HardDisk) transfer(v *Volume, cmd uint8, buf uint16, block int) uint8 {
+	if block >= v.Blocks() {
+		return hdBadBlock
+	}
+	data := make([]byte, BlockSize)
+	if cmd == hdRead {
+		if err := v.ReadBlock(block, data); err != nil {
+			log.Printf("Hard disk read failed: %v", err)
+			return hdIOError
+		}
+		for i, b := range data {
+			hd.bus.Write(buf+uint16(i), b)
+		}
+		hd.regs.X, hd.regs.Y = uint8(BlockSize&0xFF), uint8(BlockSize>>8)
+		return hdOK
+	}
+	if v.ReadOnly {
+		return hdWriteProt
+	}
+	for i := range data {
+		data[i] = hd.bus.Peek(buf + uint16(i))
+	}
+	if err := v.WriteBlock(block, data); err != nil {
+		log.Printf("Hard disk write failed: %v", err)
+		return hdIOError
+	}
+	hd.regs.X, hd.regs.Y = uint8(BlockSize&0xFF), uint8(BlockSize>>8)
+	return hdOK
+}
+
+func (hd *HardDisk) peek16(addr uint16) uint16 {
+	return uint16(hd.bus.Peek(addr)) | uint16(hd.bus.Peek(addr+1))<<8
+}
@@ -18,11 +18,19 @@ package appleii
    Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 */
 
+import "strings"
+
 //Kbd is the keyboard object for the apple ii
 type Kbd struct {
-	mem      *Mem
-	cpu      *CPU
-	ctrlDown bool
+	mem       *Mem
+	cpu       *CPU
+	ctrlDown  bool
+	paste     []byte //Pasted text waiting to be typed
+	lastPaste uint64 //Cycle count the last pasted character was typed
+	//PasteDelay the least number of cycles between pasted characters, 0 types as fast as the software reads them
+	PasteDelay uint64
+	//Uppercase paste letters as capitals for II+ software that doesn't understand lowercase
+	Uppercase bool
 }
 
 //SysKey is a system key
@@ -59,6 +67,7 @@ const (
 //NewKbd create a new keyboard object
 func NewKbd(m *Mem, c *CPU) *Kbd {
 	k := Kbd{mem: m, cpu: c}
+	m.OnKeyPoll = k.typePasted
 	return &k
 }
 
@@ -113,3 +122,38 @@ func (k *Kbd) SysKeyUp(key SysKey) {
 		k.ctrlDown = false
 	}
 }
+
+//Paste queue text to be typed, one character each time the software has taken the last one
+func (k *Kbd) Paste(text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	if k.Uppercase {
+		text = strings.ToUpper(text)
+	}
+	for _, r := range text {
+		//The Apple only has 7 bit ASCII
+		if r < 0x80 {
+			k.paste = append(k.paste, byte(r))
+		}
+	}
+}
+
+//Pasting is there pasted text still waiting to be typed
+func (k *Kbd) Pasting() bool {
+	return len(k.paste) > 0
+}
+
+//CancelPaste throw away any pasted text that hasn't been typed yet
+func (k *Kbd) CancelPaste() {
+	k.paste = nil
+}
+
+//typePasted type the next pasted character, called when the software polls the keyboard with the strobe clear
+func (k *Kbd) typePasted() {
+	if len(k.paste) == 0 || k.cpu.GetCycleCount()-k.lastPaste < k.PasteDelay {
+		return
+	}
+	k.lastPaste = k.cpu.GetCycleCount()
+	k.KeyType(int(k.paste[0]))
+	k.paste = k.paste[1:]
+}
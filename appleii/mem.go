@@ -23,17 +23,36 @@ import (
 	"log"
 )
 
+//MaxAuxBanks the most 64k aux banks a RamWorks style card can have, 8MB
+const MaxAuxBanks = 128
+
+//Video scanner timing, the IIe draws 262 lines of 65 cycles every frame
+//and the last 70 of those lines are the vertical blank
+const (
+	CyclesPerLine  = 65
+	CyclesPerFrame = 262 * CyclesPerLine //17030
+	vblStart       = 192 * CyclesPerLine //VBL is the last 4550 cycles of the frame
+)
+
 //Mem is the AppleIIe memory space with extendend 80COL card...
 type Mem struct {
-	mem           []byte //64k Main Memory
-	aux           []byte //64k Aux Memory
-	rom           []byte //ROM from $C000-$FFFF
-	boot          []byte //Disk ][ boot rom
+	mem           []byte   //64k Main Memory
+	aux           []byte   //64k Aux Memory, the bank the CPU sees
+	auxBanks      [][]byte //Every 64k aux bank (RamWorks), the video always shows bank 0
+	auxBank       int      //Bank selected by writing $C071 or $C073
+	rom           []byte   //ROM from $C000-$FFFF
 	bus           *Bus
 	cpu           *CPU
 	keyboardLatch uint8
 	preWrite      bool
-	savedCycles   uint64 //Save the cycle count on a PTRIG
+	dirty         [4]uint32 //Text rows written since the last frame for TEXT1, TEXT2, HGR1 and HGR2
+	lastMode      uint16    //Video soft switches as of the last frame
+	slots         [8]Card   //Peripheral cards, slot 0 is unused
+	expansion     int       //Slot of the card that has the $C800 expansion ROM, 0 for none
+	intC8ROM      bool      //The internal ROM has $C800 because the 80 column firmware was called
+	banks         uint32    //Banking soft switches and aux bank the bus page table was last mapped for
+	//saturn the Saturn card, it takes $D000-$FFFF from the language card while it's switched in
+	saturn *SaturnCard
 	//MAIN/AUX is $0200 to $BFFF
 	RDMAIN bool //True: Read from main, False: Read from aux
 	WRMAIN bool //True: Write main, False: Write aux
@@ -51,7 +70,6 @@ type Mem struct {
 	ALTCHAR  bool //Alternate character ROM?
 	TEXT     bool //Text mode or Graphics Mode
 	MIXED    bool //Split screen
-	VBLANK   bool //VBlank
 	DBLHIRES bool //Double hi resolution Graphics Mode
 	//SLOT FLAGS
 	INTCXROM  bool
@@ -60,11 +78,25 @@ type Mem struct {
 	KBDOAPPLE bool //Open Apple key
 	KBDFAPPLE bool //Filled Apple key
 	KBDSHIFT  bool //Shift key
+	//Paddles the game port, the push buttons share lines with the apple keys
+	Paddles *Paddles
+	//Cassette the cassette port
+	Cassette *Cassette
+	//OnKeyPoll is called when software reads the keyboard after clearing the strobe, optional (Used to paste text)
+	OnKeyPoll func()
+	//OnVBL is called when the scanner enters VBL, optional (Used by the mouse card VBL interrupt)
+	OnVBL func()
+	vbl   *Timer //Scanner entering VBL
 }
 
 //NewMem Creates a new RAM object (64k)
 func NewMem(b *Bus, c *CPU) *Mem {
 	m := Mem{bus: b, mem: make([]byte, 65536), aux: make([]byte, 65536), cpu: c, RDMAIN: true, WRMAIN: true, MAINZP: true}
+	m.Paddles = NewPaddles(b, c)
+	m.Cassette = NewCassette(c)
+	m.vbl = b.NewTimer(m.enterVBL)
+	m.vbl.Schedule(c.GetCycleCount()/CyclesPerFrame*CyclesPerFrame + vblStart)
+	m.banks = ^uint32(0)
 
 	for i := 0; i < 65536; i += 4 {
 		m.mem[i] = 0xFF
@@ -82,14 +114,45 @@ func NewMem(b *Bus, c *CPU) *Mem {
 		log.Fatal("Failed to load system ROM")
 	}
 	m.rom = data
+	m.auxBanks = [][]byte{m.aux}
 
-	data, err = ioutil.ReadFile("./data/boot.bin")
-	if err != nil {
-		log.Fatal("Failed to load boot ROM")
+	return &m
+}
+
+//SetAuxBanks fit a RamWorks style card with n 64k banks (1-128) of aux memory, bank 0 is the
+//one that was there already
+func (m *Mem) SetAuxBanks(n int) {
+	if n < 1 || n > MaxAuxBanks {
+		log.Fatalf("Aux memory can't have %d banks", n)
 	}
-	m.boot = data
+	for len(m.auxBanks) < n {
+		bank := make([]byte, 65536)
+		for i := range bank {
+			bank[i] = 0xFF
+		}
+		m.auxBanks = append(m.auxBanks, bank)
+	}
+	m.auxBanks = m.auxBanks[:n]
+	m.selectAuxBank(0)
+	m.remap()
+}
 
-	return &m
+//AuxBanks the number of 64k aux banks
+func (m *Mem) AuxBanks() int {
+	return len(m.auxBanks)
+}
+
+//selectAuxBank give the CPU aux bank n, a bank past the last one wraps around as it would with
+//the address lines of the missing chips not decoded
+func (m *Mem) selectAuxBank(n uint8) {
+	m.auxBank = int(n) % len(m.auxBanks)
+	m.aux = m.auxBanks[m.auxBank]
+}
+
+//cardSwitched remap after a card changed what is at $D000-$FFFF
+func (m *Mem) cardSwitched() {
+	m.banks = ^uint32(0)
+	m.remap()
 }
 
 //Reset the soft switches back to boot up
@@ -111,11 +174,144 @@ func (m *Mem) Reset() {
 	m.SLOTC3ROM = false
 	m.DBLHIRES = false
 	m.preWrite = false
+	m.selectAuxBank(0)
+	m.remap()
+	m.expansion = 0
+	m.intC8ROM = false
+	for _, c := range m.slots {
+		if c != nil {
+			c.Reset()
+		}
+	}
+}
+
+//AllRows is a dirty mask with every text row set
+const AllRows = 1<<24 - 1
+
+//displayRow which of the 24 text rows a display memory address lands on, -1 for the screen holes
+func displayRow(addr uint16) int {
+	offset := addr & 0x3FF
+	col := offset & 0x7F
+	if col >= 0x78 {
+		return -1
+	}
+	return int(col/40)*8 + int(offset>>7)
 }
 
-//GetGPUMemory get a pointer to the memory of current GPU page
-func (m *Mem) GetGPUMemory() ([]uint8, []uint8, uint16, bool, bool, bool, bool, bool) {
+//markDirty remember which display row a RAM write changed
+func (m *Mem) markDirty(addr uint16) {
+	page := -1
+	switch {
+	case addr >= 0x400 && addr <= 0x7FF:
+		page = 0
+	case addr >= 0x800 && addr <= 0xBFF:
+		page = 1
+	case addr >= 0x2000 && addr <= 0x3FFF:
+		page = 2
+	case addr >= 0x4000 && addr <= 0x5FFF:
+		page = 3
+	}
+	if page >= 0 {
+		if row := displayRow(addr); row >= 0 {
+			m.dirty[page] |= 1 << uint(row)
+		}
+	}
+}
+
+//bankMode the soft switches that decide where RAM and ROM are, and the aux bank
+func (m *Mem) bankMode() uint32 {
+	mode := uint32(m.auxBank) << 9
+	for i, f := range []bool{m.MAINZP, m.RDMAIN, m.WRMAIN, m.STORE80, m.PAGE2, m.HIRES, m.LCBNK2, m.LCRAM, m.LCWRITE} {
+		if f {
+			mode |= 1 << uint(i)
+		}
+	}
+	return mode
+}
+
+//remap point the bus page table at the RAM and ROM the soft switches select, the pages left out
+//are dispatched to Mem.Read and Mem.Write, which do the same thing one access at a time
+func (m *Mem) remap() {
+	mode := m.bankMode()
+	if mode == m.banks {
+		return
+	}
+	m.banks = mode
+
+	page := func(mem []byte, p int) []uint8 {
+		return mem[p<<8 : p<<8+0x100]
+	}
+	bank := func(main bool) []byte {
+		if main {
+			return m.mem
+		}
+		return m.aux
+	}
+
+	for p := 0; p < 0x100; p++ {
+		var read, write []uint8
+		switch {
+		case p <= 0x01:
+			read = page(bank(m.MAINZP), p)
+			write = read
+		case p <= 0xBF:
+			rd, wr := bank(m.RDMAIN), bank(m.WRMAIN)
+			if m.STORE80 && (p >= 0x04 && p <= 0x07 || m.HIRES && p >= 0x20 && p <= 0x3F) {
+				rd, wr = bank(!m.PAGE2), bank(!m.PAGE2)
+			}
+			read = page(rd, p)
+			//Writes to the display pages are dispatched to Mem.Write so the video knows what changed
+			if !(p >= 0x04 && p <= 0x0B || p >= 0x20 && p <= 0x5F) {
+				write = page(wr, p)
+			}
+		case p <= 0xCF:
+			//I/O and slots are always dispatched to Mem.Read and Mem.Write
+		default:
+			ram := page(bank(m.MAINZP), p)
+			if p <= 0xDF && !m.LCBNK2 {
+				ram = page(bank(m.MAINZP), p-0x10)
+			}
+			if m.LCRAM {
+				read = ram
+			} else {
+				read = m.rom[(p-0xC0)<<8 : (p-0xC0)<<8+0x100]
+			}
+			if m.LCWRITE {
+				write = ram
+			}
+			if m.saturn != nil {
+				rd, wr := m.saturn.page(uint8(p))
+				if rd != nil {
+					read = rd
+				}
+				if wr != nil {
+					write = wr
+				}
+			}
+		}
+		m.bus.MapPage(uint8(p), read, write)
+	}
+}
+
+func (m *Mem) videoMode() uint16 {
+	mode := uint16(0)
+	for i, f := range []bool{m.TEXT, m.HIRES, m.VID80, m.MIXED, m.DBLHIRES, m.PAGE2, m.STORE80, m.ALTCHAR, m.RDMAIN} {
+		if f {
+			mode |= 1 << uint(i)
+		}
+	}
+	return mode
+}
+
+//GetGPUMemory get a pointer to the memory of current GPU page, the last value is a mask of the
+//text rows (bit 0 is the top row) that changed since the last call
+func (m *Mem) GetGPUMemory() ([]uint8, []uint8, uint16, bool, bool, bool, bool, bool, uint32) {
 	addr := uint16(0x400)
+	page := 0
+	if m.PAGE2 && !m.STORE80 {
+		page = 1
+	}
+	dirty := m.dirty[page]
 	//Text and lowres mode
 	if m.TEXT {
 		if m.PAGE2 && !m.STORE80 {
@@ -126,106 +322,99 @@ func (m *Mem) GetGPUMemory() ([]uint8, []uint8, uint16, bool, bool, bool, bool,
 		if m.PAGE2 && !m.STORE80 {
 			addr = 0x4000
 		}
+		dirty |= m.dirty[page+2]
+	}
+	if mode := m.videoMode(); mode != m.lastMode {
+		//Switching modes redraws the whole screen
+		m.lastMode = mode
+		dirty = AllRows
 	}
+	m.dirty = [4]uint32{}
 
 	if !m.VID80 && (!m.RDMAIN || (m.PAGE2 && m.STORE80)) {
-		return m.aux, m.mem, addr, m.TEXT, m.HIRES, m.VID80, m.MIXED, m.DBLHIRES
+		return m.auxBanks[0], m.mem, addr, m.TEXT, m.HIRES, m.VID80, m.MIXED, m.DBLHIRES, dirty
+	}
+
+	return m.mem, m.auxBanks[0], addr, m.TEXT, m.HIRES, m.VID80, m.MIXED, m.DBLHIRES, dirty
+}
+
+//TextScreen the 24 lines of the current text page as ASCII, 80 columns wide in 80 column mode,
+//inverse and flashing characters come out as plain ones and MouseText as '?'
+func (m *Mem) TextScreen() []string {
+	base := uint16(0x400)
+	if m.PAGE2 && !m.STORE80 {
+		base = 0x800
+	}
+	lines := make([]string, 24)
+	for row := range lines {
+		addr := base + uint16(row%8)*0x80 + uint16(row/8)*40
+		var line []byte
+		for col := uint16(0); col < 40; col++ {
+			if m.VID80 {
+				line = append(line, m.textChar(m.auxBanks[0][addr+col]))
+			}
+			line = append(line, m.textChar(m.mem[addr+col]))
+		}
+		lines[row] = string(line)
+	}
+	return lines
+}
+
+//textChar the ASCII character a text screen byte shows
+func (m *Mem) textChar(b uint8) byte {
+	if b < 0x80 {
+		if m.ALTCHAR && b >= 0x40 && b < 0x60 {
+			return '?'
+		}
+		b &= 0x3F
+	}
+	b &= 0x7F
+	if b < 0x20 {
+		b += 0x40
 	}
+	return b
+}
+
+//InVBL is the video scanner in the vertical blank?
+func (m *Mem) InVBL() bool {
+	return m.cpu.GetCycleCount()%CyclesPerFrame >= vblStart
+}
+
+//enterVBL the scanner reached the bottom of the screen, it gets there again one frame later
+func (m *Mem) enterVBL() {
+	m.vbl.Schedule(m.vbl.Cycle() + CyclesPerFrame)
+	if m.OnVBL != nil {
+		m.OnVBL()
+	}
+}
+
+//RunFrame runs the CPU until the end of the current video frame
+func (m *Mem) RunFrame() {
+	end := (m.cpu.GetCycleCount()/CyclesPerFrame + 1) * CyclesPerFrame
+	for m.cpu.GetCycleCount() < end {
+		m.cpu.Tick()
+	}
+}
 
-	return m.mem, m.aux, addr, m.TEXT, m.HIRES, m.VID80, m.MIXED, m.DBLHIRES
+//doLCBankSwitch $C080-$C08F, the //e's own language card
+func (m *Mem) doLCBankSwitch(addr uint16, aRead bool) {
+	lcSwitch(uint8(addr&0xF), aRead, &m.LCBNK2, &m.LCRAM, &m.LCWRITE, &m.preWrite)
 }
 
-func (m *Mem) doLCBankSwitch(aRead bool) {
-	switch m.bus.addr {
-	case 0xC080:
-		m.LCBNK2 = true
-		m.LCRAM = true
-		m.LCWRITE = false
-	case 0xC081:
-		m.LCBNK2 = true
-		m.LCRAM = false
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC082:
-		m.LCBNK2 = true
-		m.LCRAM = false
-		m.LCWRITE = false
-	case 0xC083:
-		m.LCBNK2 = true
-		m.LCRAM = true
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC084:
-		m.LCBNK2 = true
-		m.LCRAM = true
-		m.LCWRITE = false
-	case 0xC085:
-		m.LCBNK2 = true
-		m.LCRAM = false
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC086:
-		m.LCBNK2 = true
-		m.LCRAM = false
-		m.LCWRITE = false
-	case 0xC087:
-		m.LCBNK2 = true
-		m.LCRAM = true
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC088:
-		m.LCBNK2 = false
-		m.LCRAM = true
-		m.LCWRITE = false
-	case 0xC089:
-		m.LCBNK2 = false
-		m.LCRAM = false
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC08A:
-		m.LCBNK2 = false
-		m.LCRAM = false
-		m.LCWRITE = false
-	case 0xC08B:
-		m.LCBNK2 = false
-		m.LCRAM = true
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC08C:
-		m.LCBNK2 = false
-		m.LCRAM = true
-		m.LCWRITE = false
-	case 0xC08D:
-		m.LCBNK2 = false
-		m.LCRAM = false
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
-	case 0xC08E:
-		m.LCBNK2 = false
-		m.LCRAM = false
-		m.LCWRITE = false
-	case 0xC08F:
-		m.LCBNK2 = false
-		m.LCRAM = true
-		if aRead {
-			m.LCWRITE = m.preWrite
-		}
-		m.preWrite = aRead
+//lcSwitch the language card soft switches, shared by the //e's own language card and the ones in
+//slots. Bit 3 of reg picks the 4k bank at $D000, bits 0 and 1 pick RAM or ROM to read from and an
+//odd reg read twice in a row turns on writing to RAM
+func lcSwitch(reg uint8, aRead bool, bnk2, ram, write, preWrite *bool) {
+	*bnk2 = reg&0x8 == 0
+	*ram = reg&0x3 == 0x0 || reg&0x3 == 0x3
+	if reg&0x1 == 0 {
+		*write = false
+		return
 	}
+	if aRead {
+		*write = *preWrite
+	}
+	*preWrite = aRead
 }
 
 //SysKeyDown tell the memory a key has been pressed
@@ -260,13 +449,17 @@ func (m *Mem) KeyDown(key int) {
 	}
 }
 
-func (m *Mem) ioRW(aRead bool) uint8 {
-	if m.bus.addr >= 0xC080 && m.bus.addr <= 0xC08F {
-		m.doLCBankSwitch(aRead)
+func (m *Mem) ioRW(addr uint16, aRead bool) uint8 {
+	if addr&0xFFF0 == 0xC020 {
+		//Reading or writing anywhere in $C020-$C02F flips the cassette output
+		m.Cassette.Toggle()
+	}
+	if addr >= 0xC080 && addr <= 0xC08F {
+		m.doLCBankSwitch(addr, aRead)
 	} else if aRead == false {
 		//WRITE
 		//Set the "Soft Switches"
-		switch m.bus.addr {
+		switch addr {
 		case 0xC000:
 			m.STORE80 = false
 		case 0xC001:
@@ -321,75 +514,17 @@ func (m *Mem) ioRW(aRead bool) uint8 {
 			m.DBLHIRES = true
 		case 0xC05F:
 			m.DBLHIRES = false
+		case 0xC070:
+			m.Paddles.Trigger()
 		}
 	} else {
-		//fmt.Printf("IOREAD: 0x%x\n", m.bus.addr)
-		switch m.bus.addr {
+		switch addr {
 		case 0xC000:
-			return m.keyboardLatch
+			if m.keyboardLatch&(1<<7) == 0 && m.OnKeyPoll != nil {
+				m.OnKeyPoll()
+			}
 		case 0xC010:
 			m.keyboardLatch &= ^uint8(1 << 7)
-		case 0xC011:
-			if m.LCBNK2 {
-				return 0x80
-			}
-		case 0xC012:
-			if m.LCRAM {
-				return 0x80
-			}
-		case 0xC013:
-			if m.RDMAIN == false {
-				return 0x80
-			}
-		case 0xC014:
-			if m.WRMAIN == false {
-				return 0x80
-			}
-		case 0xC015:
-			if m.INTCXROM {
-				return 0x80
-			}
-		case 0xC016:
-			if m.MAINZP == false {
-				return 0x80
-			}
-		case 0xC017:
-			if m.SLOTC3ROM {
-				return 0x80
-			}
-		case 0xC018:
-			if m.STORE80 {
-				return 0x80
-			}
-		case 0xC019:
-			if m.VBLANK {
-				return 0x80 //VBLank flag! Do we need this? I bet we do
-			}
-		case 0xC01A:
-			if m.TEXT {
-				return 0x80
-			}
-		case 0xC01B:
-			if m.MIXED {
-				return 0x80
-			}
-		case 0xC01C:
-			if m.PAGE2 {
-				return 0x80
-			}
-		case 0xC01D:
-			if m.HIRES {
-				return 0x80
-			}
-		case 0xC01E:
-			if m.ALTCHAR {
-				return 0x80
-			}
-		case 0xC01F:
-			if m.VID80 {
-				return 0x80
-			}
-
 		case 0xC050:
 			m.TEXT = false
 		case 0xC051:
@@ -410,205 +545,301 @@ func (m *Mem) ioRW(aRead bool) uint8 {
 			m.DBLHIRES = true
 		case 0xC05F:
 			m.DBLHIRES = false
-		case 0xC061:
-			if m.KBDOAPPLE {
-				return 0x80
-			}
-		case 0xC062:
-			if m.KBDFAPPLE {
-				return 0x80
-			}
-		case 0xC063:
-			if m.KBDSHIFT {
-				return 0x80
-			}
-		case 0xC064, 0xC065, 0xC066, 0xC067:
-			//No paddle support right now, so just report all joysticks are in the "middle"
-			c := (m.cpu.GetCycleCount() - m.savedCycles) / 11
-			if c >= 127 {
-				return 0
-			}
-			return 0x80
 		case 0xC070:
-			m.savedCycles = m.cpu.GetCycleCount()
-		case 0xC07F:
-			if m.DBLHIRES {
-				return 0x80
-			}
+			m.Paddles.Trigger()
 		}
+		return m.peekIO(addr)
 	}
 	return 0
 }
 
-func (m *Mem) busUpdate() {
-	if m.bus.cpuReadWrite {
-		//MEMORY READ
-		if m.bus.addr <= 0x1FF {
-			//Zero page and stack...
-			if m.MAINZP {
-				m.bus.data = m.mem[m.bus.addr]
-			} else {
-				m.bus.data = m.aux[m.bus.addr]
-			}
-		} else if m.bus.addr >= 0x200 && m.bus.addr <= 0xBFFF {
-			//Main 48k RAM area
-			if m.STORE80 {
-				if m.bus.addr >= 0x400 && m.bus.addr <= 0x7FF {
-					if m.PAGE2 {
-						m.bus.data = m.aux[m.bus.addr]
-					} else {
-						m.bus.data = m.mem[m.bus.addr]
-					}
-				} else if m.HIRES && m.bus.addr >= 0x2000 && m.bus.addr <= 0x3FFF {
-					if m.PAGE2 {
-						m.bus.data = m.aux[m.bus.addr]
-					} else {
-						m.bus.data = m.mem[m.bus.addr]
-					}
+//peekIO the I/O locations that report state, reading it doesn't change anything
+func (m *Mem) peekIO(addr uint16) uint8 {
+	switch addr {
+	case 0xC000:
+		return m.keyboardLatch
+	case 0xC011:
+		if m.LCBNK2 {
+			return 0x80
+		}
+	case 0xC012:
+		if m.LCRAM {
+			return 0x80
+		}
+	case 0xC013:
+		if m.RDMAIN == false {
+			return 0x80
+		}
+	case 0xC014:
+		if m.WRMAIN == false {
+			return 0x80
+		}
+	case 0xC015:
+		if m.INTCXROM {
+			return 0x80
+		}
+	case 0xC016:
+		if m.MAINZP == false {
+			return 0x80
+		}
+	case 0xC017:
+		if m.SLOTC3ROM {
+			return 0x80
+		}
+	case 0xC018:
+		if m.STORE80 {
+			return 0x80
+		}
+	case 0xC019:
+		//RDVBLBAR, on the //e this reads high while drawing and low during VBL
+		if !m.InVBL() {
+			return 0x80
+		}
+	case 0xC01A:
+		if m.TEXT {
+			return 0x80
+		}
+	case 0xC01B:
+		if m.MIXED {
+			return 0x80
+		}
+	case 0xC01C:
+		if m.PAGE2 {
+			return 0x80
+		}
+	case 0xC01D:
+		if m.HIRES {
+			return 0x80
+		}
+	case 0xC01E:
+		if m.ALTCHAR {
+			return 0x80
+		}
+	case 0xC01F:
+		if m.VID80 {
+			return 0x80
+		}
+	case 0xC060:
+		if m.Cassette.Input() {
+			return 0x80
+		}
+	case 0xC061:
+		if m.KBDOAPPLE || m.Paddles.Button(0) {
+			return 0x80
+		}
+	case 0xC062:
+		if m.KBDFAPPLE || m.Paddles.Button(1) {
+			return 0x80
+		}
+	case 0xC063:
+		if m.KBDSHIFT || m.Paddles.Button(2) {
+			return 0x80
+		}
+	case 0xC064, 0xC065, 0xC066, 0xC067:
+		if m.Paddles.Timing(int(addr - 0xC064)) {
+			return 0x80
+		}
+	case 0xC07F:
+		if m.DBLHIRES {
+			return 0x80
+		}
+	}
+	return 0
+}
+
+//Read a byte, reading the I/O area flips soft switches
+func (m *Mem) Read(addr uint16) uint8 {
+	if addr >= 0xC000 && addr <= 0xCFFF {
+		if addr >= 0xC100 {
+			return m.slotROM(addr, 0, true)
+		} else if addr >= 0xC090 {
+			return m.slotIO(addr, 0, true)
+		}
+		data := m.ioRW(addr, true)
+		m.remap()
+		return data
+	}
+	return m.Peek(addr)
+}
+
+//Peek read a byte without side effects (for debuggers), soft switches and cards aren't touched
+func (m *Mem) Peek(addr uint16) uint8 {
+	if addr >= 0xD000 && m.saturn != nil {
+		if rd, _ := m.saturn.page(uint8(addr >> 8)); rd != nil {
+			return rd[addr&0xFF]
+		}
+	}
+	if addr <= 0x1FF {
+		//Zero page and stack...
+		if m.MAINZP {
+			return m.mem[addr]
+		} else {
+			return m.aux[addr]
+		}
+	} else if addr >= 0x200 && addr <= 0xBFFF {
+		//Main 48k RAM area
+		if m.STORE80 {
+			if addr >= 0x400 && addr <= 0x7FF {
+				if m.PAGE2 {
+					return m.aux[addr]
 				} else {
-					if m.RDMAIN {
-						m.bus.data = m.mem[m.bus.addr]
-					} else {
-						m.bus.data = m.aux[m.bus.addr]
-					}
+					return m.mem[addr]
+				}
+			} else if m.HIRES && addr >= 0x2000 && addr <= 0x3FFF {
+				if m.PAGE2 {
+					return m.aux[addr]
+				} else {
+					return m.mem[addr]
 				}
 			} else {
 				if m.RDMAIN {
-					m.bus.data = m.mem[m.bus.addr]
+					return m.mem[addr]
 				} else {
-					m.bus.data = m.aux[m.bus.addr]
+					return m.aux[addr]
 				}
 			}
-		} else if m.bus.addr >= 0xC000 && m.bus.addr <= 0xCFFF {
-			//IO Area, RAM isn't accessible here, you have to get it from a $D000 bank
-			if m.bus.addr >= 0xC100 {
-				if m.bus.addr >= 0xC300 && m.bus.addr <= 0xC3FF {
-					m.bus.data = m.rom[m.bus.addr-0xC000]
-				} else if !m.INTCXROM && m.bus.addr <= 0xC7FF {
-					if m.bus.addr >= 0xC600 && m.bus.addr <= 0xC6FF {
-						m.bus.data = m.boot[m.bus.addr-0xC600]
-					} else {
-						m.bus.data = 0 //No other slots connected
-					}
-				} else {
-					m.bus.data = m.rom[m.bus.addr-0xC000]
-				}
+		} else {
+			if m.RDMAIN {
+				return m.mem[addr]
 			} else {
-				m.bus.data = m.ioRW(true)
+				return m.aux[addr]
 			}
-		} else if m.bus.addr >= 0xD000 && m.bus.addr <= 0xDFFF {
-			//What 4k bank are we in?
-			if m.LCRAM {
-				//ROM is switched out, we are reading from RAM
-				if m.LCBNK2 {
-					if m.MAINZP {
-						//Bank 2 -- Main RAM
-						//fmt.Printf("READ 2 0x%x to 0x%x\n", m.bus.data, m.bus.addr)
-						m.bus.data = m.mem[m.bus.addr]
-					} else {
-						//Bank 2 -- Aux RAM
-						m.bus.data = m.aux[m.bus.addr]
-					}
+		}
+	} else if addr >= 0xC000 && addr <= 0xCFFF {
+		//IO Area, RAM isn't accessible here, you have to get it from a $D000 bank
+		if addr >= 0xC100 {
+			return m.peekSlotROM(addr)
+		} else if addr >= 0xC090 {
+			return m.peekSlotIO(addr)
+		}
+		return m.peekIO(addr)
+	} else if addr >= 0xD000 && addr <= 0xDFFF {
+		//What 4k bank are we in?
+		if m.LCRAM {
+			//ROM is switched out, we are reading from RAM
+			if m.LCBNK2 {
+				if m.MAINZP {
+					//Bank 2 -- Main RAM
+					return m.mem[addr]
 				} else {
-					if m.MAINZP {
-						//Bank 1 -- Main RAM
-						//fmt.Printf("READ 1 0x%x to 0x%x\n", m.bus.data, m.bus.addr)
-						m.bus.data = m.mem[m.bus.addr-0x1000]
-					} else {
-						//Bank 1 -- Aux RAM
-						m.bus.data = m.aux[m.bus.addr-0x1000]
-					}
+					//Bank 2 -- Aux RAM
+					return m.aux[addr]
 				}
 			} else {
-				//Just give the ROM for this area
-				//fmt.Printf("READ R 0x%x to 0x%x\n", m.bus.data, m.bus.addr)
-				m.bus.data = m.rom[m.bus.addr-0xC000]
-			}
-		} else {
-			//0xE000 to 0xFFFF
-			if m.LCRAM {
 				if m.MAINZP {
-					m.bus.data = m.mem[m.bus.addr]
+					//Bank 1 -- Main RAM
+					return m.mem[addr-0x1000]
 				} else {
-					m.bus.data = m.aux[m.bus.addr]
+					//Bank 1 -- Aux RAM
+					return m.aux[addr-0x1000]
 				}
-			} else {
-				//Just give the ROM for this area
-				m.bus.data = m.rom[m.bus.addr-0xC000]
 			}
+		} else {
+			//Just give the ROM for this area
+			return m.rom[addr-0xC000]
 		}
 	} else {
-		//MEMORY WRITE
-		if m.bus.addr <= 0x1FF {
-			//Zero page and stack...
+		//0xE000 to 0xFFFF
+		if m.LCRAM {
 			if m.MAINZP {
-				m.mem[m.bus.addr] = m.bus.data
+				return m.mem[addr]
 			} else {
-				m.aux[m.bus.addr] = m.bus.data
+				return m.aux[addr]
 			}
-		} else if m.bus.addr >= 0x200 && m.bus.addr <= 0xBFFF {
-			//Main 48k RAM area
-			if m.STORE80 {
-				if m.bus.addr >= 0x400 && m.bus.addr <= 0x7FF {
-					if m.PAGE2 {
-						m.aux[m.bus.addr] = m.bus.data
-					} else {
-						m.mem[m.bus.addr] = m.bus.data
-					}
-				} else if m.HIRES && m.bus.addr >= 0x2000 && m.bus.addr <= 0x3FFF {
-					if m.PAGE2 {
-						m.aux[m.bus.addr] = m.bus.data
-					} else {
-						m.mem[m.bus.addr] = m.bus.data
-					}
+		} else {
+			//Just give the ROM for this area
+			return m.rom[addr-0xC000]
+		}
+	}
+}
+
+//Write a byte
+func (m *Mem) Write(addr uint16, data uint8) {
+	if addr >= 0xD000 && m.saturn != nil {
+		if _, wr := m.saturn.page(uint8(addr >> 8)); wr != nil {
+			wr[addr&0xFF] = data
+			return
+		}
+	}
+	if addr <= 0x1FF {
+		//Zero page and stack...
+		if m.MAINZP {
+			m.mem[addr] = data
+		} else {
+			m.aux[addr] = data
+		}
+	} else if addr >= 0x200 && addr <= 0xBFFF {
+		//Main 48k RAM area
+		m.markDirty(addr)
+		if m.STORE80 {
+			if addr >= 0x400 && addr <= 0x7FF {
+				if m.PAGE2 {
+					m.aux[addr] = data
+				} else {
+					m.mem[addr] = data
+				}
+			} else if m.HIRES && addr >= 0x2000 && addr <= 0x3FFF {
+				if m.PAGE2 {
+					m.aux[addr] = data
 				} else {
-					if m.WRMAIN {
-						m.mem[m.bus.addr] = m.bus.data
-					} else {
-						m.aux[m.bus.addr] = m.bus.data
-					}
+					m.mem[addr] = data
 				}
 			} else {
 				if m.WRMAIN {
-					m.mem[m.bus.addr] = m.bus.data
+					m.mem[addr] = data
 				} else {
-					m.aux[m.bus.addr] = m.bus.data
+					m.aux[addr] = data
 				}
 			}
-		} else if m.bus.addr >= 0xC000 && m.bus.addr <= 0xCFFF {
-			//IO Area, RAM isn't accessible here, you have to get it from a $D000 bank
-			m.ioRW(false)
-		} else if m.bus.addr >= 0xD000 && m.bus.addr <= 0xDFFF {
-			//What 4k bank are we in?
-			if m.LCWRITE {
-				//ROM is switched out, we are reading from RAM
-				if m.LCBNK2 {
-					if m.MAINZP {
-						//Bank 2 -- Main RAM
-						//fmt.Printf("WRITE 0x%x to 0x%x\n", m.bus.data, m.bus.addr)
-						m.mem[m.bus.addr] = m.bus.data
-					} else {
-						//Bank 2 -- Aux RAM
-						m.aux[m.bus.addr] = m.bus.data
-					}
-				} else {
-					if m.MAINZP {
-						//Bank 1 -- Main RAM
-						m.mem[m.bus.addr-0x1000] = m.bus.data
-					} else {
-						//Bank 1 -- Aux RAM
-						m.aux[m.bus.addr-0x1000] = m.bus.data
-					}
-				}
+		} else {
+			if m.WRMAIN {
+				m.mem[addr] = data
+			} else {
+				m.aux[addr] = data
 			}
+		}
+	} else if addr >= 0xC000 && addr <= 0xCFFF {
+		//IO Area, RAM isn't accessible here, you have to get it from a $D000 bank
+		if addr >= 0xC100 {
+			m.slotROM(addr, data, false)
+		} else if addr >= 0xC090 {
+			m.slotIO(addr, data, false)
 		} else {
-			//0xE000 to 0xFFFF
-			if m.LCWRITE {
+			if addr == 0xC071 || addr == 0xC073 {
+				m.selectAuxBank(data)
+			}
+			m.ioRW(addr, false)
+			m.remap()
+		}
+	} else if addr >= 0xD000 && addr <= 0xDFFF {
+		//What 4k bank are we in?
+		if m.LCWRITE {
+			//ROM is switched out, we are reading from RAM
+			if m.LCBNK2 {
 				if m.MAINZP {
-					m.mem[m.bus.addr] = m.bus.data
+					//Bank 2 -- Main RAM
+					m.mem[addr] = data
 				} else {
-					m.aux[m.bus.addr] = m.bus.data
+					//Bank 2 -- Aux RAM
+					m.aux[addr] = data
 				}
+			} else {
+				if m.MAINZP {
+					//Bank 1 -- Main RAM
+					m.mem[addr-0x1000] = data
+				} else {
+					//Bank 1 -- Aux RAM
+					m.aux[addr-0x1000] = data
+				}
+			}
+		}
+	} else {
+		//0xE000 to 0xFFFF
+		if m.LCWRITE {
+			if m.MAINZP {
+				m.mem[addr] = data
+			} else {
+				m.aux[addr] = data
 			}
 		}
 	}
@@ -0,0 +1,111 @@
+package appleii
+
+/* mockingboard.go -- The Sweet Micro Systems Mockingboard sound card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//Mockingboard two 6522s at $Cn00 and $Cn80, each drives an AY-3-8910 with port A as its data bus
+//and port B bits 0-2 as BC1, BDIR and /RESET. The first PSG is the left channel and the second the
+//right. The speech version has an SC-01 on the first 6522's port B, it takes a phoneme when the
+//whole port is an output and answers on CB1
+type Mockingboard struct {
+	NoExpansionROM
+	bus    *Bus
+	slot   int
+	via    [2]*VIA
+	psg    [2]*AY8910
+	speech *SC01   //nil without speech
+	irq    [2]bool //Each 6522's interrupt output, they share the slot's IRQ
+}
+
+//NewMockingboard a Mockingboard for slot, optionally with speech, mixed into mixer
+func NewMockingboard(b *Bus, mixer *Mixer, slot int, speech bool) *Mockingboard {
+	mb := Mockingboard{bus: b, slot: slot}
+	for i := range mb.via {
+		n := i
+		via, psg := NewVIA(b), NewAY8910()
+		psg.Quiet = !mixer.Listening()
+		via.InputA = psg.Data
+		via.OutputB = func(out uint8) { mb.control(n, out) }
+		via.IRQ = func(asserted bool) {
+			mb.irq[n] = asserted
+			b.SetIRQ(IRQSlot(slot), mb.irq[0] || mb.irq[1])
+		}
+		mb.via[i], mb.psg[i] = via, psg
+	}
+	if speech {
+		mb.speech = NewSC01(b)
+		mb.speech.Request = mb.via[0].SetCB1
+		mb.via[0].SetCB1(true)
+	}
+	mixer.AddSource(&mb)
+	return &mb
+}
+
+//Reset the 6522s, which resets the PSGs through /RESET
+func (mb *Mockingboard) Reset() {
+	for i := range mb.via {
+		mb.via[i].Reset()
+		mb.psg[i].Reset()
+	}
+}
+
+//IO nothing is at $C0n0-$C0nF
+func (mb *Mockingboard) IO(reg uint8, data uint8, read bool) uint8 {
+	return 0
+}
+
+//PeekIO nothing is at $C0n0-$C0nF
+func (mb *Mockingboard) PeekIO(reg uint8) uint8 {
+	return 0
+}
+
+//ROM the 6522s are where the ROM would be, $Cn00 and $Cn80
+func (mb *Mockingboard) ROM(offset uint8, data uint8, read bool) uint8 {
+	via := mb.via[offset>>7]
+	if read {
+		return via.Read(offset&0x0F, mb.bus.Cycles())
+	}
+	via.Write(offset&0x0F, data, mb.bus.Cycles())
+	return 0
+}
+
+//PeekROM the 6522 registers without clearing their interrupts
+func (mb *Mockingboard) PeekROM(offset uint8) uint8 {
+	return mb.via[offset>>7].Peek(offset&0x0F, mb.bus.Cycles())
+}
+
+//Sample the left and right PSGs
+func (mb *Mockingboard) Sample(cycle uint64) (left, right int) {
+	return mb.psg[0].Output(cycle), mb.psg[1].Output(cycle)
+}
+
+//control port B of 6522 n changed, it runs the PSG's bus or feeds the speech chip
+func (mb *Mockingboard) control(n int, out uint8) {
+	cycle := mb.bus.Cycles()
+	if n == 0 && mb.speech != nil && mb.via[0].DDRB() == 0xFF {
+		mb.speech.Speak(out, cycle)
+		return
+	}
+	psg := mb.psg[n]
+	if out&0x04 == 0 {
+		psg.run(cycle)
+		psg.Reset()
+		return
+	}
+	psg.Control(out&0x03, mb.via[n].OutA(), cycle)
+}
@@ -0,0 +1,276 @@
+package appleii
+
+/* mouse.go -- The AppleMouse II card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//Mouse card I/O registers, reading 0 gives the carry of the last call and writing 1-8 makes a call
+const (
+	mouseResult = 0x0
+	mouseSet    = 0x1 //SETMOUSE
+	mouseServe  = 0x2 //SERVEMOUSE
+	mouseRead   = 0x3 //READMOUSE
+	mouseClear  = 0x4 //CLEARMOUSE
+	mousePos    = 0x5 //POSMOUSE
+	mouseClamp  = 0x6 //CLAMPMOUSE
+	mouseHome   = 0x7 //HOMEMOUSE
+	mouseInit   = 0x8 //INITMOUSE
+)
+
+//Mode bits, SETMOUSE takes a mode of 0-$F
+const (
+	mouseOn        uint8 = 0x01
+	mouseIRQMove   uint8 = 0x02
+	mouseIRQButton uint8 = 0x04
+	mouseIRQVBL    uint8 = 0x08
+)
+
+//Status bits
+const (
+	mouseStatusMove   uint8 = 0x02 //Interrupt from movement
+	mouseStatusButton uint8 = 0x04 //Interrupt from the button
+	mouseStatusVBL    uint8 = 0x08 //Interrupt from VBL
+	mouseStatusMoved  uint8 = 0x20 //Moved since the last READMOUSE
+	mouseStatusWasDn  uint8 = 0x40 //Button was down at the last READMOUSE
+	mouseStatusDown   uint8 = 0x80 //Button is down
+)
+
+//Screen holes, the position, status and mode holes are for slot 0 and the card's slot is added
+//to them. CLAMPMOUSE reads the bounds from the slot 0 position holes
+const (
+	mouseHoleXLo   = 0x478
+	mouseHoleYLo   = 0x4F8
+	mouseHoleXHi   = 0x578
+	mouseHoleYHi   = 0x5F8
+	mouseHoleState = 0x778
+	mouseHoleMode  = 0x7F8
+)
+
+//mouseEntries where the firmware calls start, one 8 byte routine each from SETMOUSE on
+const mouseEntries = 0x40
+
+//mouseMax the largest position after INITMOUSE
+const mouseMax = 1023
+
+//MouseCard an AppleMouse II. Software calls the firmware through the table at $Cn12-$Cn19, each
+//routine traps to the card which does the work with the screen holes. The mouse is checked for
+//interrupts each VBL
+type MouseCard struct {
+	NoExpansionROM
+	bus  *Bus
+	slot int
+	rom  [256]uint8
+	regs MouseState
+}
+
+//NewMouseCard a mouse card for slot, it takes the VBL of m
+func NewMouseCard(b *Bus, m *Mem, slot int) *MouseCard {
+	mc := MouseCard{bus: b, slot: slot}
+	mc.makeROM()
+	mc.initMouse()
+	m.OnVBL = mc.vbl
+	return &mc
+}
+
+//makeROM write the firmware for the card's slot
+func (mc *MouseCard) makeROM() {
+	io := 0x80 + uint8(mc.slot)<<4 //Low byte of $C0n0
+	rom := &mc.rom
+	rom[0x00] = 0x60 //RTS, there is no BASIC interface
+	//Pascal 1.1 firmware signature and the card's ID, $20 is a mouse
+	rom[0x05], rom[0x07], rom[0x0B], rom[0x0C] = 0x38, 0x18, 0x01, 0x20
+	//Pascal entry points all fail, X=3 is no device
+	rom[0x0D], rom[0x0E], rom[0x0F], rom[0x10] = 0x30, 0x30, 0x30, 0x30
+	copy(rom[0x30:], []uint8{
+		0xA2, 0x03, //LDX #$03
+		0x38, //SEC
+		0x60, //RTS
+	})
+	for call := uint8(mouseSet); call <= mouseInit; call++ {
+		entry := mouseEntries + (call-1)*8
+		rom[0x12+call-1] = entry
+		copy(rom[entry:], []uint8{
+			0x8D, io + call, 0xC0, //STA $C0nX
+			0xAD, io + mouseResult, 0xC0, //LDA $C0n0
+			0x4A, //LSR, the carry
+			0x60, //RTS
+		})
+	}
+	rom[0xFB] = 0xD6 //Mouse ID
+}
+
+//Move the mouse by dx and dy, the position stays inside the clamps
+func (mc *MouseCard) Move(dx, dy int) {
+	r := &mc.regs
+	x, y := clampInt(r.X+dx, r.MinX, r.MaxX), clampInt(r.Y+dy, r.MinY, r.MaxY)
+	if x != r.X || y != r.Y {
+		r.X, r.Y = x, y
+		r.Moved, r.MovedIRQ = true, true
+	}
+}
+
+//SetButton press or let go of the button
+func (mc *MouseCard) SetButton(down bool) {
+	r := &mc.regs
+	if r.Button != down {
+		r.Button, r.ButtonIRQ = down, true
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+//vbl interrupt for whatever the mode asks for that has happened since the last VBL
+func (mc *MouseCard) vbl() {
+	r := &mc.regs
+	if r.Mode&mouseOn == 0 {
+		return
+	}
+	irq := uint8(0)
+	if r.Mode&mouseIRQVBL != 0 {
+		irq |= mouseStatusVBL
+	}
+	if r.Mode&mouseIRQMove != 0 && r.MovedIRQ {
+		irq |= mouseStatusMove
+	}
+	if r.Mode&mouseIRQButton != 0 && r.ButtonIRQ {
+		irq |= mouseStatusButton
+	}
+	r.MovedIRQ, r.ButtonIRQ = false, false
+	if irq != 0 {
+		r.Pending |= irq
+		mc.bus.SetIRQ(IRQSlot(mc.slot), true)
+	}
+}
+
+//hole16 the 16 bit number in a pair of screen holes
+func (mc *MouseCard) hole16(lo, hi uint16) int {
+	return int(int16(uint16(mc.bus.Peek(lo)) | uint16(mc.bus.Peek(hi))<<8))
+}
+
+//call one of the firmware's routines, false sets the carry
+func (mc *MouseCard) call(n uint8, a uint8) bool {
+	r := &mc.regs
+	slot := uint16(mc.slot)
+	switch n {
+	case mouseSet:
+		if a > 0x0F {
+			return false
+		}
+		r.Mode = a
+		mc.bus.Write(mouseHoleMode+slot, a)
+		if r.Mode&mouseOn == 0 {
+			r.Pending = 0
+			mc.bus.SetIRQ(IRQSlot(mc.slot), false)
+		}
+	case mouseServe:
+		if r.Pending == 0 {
+			return false
+		}
+		status := mc.bus.Peek(mouseHoleState + slot)
+		mc.bus.Write(mouseHoleState+slot, status&^(mouseStatusMove|mouseStatusButton|mouseStatusVBL)|r.Pending)
+		r.Pending = 0
+		mc.bus.SetIRQ(IRQSlot(mc.slot), false)
+	case mouseRead:
+		mc.bus.Write(mouseHoleXLo+slot, uint8(r.X))
+		mc.bus.Write(mouseHoleXHi+slot, uint8(r.X>>8))
+		mc.bus.Write(mouseHoleYLo+slot, uint8(r.Y))
+		mc.bus.Write(mouseHoleYHi+slot, uint8(r.Y>>8))
+		status := uint8(0)
+		if r.Button {
+			status |= mouseStatusDown
+		}
+		if r.WasDown {
+			status |= mouseStatusWasDn
+		}
+		if r.Moved {
+			status |= mouseStatusMoved
+		}
+		mc.bus.Write(mouseHoleState+slot, status)
+		r.WasDown, r.Moved = r.Button, false
+	case mouseClear:
+		r.X, r.Y = 0, 0
+	case mousePos:
+		r.X = mc.hole16(mouseHoleXLo+slot, mouseHoleXHi+slot)
+		r.Y = mc.hole16(mouseHoleYLo+slot, mouseHoleYHi+slot)
+	case mouseClamp:
+		min, max := mc.hole16(mouseHoleXLo, mouseHoleXHi), mc.hole16(mouseHoleYLo, mouseHoleYHi)
+		if a == 0 {
+			r.MinX, r.MaxX = min, max
+		} else {
+			r.MinY, r.MaxY = min, max
+		}
+		r.X, r.Y = clampInt(r.X, r.MinX, r.MaxX), clampInt(r.Y, r.MinY, r.MaxY)
+	case mouseHome:
+		r.X, r.Y = r.MinX, r.MinY
+	case mouseInit:
+		mc.initMouse()
+		mc.bus.Write(mouseHoleMode+slot, 0)
+		mc.bus.Write(mouseHoleState+slot, 0)
+	}
+	return true
+}
+
+//initMouse the mouse at the top left, clamped to 0-1023 and off. The button is left as it is
+func (mc *MouseCard) initMouse() {
+	r := &mc.regs
+	button := r.Button
+	*r = MouseState{MaxX: mouseMax, MaxY: mouseMax, Button: button, WasDown: button}
+	mc.bus.SetIRQ(IRQSlot(mc.slot), false)
+}
+
+//IO the firmware's calls
+func (mc *MouseCard) IO(reg uint8, data uint8, read bool) uint8 {
+	switch {
+	case reg == mouseResult && read:
+		return mc.regs.Result
+	case reg >= mouseSet && reg <= mouseInit && !read:
+		mc.regs.Result = 0
+		if !mc.call(reg, data) {
+			mc.regs.Result = 1
+		}
+	}
+	return 0
+}
+
+//PeekIO the result of the last call, calls are only made by writes
+func (mc *MouseCard) PeekIO(reg uint8) uint8 {
+	return mc.IO(reg, 0, true)
+}
+
+//ROM the firmware
+func (mc *MouseCard) ROM(offset uint8, data uint8, read bool) uint8 {
+	return mc.rom[offset]
+}
+
+//PeekROM the firmware
+func (mc *MouseCard) PeekROM(offset uint8) uint8 {
+	return mc.rom[offset]
+}
+
+//Reset turn the mouse off
+func (mc *MouseCard) Reset() {
+	mc.regs.Mode, mc.regs.Pending = 0, 0
+	mc.bus.SetIRQ(IRQSlot(mc.slot), false)
+}
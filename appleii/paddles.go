@@ -0,0 +1,112 @@
+package appleii
+
+/* paddles.go -- The game port, four analog paddles and three push buttons
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//CyclesPerPaddleUnit how long the 558 timer runs for each unit of paddle value, PREAD counts
+//in an 11 cycle loop so 255 comes out at ~2.8ms
+const CyclesPerPaddleUnit = 11
+
+//PaddleAxis the calibration of one analog input, values are in paddle units (0-255), an axis
+//with no range set is uncalibrated
+type PaddleAxis struct {
+	Min      int  //Input value that reads as 0
+	Max      int  //Input value that reads as 255
+	Deadzone int  //Inputs this close to the middle read as centered
+	Invert   bool //Swap the ends of the axis
+}
+
+//DefaultPaddleAxis an uncalibrated axis with no deadzone
+var DefaultPaddleAxis = PaddleAxis{Min: 0, Max: 255}
+
+//Paddles the game port, read through $C061-$C067 and triggered by $C070
+type Paddles struct {
+	cpu     *CPU
+	Axes    [4]PaddleAxis
+	value   [4]uint8
+	buttons [3]bool
+	timing  [4]bool   //558 timers still running since the last PTRIG
+	timers  [4]*Timer //Each 558 timer running out
+}
+
+//NewPaddles a game port with every paddle centered
+func NewPaddles(b *Bus, c *CPU) *Paddles {
+	p := Paddles{cpu: c}
+	for i := range p.Axes {
+		n := i
+		p.Axes[i] = DefaultPaddleAxis
+		p.value[i] = 128
+		p.timers[i] = b.NewTimer(func() { p.timing[n] = false })
+	}
+	return &p
+}
+
+//SetAxis move paddle n to the input value v (nominally 0-255), calibration is applied
+func (p *Paddles) SetAxis(n int, v int) {
+	a := p.Axes[n]
+	if a.Max <= a.Min {
+		a.Min, a.Max = DefaultPaddleAxis.Min, DefaultPaddleAxis.Max
+	}
+	mid := (a.Min + a.Max) / 2
+	if v-mid <= a.Deadzone && mid-v <= a.Deadzone {
+		v = mid
+	}
+	v = (v - a.Min) * 255 / (a.Max - a.Min)
+	if v < 0 {
+		v = 0
+	} else if v > 255 {
+		v = 255
+	}
+	if a.Invert {
+		v = 255 - v
+	}
+	p.value[n] = uint8(v)
+}
+
+//Value the current value of paddle n
+func (p *Paddles) Value(n int) uint8 {
+	return p.value[n]
+}
+
+//SetButton press or release push button n (0-2)
+func (p *Paddles) SetButton(n int, down bool) {
+	p.buttons[n] = down
+}
+
+//Button is push button n pressed
+func (p *Paddles) Button(n int) bool {
+	return p.buttons[n]
+}
+
+//Trigger PTRIG, starts all four timers, each runs out after a time set by its paddle's value
+func (p *Paddles) Trigger() {
+	now := p.cpu.GetCycleCount()
+	for n, t := range p.timers {
+		p.timing[n] = p.value[n] > 0
+		if p.timing[n] {
+			t.Schedule(now + uint64(p.value[n])*CyclesPerPaddleUnit)
+		} else {
+			t.Cancel()
+		}
+	}
+}
+
+//Timing is paddle n's timer still running
+func (p *Paddles) Timing(n int) bool {
+	return p.timing[n]
+}
@@ -0,0 +1,75 @@
+package appleii
+
+/* printer.go -- A parallel printer interface card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//Printer what is plugged into a printer port
+type Printer interface {
+	//Print take the next byte, all 8 bits as the card latched them
+	Print(b uint8)
+}
+
+//ParallelCard a parallel printer interface, a byte written to $C0n0 goes to the printer. The printer
+//is never busy so reads of the I/O locations say it's ready. The firmware sends whatever PR#
+//points CSW at it to the printer and echoes it to the screen
+type ParallelCard struct {
+	NoExpansionROM
+	//Printer what's plugged in, nil for nothing
+	Printer Printer
+	rom     [256]uint8
+}
+
+//NewParallelCard a printer card for slot with nothing plugged in
+func NewParallelCard(slot int) *ParallelCard {
+	p := ParallelCard{}
+	io := 0x80 + uint8(slot)<<4 //Low byte of $C0n0
+	copy(p.rom[:], []uint8{
+		0x48,           //PHA
+		0x8D, io, 0xC0, //STA $C0n0
+		0x68,             //PLA
+		0x4C, 0xF0, 0xFD, //JMP COUT1 (echo it)
+	})
+	return &p
+}
+
+//IO $C0n0 is the data latch, a write prints it
+func (p *ParallelCard) IO(reg uint8, data uint8, read bool) uint8 {
+	if !read && reg == 0 && p.Printer != nil {
+		p.Printer.Print(data)
+	}
+	return 0
+}
+
+//PeekIO the latch can't be read back
+func (p *ParallelCard) PeekIO(reg uint8) uint8 {
+	return 0
+}
+
+//ROM the firmware
+func (p *ParallelCard) ROM(offset uint8, data uint8, read bool) uint8 {
+	return p.rom[offset]
+}
+
+//PeekROM the firmware
+func (p *ParallelCard) PeekROM(offset uint8) uint8 {
+	return p.rom[offset]
+}
+
+//Reset nothing to do, the printer keeps what it has
+func (p *ParallelCard) Reset() {
+}
@@ -0,0 +1,102 @@
+package appleii
+
+/* saturn.go -- The Saturn 128K RAM card, eight language cards in a slot
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "log"
+
+//Each 16k bank is laid out as the $D000 bank 1, the $D000 bank 2 and then $E000-$FFFF
+const (
+	saturnBanks    = 8
+	saturnBankSize = 0x4000
+)
+
+//SaturnCard a Saturn Systems 128K RAM card, eight 16k banks that each work like a language card.
+//$C0n0-$C0n3 and $C0n8-$C0nB are the language card switches and $C0n4-$C0n7 pick banks 0-3 and
+//$C0nC-$C0nF banks 4-7. The card takes $D000-$FFFF away from the motherboard (INH) for reads while
+//it reads RAM and for writes while writing is on, the //e's own language card keeps the rest.
+//After a reset it reads ROM with writing off, so nothing changes until software switches it in
+type SaturnCard struct {
+	NoExpansionROM
+	mem  *Mem
+	regs SaturnState
+}
+
+//NewSaturnCard a Saturn card in m, there can only be one
+func NewSaturnCard(m *Mem) *SaturnCard {
+	if m.saturn != nil {
+		log.Fatal("Only one Saturn card is supported")
+	}
+	s := SaturnCard{mem: m, regs: SaturnState{RAM: make([]byte, saturnBanks*saturnBankSize)}}
+	m.saturn = &s
+	s.Reset()
+	return &s
+}
+
+//page the card's RAM for page p of $D000-$FFFF to read and write, nil where the motherboard has it
+func (s *SaturnCard) page(p uint8) (read, write []uint8) {
+	if p < 0xD0 {
+		return nil, nil
+	}
+	offset := s.regs.Bank*saturnBankSize + int(p-0xD0)<<8
+	if p >= 0xE0 || s.regs.Bank2 {
+		//Past the $D000 bank 1
+		offset += 0x1000
+	}
+	ram := s.regs.RAM[offset : offset+0x100]
+	if s.regs.ReadRAM {
+		read = ram
+	}
+	if s.regs.WriteRAM {
+		write = ram
+	}
+	return read, write
+}
+
+//IO the bank and language card switches, reads and writes both switch
+func (s *SaturnCard) IO(reg uint8, data uint8, read bool) uint8 {
+	if reg&0x4 != 0 {
+		s.regs.Bank = int(reg&0x3 | (reg&0x8)>>1)
+	} else {
+		lcSwitch(reg, read, &s.regs.Bank2, &s.regs.ReadRAM, &s.regs.WriteRAM, &s.regs.PreWrite)
+	}
+	s.mem.cardSwitched()
+	return 0
+}
+
+//PeekIO the switches don't read back
+func (s *SaturnCard) PeekIO(reg uint8) uint8 {
+	return 0
+}
+
+//ROM there isn't one
+func (s *SaturnCard) ROM(offset uint8, data uint8, read bool) uint8 {
+	return 0
+}
+
+//PeekROM there isn't one
+func (s *SaturnCard) PeekROM(offset uint8) uint8 {
+	return 0
+}
+
+//Reset back to bank 0 reading ROM with writing off, as if $C0n2 was read. The RAM keeps what it has
+func (s *SaturnCard) Reset() {
+	s.regs.Bank = 0
+	s.regs.Bank2, s.regs.ReadRAM, s.regs.WriteRAM, s.regs.PreWrite = true, false, false, false
+	s.mem.cardSwitched()
+}
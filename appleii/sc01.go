@@ -0,0 +1,64 @@
+package appleii
+
+/* sc01.go -- The Votrax SC-01 speech chip on the Mockingboard
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//sc01Phonemes how long each of the 64 phonemes lasts in milliseconds, from the datasheet
+var sc01Phonemes = [64]uint64{
+	59, 71, 121, 47, 47, 71, 103, 90, 71, 55, 80, 121, 103, 80, 71, 71, //EH3-V
+	71, 121, 71, 146, 121, 146, 103, 185, 103, 80, 47, 71, 71, 103, 55, 90, //CH-S
+	185, 65, 80, 47, 250, 103, 185, 185, 185, 103, 71, 90, 185, 80, 185, 103, //A-AE1
+	90, 71, 103, 185, 80, 121, 59, 90, 80, 71, 146, 185, 121, 250, 185, 47, //AW2-STOP
+}
+
+//SC01 a speech chip that keeps time but doesn't talk, software that speaks waits on it phoneme by
+//phoneme so it still runs at the right pace
+type SC01 struct {
+	regs  SC01State
+	timer *Timer
+	//Request is called with false when a phoneme starts and true when the chip wants the next one
+	Request func(ready bool)
+}
+
+//NewSC01 a speech chip timed by the bus
+func NewSC01(b *Bus) *SC01 {
+	s := SC01{}
+	s.timer = b.NewTimer(s.done)
+	return &s
+}
+
+//Speak start a phoneme, the low 6 bits pick it and the top 2 the inflection
+func (s *SC01) Speak(data uint8, cycle uint64) {
+	s.regs.Phoneme, s.regs.Busy = data, true
+	s.timer.Schedule(cycle + sc01Phonemes[data&0x3F]*ClockHz/1000)
+	if s.Request != nil {
+		s.Request(false)
+	}
+}
+
+//Busy is a phoneme being spoken
+func (s *SC01) Busy() bool {
+	return s.regs.Busy
+}
+
+func (s *SC01) done() {
+	s.regs.Busy = false
+	if s.Request != nil {
+		s.Request(true)
+	}
+}
@@ -0,0 +1,305 @@
+package appleii
+
+/* serial.go -- The Apple Super Serial Card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//Card I/O registers, the 6551 is at $C0n8-$C0nB
+const (
+	sscSW1   = 0x1 //DIP switch bank 1
+	sscSW2   = 0x2 //DIP switch bank 2
+	sscSetup = 0x4 //Built in firmware only, writing sets up PR# or IN#, reading says which it was
+	sscACIA  = 0x8
+)
+
+//Where things are in the built in firmware
+const (
+	sscOutput = 0x18 //Output routine CSW is pointed at
+	sscInput  = 0x30 //Input routine KSW is pointed at
+)
+
+//SSCFirmware the Super Serial Card ROM, 2k with $Cn00 at $700. Without it the card has a small
+//firmware of its own that does PR# and IN# and nothing else
+const SSCFirmware = "./data/ssc.bin"
+
+//SSCSwitches the settings of the card's DIP switches
+type SSCSwitches struct {
+	Baud     int  //One of the 6551's rates from 50 to 19200
+	DataBits int  //7 or 8
+	Parity   byte //'N'one, 'O'dd or 'E'ven
+	StopBits int  //1 or 2
+	Printer  bool //Printer mode instead of communications mode
+	LineFeed bool //Add a line feed after each carriage return
+}
+
+//DefaultSSCSwitches communications mode at 9600 8N1
+var DefaultSSCSwitches = SSCSwitches{Baud: 9600, DataBits: 8, Parity: 'N', StopBits: 1}
+
+//ParseSSCSwitches read switches written like 9600,8N1 with printer and lf optionally added
+func ParseSSCSwitches(s string) (SSCSwitches, error) {
+	sw := DefaultSSCSwitches
+	for i, f := range strings.Split(strings.ToLower(s), ",") {
+		switch {
+		case i == 0:
+			baud, err := strconv.Atoi(f)
+			if err != nil || sw.baudCode(baud) == 0 {
+				return sw, fmt.Errorf("Unknown baud rate %q", f)
+			}
+			sw.Baud = baud
+		case len(f) == 3 && (f[0] == '7' || f[0] == '8') && strings.IndexByte("noe", f[1]) >= 0 && (f[2] == '1' || f[2] == '2'):
+			sw.DataBits, sw.Parity, sw.StopBits = int(f[0]-'0'), f[1]-'a'+'A', int(f[2]-'0')
+		case f == "printer":
+			sw.Printer = true
+		case f == "lf":
+			sw.LineFeed = true
+		default:
+			return sw, fmt.Errorf("Unknown serial setting %q", f)
+		}
+	}
+	return sw, nil
+}
+
+//baudCode the 6551 control register setting for baud, 0 if it has none
+func (sw SSCSwitches) baudCode(baud int) uint8 {
+	for code := 1; code < len(aciaBaud); code++ {
+		if aciaBaud[code] == uint64(baud) {
+			return uint8(code)
+		}
+	}
+	return 0
+}
+
+//bank1 switch bank 1 as the card reads it, SW1-1 to SW1-4 are the baud rate and SW1-5 and SW1-6
+//the mode. A switch that is ON reads as 0
+func (sw SSCSwitches) bank1() uint8 {
+	bank := sw.baudCode(sw.Baud) << 4
+	if sw.Printer {
+		bank |= 0x02 //Printer mode is SW1-5 OFF and SW1-6 ON
+	} else {
+		bank |= 0x01 //Communications mode is SW1-5 ON and SW1-6 OFF
+	}
+	return ^bank
+}
+
+//bank2 switch bank 2 as the card reads it, SW2-1 is the stop bits, SW2-2 the data bits, SW2-3 and
+//SW2-4 the parity and SW2-5 the line feeds
+func (sw SSCSwitches) bank2() uint8 {
+	bank := uint8(0)
+	if sw.StopBits == 2 {
+		bank |= 0x80
+	}
+	if sw.DataBits == 7 {
+		bank |= 0x20
+	}
+	switch sw.Parity {
+	case 'O':
+		bank |= 0x08
+	case 'E':
+		bank |= 0x0C
+	}
+	if sw.LineFeed {
+		bank |= 0x02
+	}
+	return ^bank
+}
+
+//control the 6551 control register for the switches, using the baud rate generator
+func (sw SSCSwitches) control() uint8 {
+	control := 0x10 | sw.baudCode(sw.Baud)
+	if sw.DataBits == 7 {
+		control |= 0x20
+	}
+	if sw.StopBits == 2 {
+		control |= 0x80
+	}
+	return control
+}
+
+//command the 6551 command register for the switches, DTR on and no interrupts
+func (sw SSCSwitches) command() uint8 {
+	command := aciaDTR | aciaRxIRQOff | 0x08 //RTS on, no transmit interrupts
+	switch sw.Parity {
+	case 'O':
+		command |= aciaParity
+	case 'E':
+		command |= aciaParity | 0x40
+	}
+	return command
+}
+
+//SSC a Super Serial Card, a 6551 with DIP switches and a ROM
+type SSC struct {
+	bus      *Bus
+	slot     int
+	acia     *ACIA
+	Switches SSCSwitches
+	rom      []byte //2k firmware, nil with the built in firmware
+	builtin  [256]uint8
+	input    bool //The built in firmware was last set up by IN#
+}
+
+//NewSSC a Super Serial Card for slot with nothing plugged in
+func NewSSC(b *Bus, slot int, sw SSCSwitches) *SSC {
+	s := SSC{bus: b, slot: slot, acia: NewACIA(b), Switches: sw}
+	s.acia.IRQ = func(asserted bool) { b.SetIRQ(IRQSlot(slot), asserted) }
+	if data, err := ioutil.ReadFile(SSCFirmware); err == nil && len(data) == 2048 {
+		s.rom = data
+	}
+	s.makeROM()
+	return &s
+}
+
+//Connect plug line into the serial port
+func (s *SSC) Connect(line SerialLine) {
+	s.acia.Line = line
+	s.acia.startReceiving()
+}
+
+//makeROM write the built in firmware for the card's slot
+func (s *SSC) makeROM() {
+	n := uint8(s.slot)
+	io := 0x80 + n<<4 //Low byte of $C0n0
+	cn := 0xC0 + n    //High byte of $Cn00
+	rom := s.builtin[:0]
+	rom = append(rom,
+		0x48,                    //PHA
+		0x8D, io+sscSetup, 0xC0, //STA $C0n4, point CSW or KSW past here
+		0xAD, io+sscSetup, 0xC0, //LDA $C0n4
+		0xD0, 0x04, //BNE input
+		0x68,                //PLA
+		0x4C, sscOutput, cn, //JMP output
+		0x68,               //input: PLA
+		0x4C, sscInput, cn) //JMP input
+	for len(rom) < sscOutput {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x48,                              //output: PHA
+		0xAD, io+sscACIA+aciaStatus, 0xC0, //LDA $C0n9
+		0x29, aciaTDRE, //AND #TDRE
+		0xF0, 0xF9, //BEQ (wait until there's room)
+		0x68,       //PLA
+		0x48,       //PHA
+		0x29, 0x7F, //AND #$7F (plain ASCII down the line)
+		0x8D, io+sscACIA+aciaData, 0xC0, //STA $C0n8
+		0x68,             //PLA
+		0x4C, 0xF0, 0xFD) //JMP COUT1 (print it too)
+	for len(rom) < sscInput {
+		rom = append(rom, 0)
+	}
+	rom = append(rom,
+		0x91, 0x28, //input: STA ($28),Y (put back what the cursor covered)
+		0xAD, 0x00, 0xC0, //LDA $C000
+		0x30, 0x0D, //BMI key
+		0xAD, io+sscACIA+aciaStatus, 0xC0, //LDA $C0n9
+		0x29, aciaRDRF, //AND #RDRF
+		0xF0, 0xF4, //BEQ (wait for either)
+		0xAD, io+sscACIA+aciaData, 0xC0, //LDA $C0n8
+		0x09, 0x80, //ORA #$80
+		0x60,             //RTS
+		0x2C, 0x10, 0xC0, //key: BIT $C010
+		0x60) //RTS
+	for len(rom) < len(s.builtin) {
+		rom = append(rom, 0)
+	}
+}
+
+//setup the built in firmware was entered at $Cn00, hook whichever of CSW and KSW points there to
+//the output or input routine and set the 6551 up from the switches
+func (s *SSC) setup() {
+	cn := uint8(0xC0 + s.slot)
+	s.input = !(s.bus.Peek(0x36) == 0 && s.bus.Peek(0x37) == cn)
+	if s.input {
+		s.bus.Write(0x38, sscInput)
+		s.bus.Write(0x39, cn)
+	} else {
+		s.bus.Write(0x36, sscOutput)
+		s.bus.Write(0x37, cn)
+	}
+	s.acia.Write(aciaControl, s.Switches.control())
+	s.acia.Write(aciaCommand, s.Switches.command())
+}
+
+//Reset the 6551
+func (s *SSC) Reset() {
+	s.acia.Reset()
+}
+
+//IO the DIP switches and the 6551
+func (s *SSC) IO(reg uint8, data uint8, read bool) uint8 {
+	switch {
+	case reg >= sscACIA && read:
+		return s.acia.Read(reg - sscACIA)
+	case reg >= sscACIA:
+		s.acia.Write(reg-sscACIA, data)
+	case reg == sscSW1:
+		return s.Switches.bank1()
+	case reg == sscSW2:
+		return s.Switches.bank2()
+	case reg == sscSetup && s.rom == nil && read:
+		if s.input {
+			return 1
+		}
+	case reg == sscSetup && s.rom == nil:
+		s.setup()
+	}
+	return 0
+}
+
+//PeekIO the DIP switches and the 6551 without acknowledging anything
+func (s *SSC) PeekIO(reg uint8) uint8 {
+	switch {
+	case reg >= sscACIA:
+		return s.acia.Peek(reg - sscACIA)
+	case reg == sscSW1, reg == sscSW2, reg == sscSetup:
+		return s.IO(reg, 0, true)
+	}
+	return 0
+}
+
+//ROM the $Cn00 page of the firmware
+func (s *SSC) ROM(offset uint8, data uint8, read bool) uint8 {
+	return s.PeekROM(offset)
+}
+
+//PeekROM the $Cn00 page of the firmware
+func (s *SSC) PeekROM(offset uint8) uint8 {
+	if s.rom == nil {
+		return s.builtin[offset]
+	}
+	return s.rom[0x700+int(offset)]
+}
+
+//ExpansionROM the whole 2k of the firmware
+func (s *SSC) ExpansionROM(offset uint16, data uint8, read bool) uint8 {
+	return s.PeekExpansionROM(offset)
+}
+
+//PeekExpansionROM the whole 2k of the firmware
+func (s *SSC) PeekExpansionROM(offset uint16) uint8 {
+	if s.rom == nil {
+		return 0
+	}
+	return s.rom[offset]
+}
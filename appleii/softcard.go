@@ -0,0 +1,128 @@
+package appleii
+
+/* softcard.go -- The Microsoft SoftCard, a Z80 for CP/M
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//softCardClock Z80 T states for each 6502 cycle, the Z80 runs from the Apple's 2MHz clock
+const softCardClock = 2
+
+//SoftCard a Microsoft SoftCard, a Z80 that takes the bus from the 6502. A write to $Cn00 hands the
+//bus to the Z80 and a write by the Z80 to the same place (its $En00) hands it back, each processor
+//carries on from where it stopped. The Z80 sees the Apple's memory moved around so CP/M has RAM
+//from $0000 up:
+//
+//	Z80 $0000-$AFFF  Apple $1000-$BFFF
+//	Z80 $B000-$DFFF  Apple $D000-$FFFF (the language card)
+//	Z80 $E000-$EFFF  Apple $C000-$CFFF (I/O and slots)
+//	Z80 $F000-$FFFF  Apple $0000-$0FFF (zero page, stack and text screen)
+//
+//The Z80's I/O ports and interrupts aren't connected
+type SoftCard struct {
+	NoExpansionROM
+	bus  *Bus
+	z80  *Z80
+	regs SoftCardState
+}
+
+//NewSoftCard a SoftCard on b, the 6502 has the bus until it's handed over
+func NewSoftCard(b *Bus) *SoftCard {
+	s := SoftCard{bus: b}
+	s.z80 = NewZ80(softCardBus{b})
+	return &s
+}
+
+//Step run a Z80 instruction, the 6502's cycles are counted in halves of a Z80 T state
+func (s *SoftCard) Step() int {
+	t := s.z80.Step() + s.regs.T
+	s.regs.T = t % softCardClock
+	return t / softCardClock
+}
+
+//IO nothing is there
+func (s *SoftCard) IO(reg uint8, data uint8, read bool) uint8 {
+	return 0
+}
+
+//PeekIO nothing is there
+func (s *SoftCard) PeekIO(reg uint8) uint8 {
+	return 0
+}
+
+//PeekROM there isn't one
+func (s *SoftCard) PeekROM(offset uint8) uint8 {
+	return 0
+}
+
+//ROM there isn't one, writing $Cn00 swaps which processor has the bus
+func (s *SoftCard) ROM(offset uint8, data uint8, read bool) uint8 {
+	if !read && offset == 0 {
+		s.setActive(!s.regs.Active)
+	}
+	return 0
+}
+
+//setActive give the Z80 the bus or hand it back to the 6502
+func (s *SoftCard) setActive(active bool) {
+	s.regs.Active = active
+	if active {
+		s.bus.SetMaster(s)
+	} else {
+		s.bus.SetMaster(nil)
+	}
+}
+
+//Reset the 6502 gets the bus back and the Z80 starts again from $0000 next time
+func (s *SoftCard) Reset() {
+	s.z80.Reset()
+	s.regs.T = 0
+	s.setActive(false)
+}
+
+//softCardBus the Apple's bus as the Z80 sees it through the SoftCard
+type softCardBus struct {
+	bus *Bus
+}
+
+//softCardAddr the Apple address for a Z80 address
+func softCardAddr(addr uint16) uint16 {
+	switch {
+	case addr < 0xB000:
+		return addr + 0x1000
+	case addr < 0xE000:
+		return addr + 0x2000
+	case addr < 0xF000:
+		return addr - 0x2000
+	}
+	return addr + 0x1000
+}
+
+func (b softCardBus) Read(addr uint16) uint8 {
+	return b.bus.Read(softCardAddr(addr))
+}
+
+func (b softCardBus) Write(addr uint16, data uint8) {
+	b.bus.Write(softCardAddr(addr), data)
+}
+
+//In the SoftCard doesn't decode the Z80's I/O ports, reads see whatever was last on the bus
+func (b softCardBus) In(port uint16) uint8 {
+	return b.bus.Data()
+}
+
+func (b softCardBus) Out(port uint16, data uint8) {
+}
@@ -0,0 +1,516 @@
+package appleii
+
+/* state.go -- Snapshots of the emulated hardware for save states and movies
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+//State a snapshot of the whole machine, ROMs are not included
+type State struct {
+	Bus      BusState
+	CPU      CPUState
+	Mem      MemState
+	Dsk      DskState
+	Kbd      KbdState
+	Paddles  PaddlesState
+	Cassette CassetteState
+	Cards    [8][]byte //Saved by each StateCard, by slot
+}
+
+//Write encode the snapshot to w
+func (s *State) Write(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(s)
+}
+
+//ReadState decode a snapshot written by State.Write
+func ReadState(r io.Reader) (*State, error) {
+	var s State
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+//BusState the saved state of the Bus
+type BusState struct {
+	FastMode bool
+	IRQ      uint32
+	Timers   map[int]uint64 //Cycle each scheduled timer is due, by timer number
+}
+
+//State snapshot the bus
+func (b *Bus) State() BusState {
+	return BusState{FastMode: b.fastMode, IRQ: b.irq, Timers: b.pendingTimers()}
+}
+
+//SetState put the bus back the way it was
+func (b *Bus) SetState(s BusState) {
+	b.fastMode, b.irq = s.FastMode, s.IRQ
+	b.setPendingTimers(s.Timers)
+}
+
+//CPUState the saved state of the CPU
+type CPUState struct {
+	PC               uint16
+	AC, X, Y, SR, SP uint8
+	Cycles           uint64
+}
+
+//State snapshot the CPU
+func (c *CPU) State() CPUState {
+	return CPUState{PC: c.PC, SP: c.SP, AC: c.AC, X: c.X, Y: c.Y, SR: c.SR, Cycles: c.cycleCount}
+}
+
+//SetState put the CPU back the way it was
+func (c *CPU) SetState(s CPUState) {
+	c.PC, c.SP, c.AC, c.X, c.Y, c.SR = s.PC, s.SP, s.AC, s.X, s.Y, s.SR
+	c.cycleCount = s.Cycles
+}
+
+//Z80State the Z80's registers, a Z80 saves and restores them as they are
+type Z80State struct {
+	A, F, B, C, D, E, H, L uint8
+	AF2, BC2, DE2, HL2     uint16 //The other register set, EX AF,AF' and EXX swap it in
+	IX, IY, SP, PC         uint16
+	I, R                   uint8
+	IFF1, IFF2             bool
+	IM                     uint8
+	Halted                 bool
+}
+
+//State snapshot the Z80
+func (z *Z80) State() Z80State {
+	return z.Z80State
+}
+
+//SetState put the Z80 back the way it was
+func (z *Z80) SetState(s Z80State) {
+	z.Z80State = s
+}
+
+//MemState the saved state of memory and the soft switches
+type MemState struct {
+	Main, Aux     []byte   //Aux is bank 0
+	AuxBanks      [][]byte //The rest of the aux banks
+	AuxBank       int
+	KeyboardLatch uint8
+	PreWrite      bool
+	Switches      []bool //In the order of Mem.switches
+	Expansion     int    //Slot with the $C800 expansion ROM
+	IntC8ROM      bool
+}
+
+//switches every soft switch and key flag, the order is the save state format so only add to the end
+func (m *Mem) switches() []*bool {
+	return []*bool{
+		&m.RDMAIN, &m.WRMAIN, &m.MAINZP, &m.LCBNK2, &m.LCRAM, &m.LCWRITE,
+		&m.STORE80, &m.PAGE2, &m.HIRES, &m.VID80, &m.ALTCHAR, &m.TEXT, &m.MIXED, &m.DBLHIRES,
+		&m.INTCXROM, &m.SLOTC3ROM, &m.KBDOAPPLE, &m.KBDFAPPLE, &m.KBDSHIFT,
+	}
+}
+
+//State snapshot memory and the soft switches
+func (m *Mem) State() MemState {
+	s := MemState{KeyboardLatch: m.keyboardLatch, PreWrite: m.preWrite, Expansion: m.expansion, IntC8ROM: m.intC8ROM}
+	s.Main = append([]byte(nil), m.mem...)
+	s.Aux = append([]byte(nil), m.auxBanks[0]...)
+	for _, bank := range m.auxBanks[1:] {
+		s.AuxBanks = append(s.AuxBanks, append([]byte(nil), bank...))
+	}
+	s.AuxBank = m.auxBank
+	for _, f := range m.switches() {
+		s.Switches = append(s.Switches, *f)
+	}
+	return s
+}
+
+//SetState put memory and the soft switches back the way they were, the aux memory has to be the
+//same size it was saved with
+func (m *Mem) SetState(s MemState) error {
+	if banks := len(s.AuxBanks) + 1; banks != len(m.auxBanks) {
+		return fmt.Errorf("The state was saved with %dKB of aux memory, this machine has %dKB", banks*64,
+			len(m.auxBanks)*64)
+	}
+	copy(m.mem, s.Main)
+	copy(m.auxBanks[0], s.Aux)
+	for i, bank := range s.AuxBanks {
+		copy(m.auxBanks[i+1], bank)
+	}
+	m.selectAuxBank(uint8(s.AuxBank))
+	m.keyboardLatch, m.preWrite = s.KeyboardLatch, s.PreWrite
+	m.expansion, m.intC8ROM = s.Expansion, s.IntC8ROM
+	for i, f := range m.switches() {
+		if i < len(s.Switches) {
+			*f = s.Switches[i]
+		}
+	}
+	//Force the next frame to redraw everything and remap the pages
+	m.lastMode = ^uint16(0)
+	m.banks = ^uint32(0)
+	m.remap()
+	return nil
+}
+
+//DskState the saved state of the Disk ][ controller and its diskettes
+type DskState struct {
+	MotorOn, Drive2, Q6, Q7 bool
+	DataLatch               uint8
+	Phase, Track, Pos       int
+	Disk1, Disk2            *Diskette
+}
+
+//State snapshot the disk controller
+func (d *Dsk) State() DskState {
+	return DskState{MotorOn: d.motorOn, Drive2: d.drive2, Q6: d.q6, Q7: d.q7, DataLatch: d.dataLatch,
+		Phase: d.phase, Track: d.track, Pos: d.pos, Disk1: d.disk1, Disk2: d.disk2}
+}
+
+//SetState put the disk controller back the way it was
+func (d *Dsk) SetState(s DskState) {
+	d.motorOn, d.drive2, d.q6, d.q7, d.dataLatch = s.MotorOn, s.Drive2, s.Q6, s.Q7, s.DataLatch
+	d.phase, d.track, d.pos, d.disk1, d.disk2 = s.Phase, s.Track, s.Pos, s.Disk1, s.Disk2
+}
+
+//KbdState the saved state of the keyboard
+type KbdState struct {
+	CtrlDown  bool
+	Paste     []byte
+	LastPaste uint64
+}
+
+//State snapshot the keyboard
+func (k *Kbd) State() KbdState {
+	return KbdState{CtrlDown: k.ctrlDown, Paste: append([]byte(nil), k.paste...), LastPaste: k.lastPaste}
+}
+
+//SetState put the keyboard back the way it was
+func (k *Kbd) SetState(s KbdState) {
+	k.ctrlDown, k.paste, k.lastPaste = s.CtrlDown, s.Paste, s.LastPaste
+}
+
+//PaddlesState the saved state of the game port, the calibration is configuration and not saved
+type PaddlesState struct {
+	Value   [4]uint8
+	Buttons [3]bool
+	Timing  [4]bool //The timers themselves are saved with the bus
+}
+
+//State snapshot the game port
+func (p *Paddles) State() PaddlesState {
+	return PaddlesState{Value: p.value, Buttons: p.buttons, Timing: p.timing}
+}
+
+//SetState put the game port back the way it was
+func (p *Paddles) SetState(s PaddlesState) {
+	p.value, p.buttons, p.timing = s.Value, s.Buttons, s.Timing
+}
+
+//CassetteState the saved state of the cassette port, the tape itself isn't saved
+type CassetteState struct {
+	Output   bool
+	Playing  bool
+	Position uint64 //Cycles into the tape when it stopped or at Start
+	Start    uint64 //Cycle the tape started playing at
+}
+
+//State snapshot the cassette port
+func (c *Cassette) State() CassetteState {
+	return c.regs
+}
+
+//SetState put the cassette port back the way it was
+func (c *Cassette) SetState(s CassetteState) {
+	c.regs = s
+}
+
+//VIAState the saved state of a 6522, its timers are saved with the bus
+type VIAState struct {
+	ORA, ORB, DDRA, DDRB   uint8
+	T1Latch, T1Value       uint16
+	T1Base                 uint64 //Cycle timer 1 was loaded with T1Value
+	T2Latch                uint8  //Timer 2's low byte waiting for the high byte
+	T2Value                uint16
+	T2Base                 uint64 //Cycle timer 2 was loaded with T2Value
+	SR, ACR, PCR, IFR, IER uint8
+	CA1, CB1               bool
+}
+
+//State snapshot the 6522
+func (v *VIA) State() VIAState {
+	return v.regs
+}
+
+//SetState put the 6522 back the way it was
+func (v *VIA) SetState(s VIAState) {
+	v.regs = s
+	v.updateIRQ()
+}
+
+//AYState the saved state of an AY-3-8910
+type AYState struct {
+	Regs                 [16]uint8
+	Addr                 uint8 //Latched register
+	Function             uint8 //Bus control function
+	Last, Sub            uint64
+	ToneCount            [3]uint32
+	ToneOut              [3]bool
+	NoiseCount, EnvCount uint32
+	LFSR                 uint32
+	EnvStep              uint8
+	EnvAttack            bool
+	EnvHolding           bool
+	Sum                  [3]int
+	Ticks                int
+}
+
+//State snapshot the PSG
+func (ay *AY8910) State() AYState {
+	return ay.regs
+}
+
+//SetState put the PSG back the way it was
+func (ay *AY8910) SetState(s AYState) {
+	ay.regs = s
+}
+
+//SC01State the saved state of a speech chip
+type SC01State struct {
+	Phoneme uint8
+	Busy    bool
+}
+
+//State snapshot the speech chip
+func (s *SC01) State() SC01State {
+	return s.regs
+}
+
+//SetState put the speech chip back the way it was
+func (s *SC01) SetState(st SC01State) {
+	s.regs = st
+}
+
+//MockingboardState the saved state of a Mockingboard
+type MockingboardState struct {
+	VIA    [2]VIAState
+	PSG    [2]AYState
+	Speech SC01State
+}
+
+//CardState snapshot the Mockingboard
+func (mb *Mockingboard) CardState() ([]byte, error) {
+	s := MockingboardState{}
+	for i := range mb.via {
+		s.VIA[i], s.PSG[i] = mb.via[i].State(), mb.psg[i].State()
+	}
+	if mb.speech != nil {
+		s.Speech = mb.speech.State()
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&s)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the Mockingboard back the way it was
+func (mb *Mockingboard) SetCardState(data []byte) error {
+	var s MockingboardState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	for i := range mb.via {
+		mb.via[i].SetState(s.VIA[i])
+		mb.psg[i].SetState(s.PSG[i])
+	}
+	if mb.speech != nil {
+		mb.speech.SetState(s.Speech)
+	}
+	return nil
+}
+
+//HardDiskState the result of the hard disk card's last call, the volumes are files and aren't saved
+type HardDiskState struct {
+	Error, X, Y uint8
+}
+
+//CardState snapshot the hard disk card
+func (hd *HardDisk) CardState() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&hd.regs)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the hard disk card back the way it was
+func (hd *HardDisk) SetCardState(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&hd.regs)
+}
+
+//ACIAState the saved state of a 6551, its timers are saved with the bus
+type ACIAState struct {
+	Status, Command, Control uint8
+	RDR, TDR                 uint8
+	Shift                    uint8 //Character being sent
+	Sending                  bool
+}
+
+//State snapshot the 6551
+func (a *ACIA) State() ACIAState {
+	return a.regs
+}
+
+//SetState put the 6551 back the way it was
+func (a *ACIA) SetState(s ACIAState) {
+	a.regs = s
+	a.setIRQ(s.Status&aciaIRQ != 0)
+}
+
+//SSCState the saved state of a Super Serial Card, what it's connected to isn't saved
+type SSCState struct {
+	ACIA  ACIAState
+	Input bool
+}
+
+//CardState snapshot the serial card
+func (s *SSC) CardState() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&SSCState{ACIA: s.acia.State(), Input: s.input})
+	return buf.Bytes(), err
+}
+
+//SetCardState put the serial card back the way it was
+func (s *SSC) SetCardState(data []byte) error {
+	var st SSCState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&st); err != nil {
+		return err
+	}
+	s.acia.SetState(st.ACIA)
+	s.input = st.Input
+	s.acia.startReceiving()
+	return nil
+}
+
+//ClockState the saved state of a clock card, the epoch is kept so the clock carries on from the
+//time it was saved at
+type ClockState struct {
+	Epoch  int64 //Seconds since 1970 in local time at cycle 0
+	Mode   uint8
+	Length uint8 //Length of the last time read
+}
+
+//CardState snapshot the clock card
+func (c *ClockCard) CardState() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&c.regs)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the clock card back the way it was
+func (c *ClockCard) SetCardState(data []byte) error {
+	var s ClockState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	c.regs = s
+	return nil
+}
+
+//MouseState the saved state of a mouse card
+type MouseState struct {
+	X, Y                   int
+	MinX, MaxX, MinY, MaxY int
+	Mode                   uint8
+	Pending                uint8 //Interrupts SERVEMOUSE hasn't picked up yet
+	Result                 uint8 //Carry of the last call
+	Button, WasDown, Moved bool
+	MovedIRQ, ButtonIRQ    bool //Moved or clicked since the last VBL
+}
+
+//CardState snapshot the mouse card
+func (mc *MouseCard) CardState() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&mc.regs)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the mouse card back the way it was
+func (mc *MouseCard) SetCardState(data []byte) error {
+	var s MouseState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	mc.regs = s
+	return nil
+}
+
+//SaturnState the saved state of a Saturn card
+type SaturnState struct {
+	RAM                                []byte //The eight 16k banks
+	Bank                               int
+	Bank2, ReadRAM, WriteRAM, PreWrite bool //Language card switches
+}
+
+//CardState snapshot the Saturn card
+func (s *SaturnCard) CardState() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&s.regs)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the Saturn card back the way it was
+func (s *SaturnCard) SetCardState(data []byte) error {
+	var st SaturnState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&st); err != nil {
+		return err
+	}
+	if len(st.RAM) != saturnBanks*saturnBankSize {
+		return fmt.Errorf("Saturn card has %d bytes of RAM", len(st.RAM))
+	}
+	s.regs = st
+	s.mem.cardSwitched()
+	return nil
+}
+
+//SoftCardState the saved state of a SoftCard
+type SoftCardState struct {
+	Z80    Z80State
+	Active bool //The Z80 has the bus
+	T      int  //T states left over from the last 6502 cycle
+}
+
+//CardState snapshot the SoftCard
+func (s *SoftCard) CardState() ([]byte, error) {
+	s.regs.Z80 = s.z80.State()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&s.regs)
+	return buf.Bytes(), err
+}
+
+//SetCardState put the SoftCard back the way it was
+func (s *SoftCard) SetCardState(data []byte) error {
+	var st SoftCardState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&st); err != nil {
+		return err
+	}
+	s.regs = st
+	s.z80.SetState(st.Z80)
+	s.setActive(st.Active)
+	return nil
+}
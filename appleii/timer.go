@@ -0,0 +1,131 @@
+package appleii
+
+/* timer.go -- Cycle scheduler for devices that do things at a set time
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "container/heap"
+
+//noTimer the next timer cycle when nothing is scheduled
+const noTimer = ^uint64(0)
+
+//Timer calls a device back at a CPU cycle count, CPU.Tick runs it after the instruction that
+//reaches that cycle. Timers are made once when the device is built so save states can refer to
+//them by number
+type Timer struct {
+	bus   *Bus
+	id    int //Creation order, also breaks ties between timers due on the same cycle
+	fn    func()
+	cycle uint64
+	index int //Position in the bus's queue, -1 when not scheduled
+}
+
+//timerQueue is a heap of the scheduled timers, soonest first
+type timerQueue []*Timer
+
+func (q timerQueue) Len() int { return len(q) }
+func (q timerQueue) Less(i, j int) bool {
+	return q[i].cycle < q[j].cycle || (q[i].cycle == q[j].cycle && q[i].id < q[j].id)
+}
+func (q timerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *timerQueue) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	t.index = -1
+	*q = old[:len(old)-1]
+	return t
+}
+
+//NewTimer make a timer that calls fn, it does nothing until it is scheduled
+func (b *Bus) NewTimer(fn func()) *Timer {
+	t := Timer{bus: b, id: len(b.timers), fn: fn, index: -1}
+	b.timers = append(b.timers, &t)
+	return &t
+}
+
+//Schedule run the timer at cycle, replacing any time it was already scheduled for
+func (t *Timer) Schedule(cycle uint64) {
+	t.cycle = cycle
+	if t.index >= 0 {
+		heap.Fix(&t.bus.queue, t.index)
+	} else {
+		heap.Push(&t.bus.queue, t)
+	}
+	t.bus.nextTimer = t.bus.queue[0].cycle
+}
+
+//Cancel stop the timer from running
+func (t *Timer) Cancel() {
+	if t.index < 0 {
+		return
+	}
+	heap.Remove(&t.bus.queue, t.index)
+	t.bus.nextTimer = noTimer
+	if len(t.bus.queue) > 0 {
+		t.bus.nextTimer = t.bus.queue[0].cycle
+	}
+}
+
+//Pending is the timer scheduled
+func (t *Timer) Pending() bool {
+	return t.index >= 0
+}
+
+//Cycle the cycle the timer is scheduled for
+func (t *Timer) Cycle() uint64 {
+	return t.cycle
+}
+
+//runTimers run every timer due by cycle, in order
+func (b *Bus) runTimers(cycle uint64) {
+	for len(b.queue) > 0 && b.queue[0].cycle <= cycle {
+		t := heap.Pop(&b.queue).(*Timer)
+		b.nextTimer = noTimer
+		if len(b.queue) > 0 {
+			b.nextTimer = b.queue[0].cycle
+		}
+		t.fn()
+	}
+}
+
+//pendingTimers the cycle each scheduled timer is due, by timer number
+func (b *Bus) pendingTimers() map[int]uint64 {
+	pending := make(map[int]uint64)
+	for _, t := range b.queue {
+		pending[t.id] = t.cycle
+	}
+	return pending
+}
+
+//setPendingTimers schedule exactly the timers in pending
+func (b *Bus) setPendingTimers(pending map[int]uint64) {
+	for _, t := range b.timers {
+		if cycle, ok := pending[t.id]; ok {
+			t.Schedule(cycle)
+		} else {
+			t.Cancel()
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package appleii
+
+/* via.go -- The 6522 Versatile Interface Adapter
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//6522 registers
+const (
+	viaORB  = 0x0 //Port B
+	viaORA  = 0x1 //Port A
+	viaDDRB = 0x2 //Port B direction, 1 bits are outputs
+	viaDDRA = 0x3 //Port A direction
+	viaT1CL = 0x4 //Timer 1 counter low, writes go to the latch
+	viaT1CH = 0x5 //Timer 1 counter high, writing starts the timer
+	viaT1LL = 0x6 //Timer 1 latch low
+	viaT1LH = 0x7 //Timer 1 latch high
+	viaT2CL = 0x8 //Timer 2 counter low, writes go to the latch
+	viaT2CH = 0x9 //Timer 2 counter high, writing starts the timer
+	viaSR   = 0xA //Shift register
+	viaACR  = 0xB //Auxiliary control
+	viaPCR  = 0xC //Peripheral control
+	viaIFR  = 0xD //Interrupt flags
+	viaIER  = 0xE //Interrupt enable
+	viaORAN = 0xF //Port A without handshaking
+)
+
+//6522 interrupt flags
+const (
+	viaIntCA2 uint8 = 1 << iota
+	viaIntCA1
+	viaIntSR
+	viaIntCB2
+	viaIntCB1
+	viaIntT2
+	viaIntT1
+	viaIntAny
+)
+
+//viaFreeRun ACR bit that makes timer 1 reload from its latch and keep interrupting
+const viaFreeRun = 0x40
+
+//VIA a 6522, the timers count CPU cycles. The shift register holds what's written but doesn't shift
+type VIA struct {
+	regs   VIAState
+	t1, t2 *Timer
+	//OutputA and OutputB are called when a port is written, out has the output pins, optional
+	OutputA, OutputB func(out uint8)
+	//InputA and InputB give the level of a port's pins, optional (inputs read high)
+	InputA, InputB func() uint8
+	//IRQ is called whenever the interrupt output is worked out, optional
+	IRQ func(asserted bool)
+}
+
+//NewVIA a 6522 timed by the bus
+func NewVIA(b *Bus) *VIA {
+	v := VIA{}
+	v.t1 = b.NewTimer(v.timer1)
+	v.t2 = b.NewTimer(v.timer2)
+	return &v
+}
+
+//Reset clears every register except the timers and the shift register, the timers stop interrupting
+func (v *VIA) Reset() {
+	r := &v.regs
+	r.ORA, r.ORB, r.DDRA, r.DDRB = 0, 0, 0, 0
+	r.ACR, r.PCR, r.IFR, r.IER = 0, 0, 0, 0
+	v.t1.Cancel()
+	v.t2.Cancel()
+	v.updateIRQ()
+}
+
+//Read a register, reading the counters and ports clears their interrupts
+func (v *VIA) Read(reg uint8, cycle uint64) uint8 {
+	data := v.Peek(reg, cycle)
+	switch reg & 0xF {
+	case viaORB:
+		v.clearIRQ(viaIntCB1 | viaIntCB2)
+	case viaORA:
+		v.clearIRQ(viaIntCA1 | viaIntCA2)
+	case viaT1CL:
+		v.clearIRQ(viaIntT1)
+	case viaT2CL:
+		v.clearIRQ(viaIntT2)
+	}
+	return data
+}
+
+//Peek what Read would return without clearing any interrupts
+func (v *VIA) Peek(reg uint8, cycle uint64) uint8 {
+	r := &v.regs
+	switch reg & 0xF {
+	case viaORB:
+		return v.portIn(r.ORB, r.DDRB, v.InputB)
+	case viaORA, viaORAN:
+		return v.portIn(r.ORA, r.DDRA, v.InputA)
+	case viaDDRB:
+		return r.DDRB
+	case viaDDRA:
+		return r.DDRA
+	case viaT1CL:
+		return uint8(v.counter1(cycle))
+	case viaT1CH:
+		return uint8(v.counter1(cycle) >> 8)
+	case viaT1LL:
+		return uint8(r.T1Latch)
+	case viaT1LH:
+		return uint8(r.T1Latch >> 8)
+	case viaT2CL:
+		return uint8(v.counter2(cycle))
+	case viaT2CH:
+		return uint8(v.counter2(cycle) >> 8)
+	case viaSR:
+		return r.SR
+	case viaACR:
+		return r.ACR
+	case viaPCR:
+		return r.PCR
+	case viaIFR:
+		if r.IFR&r.IER&^viaIntAny != 0 {
+			return r.IFR | viaIntAny
+		}
+		return r.IFR
+	default: //viaIER
+		return r.IER | viaIntAny
+	}
+}
+
+//Write a register, writing the high byte of a counter starts it
+func (v *VIA) Write(reg uint8, data uint8, cycle uint64) {
+	r := &v.regs
+	switch reg & 0xF {
+	case viaORB:
+		r.ORB = data
+		v.clearIRQ(viaIntCB1 | viaIntCB2)
+		v.outputB()
+	case viaORA:
+		r.ORA = data
+		v.clearIRQ(viaIntCA1 | viaIntCA2)
+		v.outputA()
+	case viaORAN:
+		r.ORA = data
+		v.outputA()
+	case viaDDRB:
+		r.DDRB = data
+		v.outputB()
+	case viaDDRA:
+		r.DDRA = data
+		v.outputA()
+	case viaT1CL, viaT1LL:
+		r.T1Latch = r.T1Latch&0xFF00 | uint16(data)
+	case viaT1CH:
+		r.T1Latch = r.T1Latch&0x00FF | uint16(data)<<8
+		v.clearIRQ(viaIntT1)
+		r.T1Base, r.T1Value = cycle, r.T1Latch
+		v.t1.Schedule(cycle + uint64(r.T1Value) + 1)
+	case viaT1LH:
+		r.T1Latch = r.T1Latch&0x00FF | uint16(data)<<8
+		v.clearIRQ(viaIntT1)
+	case viaT2CL:
+		r.T2Latch = data
+	case viaT2CH:
+		v.clearIRQ(viaIntT2)
+		r.T2Base, r.T2Value = cycle, uint16(data)<<8|uint16(r.T2Latch)
+		v.t2.Schedule(cycle + uint64(r.T2Value) + 1)
+	case viaSR:
+		r.SR = data
+	case viaACR:
+		r.ACR = data
+	case viaPCR:
+		r.PCR = data
+	case viaIFR:
+		v.clearIRQ(data)
+	default: //viaIER
+		if data&viaIntAny != 0 {
+			r.IER |= data &^ viaIntAny
+		} else {
+			r.IER &^= data
+		}
+		v.updateIRQ()
+	}
+}
+
+//SetCA1 drive the CA1 pin, the edge picked by PCR bit 0 sets its interrupt flag
+func (v *VIA) SetCA1(level bool) {
+	if level != v.regs.CA1 {
+		v.regs.CA1 = level
+		if level == (v.regs.PCR&0x01 != 0) {
+			v.setIRQ(viaIntCA1)
+		}
+	}
+}
+
+//SetCB1 drive the CB1 pin, the edge picked by PCR bit 4 sets its interrupt flag
+func (v *VIA) SetCB1(level bool) {
+	if level != v.regs.CB1 {
+		v.regs.CB1 = level
+		if level == (v.regs.PCR&0x10 != 0) {
+			v.setIRQ(viaIntCB1)
+		}
+	}
+}
+
+//OutA the level of port A's output pins, pins set as inputs read high
+func (v *VIA) OutA() uint8 {
+	return v.regs.ORA | ^v.regs.DDRA
+}
+
+//OutB the level of port B's output pins, pins set as inputs read high
+func (v *VIA) OutB() uint8 {
+	return v.regs.ORB | ^v.regs.DDRB
+}
+
+//DDRB port B's direction register
+func (v *VIA) DDRB() uint8 {
+	return v.regs.DDRB
+}
+
+//portIn what the CPU reads from a port, the output register for outputs and the pins for inputs
+func (v *VIA) portIn(or uint8, ddr uint8, input func() uint8) uint8 {
+	in := uint8(0xFF)
+	if input != nil {
+		in = input()
+	}
+	return or&ddr | in&^ddr
+}
+
+func (v *VIA) outputA() {
+	if v.OutputA != nil {
+		v.OutputA(v.OutA())
+	}
+}
+
+func (v *VIA) outputB() {
+	if v.OutputB != nil {
+		v.OutputB(v.OutB())
+	}
+}
+
+//counter1 timer 1 at cycle, it passes through $FFFF before reloading
+func (v *VIA) counter1(cycle uint64) uint16 {
+	return v.regs.T1Value - uint16(cycle-v.regs.T1Base)
+}
+
+//counter2 timer 2 at cycle, it carries on counting down after it runs out
+func (v *VIA) counter2(cycle uint64) uint16 {
+	return v.regs.T2Value - uint16(cycle-v.regs.T2Base)
+}
+
+//timer1 timer 1 ran out, in free run mode it reloads from the latch and goes again
+func (v *VIA) timer1() {
+	r := &v.regs
+	v.setIRQ(viaIntT1)
+	if r.ACR&viaFreeRun != 0 {
+		r.T1Base, r.T1Value = v.t1.Cycle()+1, r.T1Latch
+		v.t1.Schedule(r.T1Base + uint64(r.T1Value) + 1)
+	}
+}
+
+//timer2 timer 2 ran out, it only interrupts once
+func (v *VIA) timer2() {
+	v.setIRQ(viaIntT2)
+}
+
+func (v *VIA) setIRQ(flags uint8) {
+	v.regs.IFR |= flags
+	v.updateIRQ()
+}
+
+func (v *VIA) clearIRQ(flags uint8) {
+	v.regs.IFR &^= flags
+	v.updateIRQ()
+}
+
+func (v *VIA) updateIRQ() {
+	if v.IRQ != nil {
+		v.IRQ(v.regs.IFR&v.regs.IER&^viaIntAny != 0)
+	}
+}
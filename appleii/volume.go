@@ -0,0 +1,130 @@
+package appleii
+
+/* volume.go -- ProDOS block device images (.hdv, .po and .2mg)
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	//BlockSize bytes in a ProDOS block
+	BlockSize = 512
+	//MaxBlocks the most blocks a ProDOS volume can have (32MB)
+	MaxBlocks = 65535
+)
+
+//2IMG header fields
+const (
+	twoIMGHeaderSize = 64
+	twoIMGProDOS     = 1       //Image format with the blocks in ProDOS order
+	twoIMGLocked     = 1 << 31 //Flag for a write protected image
+)
+
+//Volume a ProDOS block device image, blocks are read from and written straight to the file
+type Volume struct {
+	//Filename the image file
+	Filename string
+	//ReadOnly writes fail as write protected, the image is locked or the file can't be written
+	ReadOnly bool
+	file     *os.File
+	offset   int64 //Where block 0 starts in the file
+	blocks   int
+}
+
+//OpenVolume open a .hdv or .po image (raw blocks in ProDOS order) or a ProDOS order .2mg image
+func OpenVolume(filename string) (*Volume, error) {
+	v := Volume{Filename: filename}
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if os.IsPermission(err) || errors.Is(err, syscall.EROFS) {
+		file, err = os.Open(filename)
+		v.ReadOnly = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open volume: %v", err)
+	}
+	v.file = file
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to open volume: %v", err)
+	}
+	size := info.Size()
+
+	if strings.EqualFold(filepath.Ext(filename), ".2mg") {
+		header := make([]byte, twoIMGHeaderSize)
+		if _, err := file.ReadAt(header, 0); err != nil || string(header[:4]) != "2IMG" {
+			file.Close()
+			return nil, fmt.Errorf("%s is not a 2IMG image", filename)
+		}
+		if binary.LittleEndian.Uint32(header[12:]) != twoIMGProDOS {
+			file.Close()
+			return nil, fmt.Errorf("%s is not in ProDOS order", filename)
+		}
+		if binary.LittleEndian.Uint32(header[16:])&twoIMGLocked != 0 {
+			v.ReadOnly = true
+		}
+		v.offset = int64(binary.LittleEndian.Uint32(header[24:]))
+		size = int64(binary.LittleEndian.Uint32(header[28:]))
+		if size == 0 {
+			//Some tools leave the data length out, the ProDOS block count has the size
+			size = int64(binary.LittleEndian.Uint32(header[20:])) * BlockSize
+		}
+	}
+
+	if size == 0 || size%BlockSize != 0 || size/BlockSize > MaxBlocks {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a valid ProDOS volume (%d bytes)", filename, size)
+	}
+	v.blocks = int(size / BlockSize)
+	return &v, nil
+}
+
+//Blocks the size of the volume
+func (v *Volume) Blocks() int {
+	return v.blocks
+}
+
+//ReadBlock read block into buf
+func (v *Volume) ReadBlock(block int, buf []byte) error {
+	if block >= v.blocks {
+		return fmt.Errorf("Block %d is past the end of %s", block, v.Filename)
+	}
+	_, err := v.file.ReadAt(buf[:BlockSize], v.offset+int64(block)*BlockSize)
+	return err
+}
+
+//WriteBlock write buf to block
+func (v *Volume) WriteBlock(block int, buf []byte) error {
+	if block >= v.blocks {
+		return fmt.Errorf("Block %d is past the end of %s", block, v.Filename)
+	}
+	_, err := v.file.WriteAt(buf[:BlockSize], v.offset+int64(block)*BlockSize)
+	return err
+}
+
+//Close the image file
+func (v *Volume) Close() error {
+	return v.file.Close()
+}
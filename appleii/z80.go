@@ -0,0 +1,1006 @@
+package appleii
+
+/* z80.go -- A Zilog Z80 CPU
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+/* Opcodes are decoded by their fields the way the Z80 does it, x (bits 7-6), y (bits 5-3) and
+   r (bits 2-0) with y split into p (bits 5-4) and q (bit 3). r and y pick one of B, C, D, E, H, L,
+   (HL) and A, p one of the register pairs BC, DE, HL and SP (or AF for PUSH and POP). The DD and
+   FD prefixes make the next instruction use IX or IY in place of HL, (HL) becomes (IX+d) and H
+   and L become the undocumented halves of IX. */
+
+//Z80Bus the memory and I/O ports a Z80 is wired to
+type Z80Bus interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, data uint8)
+	In(port uint16) uint8
+	Out(port uint16, data uint8)
+}
+
+//Z80 Flags
+const (
+	z80FlagC uint8 = 1 << 0 //Carry
+	z80FlagN uint8 = 1 << 1 //Subtract, DAA needs to know
+	z80FlagP uint8 = 1 << 2 //Parity or overflow
+	z80FlagX uint8 = 1 << 3 //Undocumented, usually bit 3 of the result
+	z80FlagH uint8 = 1 << 4 //Half carry
+	z80FlagY uint8 = 1 << 5 //Undocumented, usually bit 5 of the result
+	z80FlagZ uint8 = 1 << 6 //Zero
+	z80FlagS uint8 = 1 << 7 //Sign
+)
+
+//The register pair standing in for HL
+const (
+	z80HL = iota
+	z80IX
+	z80IY
+)
+
+//z80SZXY the sign, zero and undocumented flags for each result, z80SZXYP adds the parity
+var z80SZXY, z80SZXYP [256]uint8
+
+func init() {
+	for i := range z80SZXY {
+		f := uint8(i) & (z80FlagS | z80FlagX | z80FlagY)
+		if i == 0 {
+			f |= z80FlagZ
+		}
+		z80SZXY[i] = f
+		p := uint8(i)
+		p ^= p >> 4
+		p ^= p >> 2
+		p ^= p >> 1
+		if p&1 == 0 {
+			f |= z80FlagP
+		}
+		z80SZXYP[i] = f
+	}
+}
+
+//Z80 a Zilog Z80, every documented instruction and the undocumented ones programs lean on (the
+//halves of IX and IY, SLL and the DDCB copies to a register). Interrupts aren't wired to anything
+type Z80 struct {
+	Z80State
+	bus Z80Bus
+	idx int //The pair standing in for HL in this instruction
+	t   int //T states this instruction has taken so far
+}
+
+//NewZ80 a Z80 on b, fresh from a reset
+func NewZ80(b Z80Bus) *Z80 {
+	z := Z80{bus: b}
+	z.Reset()
+	return &z
+}
+
+//Reset start again from $0000 with interrupts off
+func (z *Z80) Reset() {
+	z.PC, z.SP = 0, 0xFFFF
+	z.A, z.F = 0xFF, 0xFF
+	z.I, z.R = 0, 0
+	z.IFF1, z.IFF2, z.IM = false, false, 0
+	z.Halted = false
+}
+
+//Step run one instruction and give the T states it took
+func (z *Z80) Step() int {
+	z.t, z.idx = 0, z80HL
+	if z.Halted {
+		//HALT runs NOPs until an interrupt
+		z.incR()
+		return 4
+	}
+	op := z.fetchOp()
+	for op == 0xDD || op == 0xFD {
+		z.idx = z80IX
+		if op == 0xFD {
+			z.idx = z80IY
+		}
+		z.t += 4
+		op = z.fetchOp()
+	}
+	switch {
+	case op == 0xCB && z.idx != z80HL:
+		z.indexedCB()
+	case op == 0xCB:
+		z.cb(z.fetchOp())
+	case op == 0xED:
+		//DD and FD have no effect on ED instructions
+		z.idx = z80HL
+		z.ed(z.fetchOp())
+	default:
+		z.main(op)
+	}
+	return z.t
+}
+
+//main the unprefixed instructions (and the DD and FD versions of them)
+func (z *Z80) main(op uint8) {
+	x, y, r := op>>6, op>>3&7, op&7
+	p, q := y>>1, y&1
+	switch x {
+	case 0:
+		switch r {
+		case 0:
+			switch y {
+			case 0: //NOP
+				z.t += 4
+			case 1: //EX AF,AF'
+				af := z.af()
+				z.setAF(z.AF2)
+				z.AF2 = af
+				z.t += 4
+			case 2: //DJNZ d
+				d := z.fetch()
+				z.B--
+				z.t += 8
+				if z.B != 0 {
+					z.jr(d)
+					z.t += 5
+				}
+			case 3: //JR d
+				z.jr(z.fetch())
+				z.t += 12
+			default: //JR cc,d
+				d := z.fetch()
+				z.t += 7
+				if z.cond(y - 4) {
+					z.jr(d)
+					z.t += 5
+				}
+			}
+		case 1:
+			if q == 0 { //LD rr,nn
+				z.setRP(p, z.fetch16())
+				z.t += 10
+			} else { //ADD HL,rr
+				z.setHLX(z.add16(z.hlx(), z.rp(p)))
+				z.t += 11
+			}
+		case 2:
+			switch y {
+			case 0: //LD (BC),A
+				z.bus.Write(z.bc(), z.A)
+				z.t += 7
+			case 1: //LD A,(BC)
+				z.A = z.bus.Read(z.bc())
+				z.t += 7
+			case 2: //LD (DE),A
+				z.bus.Write(z.de(), z.A)
+				z.t += 7
+			case 3: //LD A,(DE)
+				z.A = z.bus.Read(z.de())
+				z.t += 7
+			case 4: //LD (nn),HL
+				z.write16(z.fetch16(), z.hlx())
+				z.t += 16
+			case 5: //LD HL,(nn)
+				z.setHLX(z.read16(z.fetch16()))
+				z.t += 16
+			case 6: //LD (nn),A
+				z.bus.Write(z.fetch16(), z.A)
+				z.t += 13
+			case 7: //LD A,(nn)
+				z.A = z.bus.Read(z.fetch16())
+				z.t += 13
+			}
+		case 3: //INC rr and DEC rr
+			if q == 0 {
+				z.setRP(p, z.rp(p)+1)
+			} else {
+				z.setRP(p, z.rp(p)-1)
+			}
+			z.t += 6
+		case 4: //INC r
+			if y == 6 {
+				addr := z.memAddr()
+				z.bus.Write(addr, z.inc8(z.bus.Read(addr)))
+				z.t += 11
+			} else {
+				z.setReg(y, z.inc8(z.reg(y)))
+				z.t += 4
+			}
+		case 5: //DEC r
+			if y == 6 {
+				addr := z.memAddr()
+				z.bus.Write(addr, z.dec8(z.bus.Read(addr)))
+				z.t += 11
+			} else {
+				z.setReg(y, z.dec8(z.reg(y)))
+				z.t += 4
+			}
+		case 6: //LD r,n
+			if y == 6 {
+				addr := z.memAddr()
+				z.bus.Write(addr, z.fetch())
+				z.t += 10
+				if z.idx != z80HL {
+					//The displacement and n are fetched while the address is worked out
+					z.t -= 3
+				}
+			} else {
+				z.setReg(y, z.fetch())
+				z.t += 7
+			}
+		case 7:
+			z.accumulator(y)
+			z.t += 4
+		}
+	case 1:
+		switch {
+		case op == 0x76: //HALT
+			z.Halted = true
+			z.t += 4
+		case y == 6: //LD (HL),r, r is never the half of IX
+			addr := z.memAddr()
+			z.bus.Write(addr, z.plainReg(r))
+			z.t += 7
+		case r == 6: //LD r,(HL)
+			addr := z.memAddr()
+			z.setPlainReg(y, z.bus.Read(addr))
+			z.t += 7
+		default: //LD r,r'
+			z.setReg(y, z.reg(r))
+			z.t += 4
+		}
+	case 2: //ALU A,r
+		if r == 6 {
+			z.alu(y, z.bus.Read(z.memAddr()))
+			z.t += 7
+		} else {
+			z.alu(y, z.reg(r))
+			z.t += 4
+		}
+	case 3:
+		switch r {
+		case 0: //RET cc
+			z.t += 5
+			if z.cond(y) {
+				z.PC = z.pop()
+				z.t += 6
+			}
+		case 1:
+			switch {
+			case q == 0: //POP rr
+				z.setRP2(p, z.pop())
+				z.t += 10
+			case p == 0: //RET
+				z.PC = z.pop()
+				z.t += 10
+			case p == 1: //EXX
+				bc, de, hl := z.bc(), z.de(), z.hl()
+				z.setBC(z.BC2)
+				z.setDE(z.DE2)
+				z.setHL(z.HL2)
+				z.BC2, z.DE2, z.HL2 = bc, de, hl
+				z.t += 4
+			case p == 2: //JP (HL)
+				z.PC = z.hlx()
+				z.t += 4
+			case p == 3: //LD SP,HL
+				z.SP = z.hlx()
+				z.t += 6
+			}
+		case 2: //JP cc,nn
+			nn := z.fetch16()
+			if z.cond(y) {
+				z.PC = nn
+			}
+			z.t += 10
+		case 3:
+			switch y {
+			case 0: //JP nn
+				z.PC = z.fetch16()
+				z.t += 10
+			case 2: //OUT (n),A
+				n := z.fetch()
+				z.bus.Out(uint16(z.A)<<8|uint16(n), z.A)
+				z.t += 11
+			case 3: //IN A,(n)
+				n := z.fetch()
+				z.A = z.bus.In(uint16(z.A)<<8 | uint16(n))
+				z.t += 11
+			case 4: //EX (SP),HL
+				v := z.read16(z.SP)
+				z.write16(z.SP, z.hlx())
+				z.setHLX(v)
+				z.t += 19
+			case 5: //EX DE,HL, always HL
+				de := z.de()
+				z.setDE(z.hl())
+				z.setHL(de)
+				z.t += 4
+			case 6: //DI
+				z.IFF1, z.IFF2 = false, false
+				z.t += 4
+			case 7: //EI
+				z.IFF1, z.IFF2 = true, true
+				z.t += 4
+			}
+		case 4: //CALL cc,nn
+			nn := z.fetch16()
+			z.t += 10
+			if z.cond(y) {
+				z.push(z.PC)
+				z.PC = nn
+				z.t += 7
+			}
+		case 5:
+			if q == 0 { //PUSH rr
+				z.push(z.rp2(p))
+				z.t += 11
+			} else { //CALL nn, the other p are the prefixes
+				nn := z.fetch16()
+				z.push(z.PC)
+				z.PC = nn
+				z.t += 17
+			}
+		case 6: //ALU A,n
+			z.alu(y, z.fetch())
+			z.t += 7
+		case 7: //RST
+			z.push(z.PC)
+			z.PC = uint16(y) * 8
+			z.t += 11
+		}
+	}
+}
+
+//cb the CB instructions, rotates, shifts and bits
+func (z *Z80) cb(op uint8) {
+	x, y, r := op>>6, op>>3&7, op&7
+	var v uint8
+	if r == 6 {
+		v = z.bus.Read(z.hl())
+		z.t += 7
+	} else {
+		v = z.reg(r)
+	}
+	z.t += 8
+	switch x {
+	case 0:
+		v = z.rot(y, v)
+	case 1:
+		xy := v
+		if r == 6 {
+			xy = uint8(z.hl() >> 8)
+		}
+		z.bit(y, v, xy)
+		if r == 6 {
+			z.t -= 3
+		}
+		return
+	case 2:
+		v &^= 1 << y
+	case 3:
+		v |= 1 << y
+	}
+	if r == 6 {
+		z.bus.Write(z.hl(), v)
+	} else {
+		z.setReg(r, v)
+	}
+}
+
+//indexedCB DD CB d op and FD CB d op, the result also goes to r unless r is (HL)
+func (z *Z80) indexedCB() {
+	addr := z.hlx() + uint16(int8(z.fetch()))
+	op := z.fetch()
+	x, y, r := op>>6, op>>3&7, op&7
+	v := z.bus.Read(addr)
+	switch x {
+	case 0:
+		v = z.rot(y, v)
+	case 1:
+		z.bit(y, v, uint8(addr>>8))
+		z.t += 16
+		return
+	case 2:
+		v &^= 1 << y
+	case 3:
+		v |= 1 << y
+	}
+	z.bus.Write(addr, v)
+	if r != 6 {
+		z.setPlainReg(r, v)
+	}
+	z.t += 19
+}
+
+//ed the ED instructions, anything not listed is an 8 T state NOP
+func (z *Z80) ed(op uint8) {
+	x, y, r := op>>6, op>>3&7, op&7
+	p, q := y>>1, y&1
+	if x == 2 && y >= 4 && r <= 3 {
+		z.block(y, r)
+		return
+	}
+	if x != 1 {
+		z.t += 8
+		return
+	}
+	switch r {
+	case 0: //IN r,(C), (HL) only sets the flags
+		v := z.bus.In(z.bc())
+		if y != 6 {
+			z.setReg(y, v)
+		}
+		z.F = z.F&z80FlagC | z80SZXYP[v]
+		z.t += 12
+	case 1: //OUT (C),r, (HL) sends 0
+		v := uint8(0)
+		if y != 6 {
+			v = z.reg(y)
+		}
+		z.bus.Out(z.bc(), v)
+		z.t += 12
+	case 2:
+		if q == 0 {
+			z.sbc16(z.rp(p))
+		} else {
+			z.adc16(z.rp(p))
+		}
+		z.t += 15
+	case 3:
+		nn := z.fetch16()
+		if q == 0 { //LD (nn),rr
+			z.write16(nn, z.rp(p))
+		} else { //LD rr,(nn)
+			z.setRP(p, z.read16(nn))
+		}
+		z.t += 20
+	case 4: //NEG
+		a := z.A
+		z.A = 0
+		z.A = z.sub8(a, 0)
+		z.t += 8
+	case 5: //RETN and RETI
+		z.PC = z.pop()
+		z.IFF1 = z.IFF2
+		z.t += 14
+	case 6: //IM
+		z.IM = [8]uint8{0, 0, 1, 2, 0, 0, 1, 2}[y]
+		z.t += 8
+	case 7:
+		switch y {
+		case 0: //LD I,A
+			z.I = z.A
+			z.t += 9
+		case 1: //LD R,A
+			z.R = z.A
+			z.t += 9
+		case 2, 3: //LD A,I and LD A,R
+			z.A = z.I
+			if y == 3 {
+				z.A = z.R
+			}
+			z.F = z.F&z80FlagC | z80SZXY[z.A]
+			if z.IFF2 {
+				z.F |= z80FlagP
+			}
+			z.t += 9
+		case 4: //RRD
+			v := z.bus.Read(z.hl())
+			z.bus.Write(z.hl(), z.A<<4|v>>4)
+			z.A = z.A&0xF0 | v&0x0F
+			z.F = z.F&z80FlagC | z80SZXYP[z.A]
+			z.t += 18
+		case 5: //RLD
+			v := z.bus.Read(z.hl())
+			z.bus.Write(z.hl(), v<<4|z.A&0x0F)
+			z.A = z.A&0xF0 | v>>4
+			z.F = z.F&z80FlagC | z80SZXYP[z.A]
+			z.t += 18
+		default:
+			z.t += 8
+		}
+	}
+}
+
+//block LDI, CPI, INI and OUTI, y picks up or down (LDD) and once or repeating (LDIR and LDDR)
+func (z *Z80) block(y, r uint8) {
+	step := uint16(1)
+	if y&1 != 0 {
+		step = 0xFFFF
+	}
+	again := false
+	switch r {
+	case 0: //LDI
+		v := z.bus.Read(z.hl())
+		z.bus.Write(z.de(), v)
+		z.setHL(z.hl() + step)
+		z.setDE(z.de() + step)
+		z.setBC(z.bc() - 1)
+		n := v + z.A
+		z.F = z.F&(z80FlagS|z80FlagZ|z80FlagC) | n&z80FlagX | n<<4&z80FlagY
+		if z.bc() != 0 {
+			z.F |= z80FlagP
+			again = true
+		}
+	case 1: //CPI
+		v := z.bus.Read(z.hl())
+		res := z.A - v
+		z.setHL(z.hl() + step)
+		z.setBC(z.bc() - 1)
+		f := z.F&z80FlagC | z80FlagN | z80SZXY[res]&(z80FlagS|z80FlagZ) | (z.A^v^res)&z80FlagH
+		n := res
+		if f&z80FlagH != 0 {
+			n--
+		}
+		f |= n&z80FlagX | n<<4&z80FlagY
+		if z.bc() != 0 {
+			f |= z80FlagP
+			again = res != 0
+		}
+		z.F = f
+	case 2: //INI
+		v := z.bus.In(z.bc())
+		z.bus.Write(z.hl(), v)
+		z.setHL(z.hl() + step)
+		z.B--
+		z.F = z.F&z80FlagC | z80SZXY[z.B] | z80FlagN
+		again = z.B != 0
+	case 3: //OUTI, B counts down before it goes out on the port
+		v := z.bus.Read(z.hl())
+		z.B--
+		z.bus.Out(z.bc(), v)
+		z.setHL(z.hl() + step)
+		z.F = z.F&z80FlagC | z80SZXY[z.B] | z80FlagN
+		again = z.B != 0
+	}
+	z.t += 16
+	if y >= 6 && again {
+		//Run it again, interrupts get a look in between
+		z.PC -= 2
+		z.t += 5
+	}
+}
+
+//accumulator RLCA, RRCA, RLA, RRA, DAA, CPL, SCF and CCF
+func (z *Z80) accumulator(y uint8) {
+	keep := z.F & (z80FlagS | z80FlagZ | z80FlagP)
+	switch y {
+	case 0: //RLCA
+		c := z.A >> 7
+		z.A = z.A<<1 | c
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | c
+	case 1: //RRCA
+		c := z.A & 1
+		z.A = z.A>>1 | c<<7
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | c
+	case 2: //RLA
+		c := z.A >> 7
+		z.A = z.A<<1 | z.F&z80FlagC
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | c
+	case 3: //RRA
+		c := z.A & 1
+		z.A = z.A>>1 | z.F&z80FlagC<<7
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | c
+	case 4:
+		z.daa()
+	case 5: //CPL
+		z.A = ^z.A
+		z.F = z.F&(z80FlagS|z80FlagZ|z80FlagP|z80FlagC) | z.A&(z80FlagX|z80FlagY) | z80FlagH | z80FlagN
+	case 6: //SCF
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | z80FlagC
+	case 7: //CCF, H gets the old carry
+		z.F = keep | z.A&(z80FlagX|z80FlagY) | (z.F&z80FlagC)<<4 | (z.F&z80FlagC ^ z80FlagC)
+	}
+}
+
+//daa fix A up to BCD after an add or subtract
+func (z *Z80) daa() {
+	a, fix := z.A, uint8(0)
+	c := z.F & z80FlagC
+	if z.F&z80FlagH != 0 || a&0x0F > 9 {
+		fix |= 0x06
+	}
+	if c != 0 || a > 0x99 {
+		fix |= 0x60
+		c = z80FlagC
+	}
+	var h uint8
+	if z.F&z80FlagN != 0 {
+		z.A = a - fix
+		if z.F&z80FlagH != 0 && a&0x0F < 6 {
+			h = z80FlagH
+		}
+	} else {
+		z.A = a + fix
+		if a&0x0F > 9 {
+			h = z80FlagH
+		}
+	}
+	z.F = z80SZXYP[z.A] | z.F&z80FlagN | h | c
+}
+
+//alu ADD, ADC, SUB, SBC, AND, XOR, OR and CP with v
+func (z *Z80) alu(y uint8, v uint8) {
+	switch y {
+	case 0:
+		z.add8(v, 0)
+	case 1:
+		z.add8(v, z.F&z80FlagC)
+	case 2:
+		z.A = z.sub8(v, 0)
+	case 3:
+		z.A = z.sub8(v, z.F&z80FlagC)
+	case 4:
+		z.A &= v
+		z.F = z80SZXYP[z.A] | z80FlagH
+	case 5:
+		z.A ^= v
+		z.F = z80SZXYP[z.A]
+	case 6:
+		z.A |= v
+		z.F = z80SZXYP[z.A]
+	case 7:
+		//CP takes the undocumented flags from v, not the result
+		z.sub8(v, 0)
+		z.F = z.F&^(z80FlagX|z80FlagY) | v&(z80FlagX|z80FlagY)
+	}
+}
+
+func (z *Z80) add8(v uint8, c uint8) {
+	sum := uint16(z.A) + uint16(v) + uint16(c)
+	res := uint8(sum)
+	f := z80SZXY[res] | (z.A^v^res)&z80FlagH
+	if sum > 0xFF {
+		f |= z80FlagC
+	}
+	if (z.A^v)&0x80 == 0 && (z.A^res)&0x80 != 0 {
+		f |= z80FlagP
+	}
+	z.A, z.F = res, f
+}
+
+//sub8 A-v-c, the flags are set but A is left for the caller
+func (z *Z80) sub8(v uint8, c uint8) uint8 {
+	diff := uint16(z.A) - uint16(v) - uint16(c)
+	res := uint8(diff)
+	f := z80SZXY[res] | (z.A^v^res)&z80FlagH | z80FlagN
+	if diff > 0xFF {
+		f |= z80FlagC
+	}
+	if (z.A^v)&0x80 != 0 && (z.A^res)&0x80 != 0 {
+		f |= z80FlagP
+	}
+	z.F = f
+	return res
+}
+
+func (z *Z80) inc8(v uint8) uint8 {
+	res := v + 1
+	f := z.F&z80FlagC | z80SZXY[res]
+	if v&0x0F == 0x0F {
+		f |= z80FlagH
+	}
+	if v == 0x7F {
+		f |= z80FlagP
+	}
+	z.F = f
+	return res
+}
+
+func (z *Z80) dec8(v uint8) uint8 {
+	res := v - 1
+	f := z.F&z80FlagC | z80SZXY[res] | z80FlagN
+	if v&0x0F == 0 {
+		f |= z80FlagH
+	}
+	if v == 0x80 {
+		f |= z80FlagP
+	}
+	z.F = f
+	return res
+}
+
+//add16 ADD HL,rr, only the carries change
+func (z *Z80) add16(a, b uint16) uint16 {
+	sum := uint32(a) + uint32(b)
+	res := uint16(sum)
+	f := z.F&(z80FlagS|z80FlagZ|z80FlagP) | uint8(res>>8)&(z80FlagX|z80FlagY)
+	if (a^b^res)&0x1000 != 0 {
+		f |= z80FlagH
+	}
+	if sum > 0xFFFF {
+		f |= z80FlagC
+	}
+	z.F = f
+	return res
+}
+
+//adc16 ADC HL,rr
+func (z *Z80) adc16(v uint16) {
+	hl := z.hl()
+	sum := uint32(hl) + uint32(v) + uint32(z.F&z80FlagC)
+	res := uint16(sum)
+	f := uint8(res>>8) & (z80FlagS | z80FlagX | z80FlagY)
+	if res == 0 {
+		f |= z80FlagZ
+	}
+	if (hl^v^res)&0x1000 != 0 {
+		f |= z80FlagH
+	}
+	if (hl^v)&0x8000 == 0 && (hl^res)&0x8000 != 0 {
+		f |= z80FlagP
+	}
+	if sum > 0xFFFF {
+		f |= z80FlagC
+	}
+	z.setHL(res)
+	z.F = f
+}
+
+//sbc16 SBC HL,rr
+func (z *Z80) sbc16(v uint16) {
+	hl := z.hl()
+	diff := uint32(hl) - uint32(v) - uint32(z.F&z80FlagC)
+	res := uint16(diff)
+	f := uint8(res>>8)&(z80FlagS|z80FlagX|z80FlagY) | z80FlagN
+	if res == 0 {
+		f |= z80FlagZ
+	}
+	if (hl^v^res)&0x1000 != 0 {
+		f |= z80FlagH
+	}
+	if (hl^v)&0x8000 != 0 && (hl^res)&0x8000 != 0 {
+		f |= z80FlagP
+	}
+	if diff > 0xFFFF {
+		f |= z80FlagC
+	}
+	z.setHL(res)
+	z.F = f
+}
+
+//rot the CB rotates and shifts, RLC RRC RL RR SLA SRA SLL SRL
+func (z *Z80) rot(y uint8, v uint8) uint8 {
+	var res, c uint8
+	switch y {
+	case 0:
+		c = v >> 7
+		res = v<<1 | c
+	case 1:
+		c = v & 1
+		res = v>>1 | c<<7
+	case 2:
+		c = v >> 7
+		res = v<<1 | z.F&z80FlagC
+	case 3:
+		c = v & 1
+		res = v>>1 | z.F&z80FlagC<<7
+	case 4:
+		c = v >> 7
+		res = v << 1
+	case 5:
+		c = v & 1
+		res = v>>1 | v&0x80
+	case 6:
+		c = v >> 7
+		res = v<<1 | 1
+	case 7:
+		c = v & 1
+		res = v >> 1
+	}
+	z.F = z80SZXYP[res] | c
+	return res
+}
+
+//bit BIT y,v, the undocumented flags come from xy
+func (z *Z80) bit(y uint8, v uint8, xy uint8) {
+	f := z.F&z80FlagC | z80FlagH | xy&(z80FlagX|z80FlagY)
+	if v&(1<<y) == 0 {
+		f |= z80FlagZ | z80FlagP
+	} else if y == 7 {
+		f |= z80FlagS
+	}
+	z.F = f
+}
+
+//cond NZ, Z, NC, C, PO, PE, P and M
+func (z *Z80) cond(y uint8) bool {
+	flag := [4]uint8{z80FlagZ, z80FlagC, z80FlagP, z80FlagS}[y>>1]
+	return (z.F&flag != 0) == (y&1 != 0)
+}
+
+func (z *Z80) jr(d uint8) {
+	z.PC += uint16(int8(d))
+}
+
+//incR R counts opcode fetches in its low 7 bits
+func (z *Z80) incR() {
+	z.R = z.R&0x80 | (z.R+1)&0x7F
+}
+
+func (z *Z80) fetchOp() uint8 {
+	z.incR()
+	return z.fetch()
+}
+
+func (z *Z80) fetch() uint8 {
+	v := z.bus.Read(z.PC)
+	z.PC++
+	return v
+}
+
+func (z *Z80) fetch16() uint16 {
+	lo := uint16(z.fetch())
+	return uint16(z.fetch())<<8 | lo
+}
+
+func (z *Z80) read16(addr uint16) uint16 {
+	lo := uint16(z.bus.Read(addr))
+	return uint16(z.bus.Read(addr+1))<<8 | lo
+}
+
+func (z *Z80) write16(addr uint16, v uint16) {
+	z.bus.Write(addr, uint8(v))
+	z.bus.Write(addr+1, uint8(v>>8))
+}
+
+func (z *Z80) push(v uint16) {
+	z.SP--
+	z.bus.Write(z.SP, uint8(v>>8))
+	z.SP--
+	z.bus.Write(z.SP, uint8(v))
+}
+
+func (z *Z80) pop() uint16 {
+	v := z.read16(z.SP)
+	z.SP += 2
+	return v
+}
+
+//memAddr the address (HL) means, (IX+d) fetches the displacement
+func (z *Z80) memAddr() uint16 {
+	if z.idx == z80HL {
+		return z.hl()
+	}
+	d := int8(z.fetch())
+	z.t += 8
+	return z.hlx() + uint16(d)
+}
+
+func (z *Z80) af() uint16 { return uint16(z.A)<<8 | uint16(z.F) }
+func (z *Z80) bc() uint16 { return uint16(z.B)<<8 | uint16(z.C) }
+func (z *Z80) de() uint16 { return uint16(z.D)<<8 | uint16(z.E) }
+func (z *Z80) hl() uint16 { return uint16(z.H)<<8 | uint16(z.L) }
+
+func (z *Z80) setAF(v uint16) { z.A, z.F = uint8(v>>8), uint8(v) }
+func (z *Z80) setBC(v uint16) { z.B, z.C = uint8(v>>8), uint8(v) }
+func (z *Z80) setDE(v uint16) { z.D, z.E = uint8(v>>8), uint8(v) }
+func (z *Z80) setHL(v uint16) { z.H, z.L = uint8(v>>8), uint8(v) }
+
+//hlx HL, IX or IY, whichever the prefix picked
+func (z *Z80) hlx() uint16 {
+	switch z.idx {
+	case z80IX:
+		return z.IX
+	case z80IY:
+		return z.IY
+	}
+	return z.hl()
+}
+
+func (z *Z80) setHLX(v uint16) {
+	switch z.idx {
+	case z80IX:
+		z.IX = v
+	case z80IY:
+		z.IY = v
+	default:
+		z.setHL(v)
+	}
+}
+
+//rp BC, DE, HL and SP
+func (z *Z80) rp(p uint8) uint16 {
+	switch p {
+	case 0:
+		return z.bc()
+	case 1:
+		return z.de()
+	case 2:
+		return z.hlx()
+	}
+	return z.SP
+}
+
+func (z *Z80) setRP(p uint8, v uint16) {
+	switch p {
+	case 0:
+		z.setBC(v)
+	case 1:
+		z.setDE(v)
+	case 2:
+		z.setHLX(v)
+	default:
+		z.SP = v
+	}
+}
+
+//rp2 BC, DE, HL and AF for PUSH and POP
+func (z *Z80) rp2(p uint8) uint16 {
+	if p == 3 {
+		return z.af()
+	}
+	return z.rp(p)
+}
+
+func (z *Z80) setRP2(p uint8, v uint16) {
+	if p == 3 {
+		z.setAF(v)
+	} else {
+		z.setRP(p, v)
+	}
+}
+
+//reg B, C, D, E, H, L and A (r is never 6), H and L are the halves of IX or IY after a prefix
+func (z *Z80) reg(r uint8) uint8 {
+	switch r {
+	case 0:
+		return z.B
+	case 1:
+		return z.C
+	case 2:
+		return z.D
+	case 3:
+		return z.E
+	case 4:
+		return uint8(z.hlx() >> 8)
+	case 5:
+		return uint8(z.hlx())
+	}
+	return z.A
+}
+
+func (z *Z80) setReg(r uint8, v uint8) {
+	switch r {
+	case 0:
+		z.B = v
+	case 1:
+		z.C = v
+	case 2:
+		z.D = v
+	case 3:
+		z.E = v
+	case 4:
+		z.setHLX(z.hlx()&0x00FF | uint16(v)<<8)
+	case 5:
+		z.setHLX(z.hlx()&0xFF00 | uint16(v))
+	default:
+		z.A = v
+	}
+}
+
+//plainReg reg with H and L always H and L, for the instructions that also use (IX+d)
+func (z *Z80) plainReg(r uint8) uint8 {
+	idx := z.idx
+	z.idx = z80HL
+	v := z.reg(r)
+	z.idx = idx
+	return v
+}
+
+func (z *Z80) setPlainReg(r uint8, v uint8) {
+	idx := z.idx
+	z.idx = z80HL
+	z.setReg(r, v)
+	z.idx = idx
+}
@@ -0,0 +1,378 @@
+package appleii
+
+/* z80_test.go -- Z80 flags, block instructions and timing, and the SoftCard around it
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import "testing"
+
+//fakeZ80Bus 64K of RAM, reads from the I/O ports take the bytes queued in one at a time
+type fakeZ80Bus struct {
+	mem   [0x10000]uint8
+	in    []uint8
+	ports []uint16
+	out   []uint8
+}
+
+func (f *fakeZ80Bus) Read(addr uint16) uint8 {
+	return f.mem[addr]
+}
+
+func (f *fakeZ80Bus) Write(addr uint16, data uint8) {
+	f.mem[addr] = data
+}
+
+func (f *fakeZ80Bus) In(port uint16) uint8 {
+	f.ports = append(f.ports, port)
+	if len(f.in) == 0 {
+		return 0xFF
+	}
+	v := f.in[0]
+	f.in = f.in[1:]
+	return v
+}
+
+func (f *fakeZ80Bus) Out(port uint16, data uint8) {
+	f.ports = append(f.ports, port)
+	f.out = append(f.out, data)
+}
+
+//testZ80 a Z80 with program at $0000 and F cleared
+func testZ80(program ...uint8) (*Z80, *fakeZ80Bus) {
+	f := &fakeZ80Bus{}
+	copy(f.mem[:], program)
+	z := NewZ80(f)
+	z.F = 0
+	return z, f
+}
+
+//runZ80 step until PC gets to end and give the T states it took
+func runZ80(t *testing.T, z *Z80, end uint16) int {
+	t.Helper()
+	total := 0
+	for i := 0; z.PC != end; i++ {
+		if i > 1000 {
+			t.Fatalf("PC never got to $%04X, it's at $%04X", end, z.PC)
+		}
+		total += z.Step()
+	}
+	return total
+}
+
+func checkFlags(t *testing.T, name string, z *Z80, want uint8) {
+	t.Helper()
+	if z.F != want {
+		t.Errorf("%s: F is %08b, want %08b", name, z.F, want)
+	}
+}
+
+func TestZ80DAA(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      uint8 //ADD A,n or SUB n
+		a, n    uint8
+		want, f uint8
+	}{
+		{"15+27", 0xC6, 0x15, 0x27, 0x42, z80FlagH | z80FlagP},
+		{"42-15", 0xD6, 0x42, 0x15, 0x27, z80FlagY | z80FlagP | z80FlagN},
+		{"99+01", 0xC6, 0x99, 0x01, 0x00, z80FlagZ | z80FlagH | z80FlagP | z80FlagC},
+		{"10-20", 0xD6, 0x10, 0x20, 0x90, z80FlagS | z80FlagP | z80FlagN | z80FlagC},
+	}
+	for _, tt := range tests {
+		z, _ := testZ80(tt.op, tt.n, 0x27)
+		z.A = tt.a
+		runZ80(t, z, 3)
+		if z.A != tt.want {
+			t.Errorf("%s: DAA gave %02X, want %02X", tt.name, z.A, tt.want)
+		}
+		checkFlags(t, tt.name, z, tt.f)
+	}
+}
+
+func TestZ80ADCSBC16(t *testing.T) {
+	//ADC HL,BC from $7FFF with the carry in overflows into the sign
+	z, _ := testZ80(0xED, 0x4A)
+	z.setHL(0x7FFF)
+	z.F = z80FlagC
+	if n := z.Step(); n != 15 {
+		t.Errorf("ADC HL,BC took %d T states, want 15", n)
+	}
+	if z.hl() != 0x8000 {
+		t.Errorf("ADC HL,BC gave %04X, want 8000", z.hl())
+	}
+	checkFlags(t, "ADC HL,BC", z, z80FlagS|z80FlagH|z80FlagP)
+
+	//SBC HL,DE to zero
+	z, _ = testZ80(0xED, 0x52)
+	z.setHL(0x1000)
+	z.setDE(0x1000)
+	z.Step()
+	if z.hl() != 0 {
+		t.Errorf("SBC HL,DE gave %04X, want 0000", z.hl())
+	}
+	checkFlags(t, "SBC HL,DE", z, z80FlagZ|z80FlagN)
+
+	//SBC HL,DE borrowing all the way through
+	z, _ = testZ80(0xED, 0x52)
+	z.setDE(1)
+	z.Step()
+	if z.hl() != 0xFFFF {
+		t.Errorf("SBC HL,DE gave %04X, want FFFF", z.hl())
+	}
+	checkFlags(t, "SBC HL,DE borrow", z, z80FlagS|z80FlagY|z80FlagH|z80FlagX|z80FlagN|z80FlagC)
+}
+
+func TestZ80LDIR(t *testing.T) {
+	z, f := testZ80(0xED, 0xB0)
+	copy(f.mem[0x100:], []uint8{1, 2, 3})
+	z.A = 0
+	z.setHL(0x100)
+	z.setDE(0x200)
+	z.setBC(3)
+	if n := runZ80(t, z, 2); n != 21+21+16 {
+		t.Errorf("LDIR of 3 bytes took %d T states, want 58", n)
+	}
+	if got := f.mem[0x200:0x204]; got[0] != 1 || got[1] != 2 || got[2] != 3 || got[3] != 0 {
+		t.Errorf("LDIR copied % X", got)
+	}
+	if z.hl() != 0x103 || z.de() != 0x203 || z.bc() != 0 {
+		t.Errorf("LDIR left HL %04X DE %04X BC %04X", z.hl(), z.de(), z.bc())
+	}
+	//A+the last byte is 3, bit 1 of it is Y
+	checkFlags(t, "LDIR", z, z80FlagY)
+}
+
+func TestZ80CPIR(t *testing.T) {
+	z, f := testZ80(0xED, 0xB1)
+	copy(f.mem[0x100:], []uint8{0x11, 0x22, 0x33, 0x44})
+	z.A = 0x33
+	z.setHL(0x100)
+	z.setBC(4)
+	if n := runZ80(t, z, 2); n != 21+21+16 {
+		t.Errorf("CPIR took %d T states, want 58", n)
+	}
+	if z.hl() != 0x103 || z.bc() != 1 {
+		t.Errorf("CPIR stopped with HL %04X BC %04X, want 0103 0001", z.hl(), z.bc())
+	}
+	checkFlags(t, "CPIR", z, z80FlagZ|z80FlagP|z80FlagN)
+
+	//Running out without a match
+	z, f = testZ80(0xED, 0xB1)
+	copy(f.mem[0x100:], []uint8{0x11, 0x22})
+	z.A = 0x33
+	z.setHL(0x100)
+	z.setBC(2)
+	runZ80(t, z, 2)
+	if z.bc() != 0 || z.F&(z80FlagZ|z80FlagP) != 0 {
+		t.Errorf("CPIR with no match left BC %04X F %08b", z.bc(), z.F)
+	}
+}
+
+func TestZ80INIR(t *testing.T) {
+	z, f := testZ80(0xED, 0xB2)
+	f.in = []uint8{0xA1, 0xB2, 0xC3}
+	z.setHL(0x300)
+	z.setBC(0x0310)
+	if n := runZ80(t, z, 2); n != 21+21+16 {
+		t.Errorf("INIR took %d T states, want 58", n)
+	}
+	if got := f.mem[0x300:0x303]; got[0] != 0xA1 || got[1] != 0xB2 || got[2] != 0xC3 {
+		t.Errorf("INIR stored % X", got)
+	}
+	//B goes out on the top half of the address bus before it counts down
+	if len(f.ports) != 3 || f.ports[0] != 0x0310 || f.ports[1] != 0x0210 || f.ports[2] != 0x0110 {
+		t.Errorf("INIR read ports %04X", f.ports)
+	}
+	if z.B != 0 || z.hl() != 0x303 {
+		t.Errorf("INIR left B %02X HL %04X", z.B, z.hl())
+	}
+	checkFlags(t, "INIR", z, z80FlagZ|z80FlagN)
+}
+
+func TestZ80IndexedBits(t *testing.T) {
+	//BIT 7,(IX+5)
+	z, f := testZ80(0xDD, 0xCB, 0x05, 0x7E)
+	z.IX = 0x1000
+	f.mem[0x1005] = 0x80
+	if n := z.Step(); n != 20 {
+		t.Errorf("BIT 7,(IX+5) took %d T states, want 20", n)
+	}
+	checkFlags(t, "BIT 7,(IX+5)", z, z80FlagS|z80FlagH)
+
+	//SET 0,(IY-2)
+	z, f = testZ80(0xFD, 0xCB, 0xFE, 0xC6)
+	z.IY = 0x2002
+	f.mem[0x2000] = 0x40
+	if n := z.Step(); n != 23 {
+		t.Errorf("SET 0,(IY-2) took %d T states, want 23", n)
+	}
+	if f.mem[0x2000] != 0x41 {
+		t.Errorf("SET 0,(IY-2) wrote %02X, want 41", f.mem[0x2000])
+	}
+
+	//RLC (IX+1),B the undocumented copy of the result to a register
+	z, f = testZ80(0xDD, 0xCB, 0x01, 0x00)
+	z.IX = 0x1000
+	f.mem[0x1001] = 0x81
+	z.Step()
+	if f.mem[0x1001] != 0x03 || z.B != 0x03 {
+		t.Errorf("RLC (IX+1),B left (IX+1) %02X and B %02X, want 03 and 03", f.mem[0x1001], z.B)
+	}
+	checkFlags(t, "RLC (IX+1),B", z, z80FlagP|z80FlagC)
+
+	//RES 3,(IY+0) leaves H alone, (HL) isn't a copy
+	z, f = testZ80(0xFD, 0xCB, 0x00, 0x9E)
+	z.IY = 0x3000
+	z.H = 0x55
+	f.mem[0x3000] = 0xFF
+	z.Step()
+	if f.mem[0x3000] != 0xF7 || z.H != 0x55 {
+		t.Errorf("RES 3,(IY+0) left (IY) %02X and H %02X", f.mem[0x3000], z.H)
+	}
+}
+
+func TestZ80RLDRRD(t *testing.T) {
+	z, f := testZ80(0xED, 0x6F)
+	z.A = 0x12
+	z.setHL(0x500)
+	f.mem[0x500] = 0x34
+	if n := z.Step(); n != 18 {
+		t.Errorf("RLD took %d T states, want 18", n)
+	}
+	if z.A != 0x13 || f.mem[0x500] != 0x42 {
+		t.Errorf("RLD left A %02X (HL) %02X, want 13 42", z.A, f.mem[0x500])
+	}
+	checkFlags(t, "RLD", z, 0)
+
+	z, f = testZ80(0xED, 0x67)
+	z.A = 0x12
+	z.F = z80FlagC
+	z.setHL(0x500)
+	f.mem[0x500] = 0x34
+	z.Step()
+	if z.A != 0x14 || f.mem[0x500] != 0x23 {
+		t.Errorf("RRD left A %02X (HL) %02X, want 14 23", z.A, f.mem[0x500])
+	}
+	checkFlags(t, "RRD", z, z80FlagP|z80FlagC)
+}
+
+func TestZ80Timing(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint8
+		f       uint8
+		want    int
+	}{
+		{"NOP", []uint8{0x00}, 0, 4},
+		{"LD BC,nn", []uint8{0x01, 0x34, 0x12}, 0, 10},
+		{"LD A,(HL)", []uint8{0x7E}, 0, 7},
+		{"LD (IX+d),n", []uint8{0xDD, 0x36, 0x01, 0x55}, 0, 19},
+		{"LD H,(IX+d)", []uint8{0xDD, 0x66, 0x01}, 0, 19},
+		{"INC IXH", []uint8{0xDD, 0x24}, 0, 8},
+		{"JR NZ taken", []uint8{0x20, 0x10}, 0, 12},
+		{"JR NZ not taken", []uint8{0x20, 0x10}, z80FlagZ, 7},
+		{"DJNZ taken", []uint8{0x10, 0x10}, 0, 13},
+		{"CALL nn", []uint8{0xCD, 0x00, 0x10}, 0, 17},
+		{"RET NZ not taken", []uint8{0xC0}, z80FlagZ, 5},
+		{"RET NZ taken", []uint8{0xC0}, 0, 11},
+		{"PUSH BC", []uint8{0xC5}, 0, 11},
+		{"EX (SP),IX", []uint8{0xDD, 0xE3}, 0, 23},
+		{"ADD HL,BC", []uint8{0x09}, 0, 11},
+		{"OUT (n),A", []uint8{0xD3, 0x10}, 0, 11},
+		{"RLC B", []uint8{0xCB, 0x00}, 0, 8},
+		{"BIT 0,(HL)", []uint8{0xCB, 0x46}, 0, 12},
+		{"SET 0,(HL)", []uint8{0xCB, 0xC6}, 0, 15},
+		{"LD (nn),HL", []uint8{0x22, 0x00, 0x10}, 0, 16},
+		{"LD (nn),DE", []uint8{0xED, 0x53, 0x00, 0x10}, 0, 20},
+		{"HALT", []uint8{0x76}, 0, 4},
+	}
+	for _, tt := range tests {
+		z, _ := testZ80(tt.program...)
+		z.B = 2
+		z.F = tt.f
+		if n := z.Step(); n != tt.want {
+			t.Errorf("%s took %d T states, want %d", tt.name, n, tt.want)
+		}
+	}
+}
+
+func TestSoftCardAddr(t *testing.T) {
+	tests := []struct{ z80, apple uint16 }{
+		{0x0000, 0x1000},
+		{0x0100, 0x1100}, //CP/M's TPA
+		{0xAFFF, 0xBFFF},
+		{0xB000, 0xD000},
+		{0xDFFF, 0xFFFF},
+		{0xE000, 0xC000},
+		{0xE400, 0xC400}, //Slot 4's ROM, the SoftCard itself
+		{0xEFFF, 0xCFFF},
+		{0xF000, 0x0000},
+		{0xF400, 0x0400}, //The text screen
+		{0xFFFF, 0x0FFF},
+	}
+	for _, tt := range tests {
+		if got := softCardAddr(tt.z80); got != tt.apple {
+			t.Errorf("Z80 $%04X is Apple $%04X, want $%04X", tt.z80, got, tt.apple)
+		}
+	}
+}
+
+func TestSoftCardHandoff(t *testing.T) {
+	b, c, m := testMachine(t)
+	s := NewSoftCard(b)
+	m.InsertCard(4, s)
+	//LD A,$C1 / LD ($F400),A / LD ($E400),A / NOP, the text screen gets an A and the bus goes back
+	//to the 6502
+	copy(m.mem[0x1000:], []uint8{0x3E, 0xC1, 0x32, 0x00, 0xF4, 0x32, 0x00, 0xE4, 0x00})
+
+	b.Write(0xC400, 0)
+	if b.master != s {
+		t.Fatal("writing $C400 didn't give the Z80 the bus")
+	}
+	cycles := 0
+	for i := 0; b.master != nil; i++ {
+		if i > 10 {
+			t.Fatalf("the Z80 never handed the bus back, it's at $%04X", s.z80.PC)
+		}
+		cycles += c.Tick()
+	}
+	if got := b.Read(0x0400); got != 0xC1 {
+		t.Errorf("the Z80 wrote %02X to the text screen, want C1", got)
+	}
+	//7+13+13 T states are 16 6502 cycles with half of one left over
+	if cycles != 16 || s.regs.T != 1 {
+		t.Errorf("the Z80 ran for %d cycles with %d T states over, want 16 and 1", cycles, s.regs.T)
+	}
+	if s.z80.PC != 0x0008 {
+		t.Errorf("the Z80 stopped at $%04X, want $0008", s.z80.PC)
+	}
+
+	//The Z80 picks up where it stopped the next time it gets the bus
+	b.Write(0xC400, 0)
+	if b.master != s {
+		t.Fatal("writing $C400 again didn't give the Z80 the bus")
+	}
+	c.Tick()
+	if s.z80.PC != 0x0009 {
+		t.Errorf("the Z80 ran on to $%04X, want $0009", s.z80.PC)
+	}
+
+	s.Reset()
+	if b.master != nil || s.regs.Active || s.z80.PC != 0 {
+		t.Errorf("reset left the Z80 with the bus or at $%04X", s.z80.PC)
+	}
+}
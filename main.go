@@ -19,16 +19,155 @@ package main
 */
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
+	"github.com/cupcakus/appleII-piz/appleii"
 	"github.com/cupcakus/appleII-piz/sys"
+	"github.com/cupcakus/appleII-piz/video"
+)
+
+var (
+	scale    = flag.String("scale", "nearest", "how the display is scaled: nearest, integer, letterbox or bilinear")
+	headless = flag.Bool("headless", false, "run without a display or input (for automated tooling)")
+	frames   = flag.Uint64("frames", 0, "stop a headless run after this many frames (0 runs forever)")
+	dump     = flag.String("dump", "", "comma separated list of frame numbers to write to PNG")
+	dumpDir  = flag.String("dumpdir", ".", "directory for frames written by -dump")
+	record   = flag.String("record", "", "record a headless run to a .gif, .y4m or .rgb file")
+	deadzone = flag.Int("deadzone", 0, "joystick inputs this close to the middle (0-255 scale) read as centered")
+	invert   = flag.String("invert", "", "comma separated list of paddles (0-3) to invert")
+	calib    = flag.String("calibrate", "", "comma separated paddle:min:max list, the input range that reads as 0-255")
+	paste    = flag.String("paste", "", "type the contents of this file into the emulator at startup")
+	delay    = flag.Uint64("pastedelay", 0, "least number of cycles between pasted characters (0 types as fast as the software reads them)")
+	upper    = flag.Bool("uppercase", false, "paste letters as capitals (for II+ software)")
+	script   = flag.String("script", "", "script file that drives the emulator (see sys/script.go)")
+	load     = flag.String("load", "", "start from a save state (written by the script save command)")
+	movie    = flag.String("movie", "", "record every input to a movie file for exact replay")
+	replay   = flag.String("replay", "", "replay a movie recorded with -movie")
+	slots    = flag.String("slots", "6=disk", "comma separated slot=card list, cards: disk, harddisk, mockingboard, mockingboard-speech, printer, serial, clock, mouse, saturn, softcard")
+	wav      = flag.String("wav", "", "record the sound to a WAV file")
+	tapeIn   = flag.String("tape", "", "WAV file in the cassette player, the script tape command plays it")
+	tapeOut  = flag.String("tapeout", "", "record the cassette output (SAVE, monitor W) to a WAV file")
+	serial   = flag.String("serial", "", "comma separated slot=connection list for serial cards, connections: pty, listen:ADDR, connect:ADDR, file:NAME")
+	ssc      = flag.String("serialdip", "9600,8N1", "serial card DIP switches: baud rate, data bits, parity and stop bits, then printer and lf to turn those on")
+	printer  = flag.String("printer", "", "comma separated slot=output list for printer cards, outputs: file:NAME (text) or imagewriter:DIR (PNG pages)")
+	clock    = flag.Duration("clockoffset", 0, "set the clock card this far from the host's time, -8760h is a year ago")
+	hd       = flag.String("hd", "", "comma separated slot=image list of .hdv, .po or .2mg volumes for harddisk cards, repeat a slot for more units")
+	ramworks = flag.Int("ramworks", 64, "KB of aux memory in 64KB banks up to 8192, more than 64 is a RamWorks style card")
+	bench    = flag.Bool("bench", false, "report how long emulating, rendering and scaling a frame takes (implies -headless)")
 )
 
 func main() {
-	runner := sys.NewRunner()
+	flag.Parse()
+
+	cfg := sys.Config{DumpDir: *dumpDir, Frames: *frames, Record: *record, Bench: *bench}
+	cfg.Paste, cfg.PasteDelay, cfg.Uppercase = *paste, *delay, *upper
+	cfg.Script, cfg.Audio, cfg.ClockOffset = *script, *wav, *clock
+	cfg.LoadState, cfg.Movie, cfg.Replay = *load, *movie, *replay
+	cfg.TapeIn, cfg.TapeOut = *tapeIn, *tapeOut
+	mode, err := video.ParseScaleMode(*scale)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Scale = mode
+	for _, f := range strings.Split(*dump, ",") {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			log.Fatalf("Bad frame number %q in -dump", f)
+		}
+		cfg.Dumps = append(cfg.Dumps, n)
+	}
+
+	if *ramworks < 64 || *ramworks > 64*appleii.MaxAuxBanks || *ramworks%64 != 0 {
+		log.Fatalf("Bad aux memory size %dKB in -ramworks", *ramworks)
+	}
+	cfg.AuxBanks = *ramworks / 64
+
+	for i := range cfg.Paddles {
+		cfg.Paddles[i] = appleii.DefaultPaddleAxis
+		cfg.Paddles[i].Deadzone = *deadzone
+	}
+	for _, f := range strings.Split(*invert, ",") {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || n > 3 {
+			log.Fatalf("Bad paddle %q in -invert", f)
+		}
+		cfg.Paddles[n].Invert = true
+	}
+	for _, f := range strings.Split(*calib, ",") {
+		if f == "" {
+			continue
+		}
+		var n, min, max int
+		if _, err := fmt.Sscanf(f, "%d:%d:%d", &n, &min, &max); err != nil || n < 0 || n > 3 || max <= min {
+			log.Fatalf("Bad calibration %q in -calibrate", f)
+		}
+		cfg.Paddles[n].Min, cfg.Paddles[n].Max = min, max
+	}
+
+	for _, f := range strings.Split(*slots, ",") {
+		if f == "" {
+			continue
+		}
+		var n int
+		var card string
+		if _, err := fmt.Sscanf(strings.Replace(f, "=", " ", 1), "%d %s", &n, &card); err != nil || n < 1 || n > 7 {
+			log.Fatalf("Bad slot %q in -slots", f)
+		}
+		cfg.Slots[n] = card
+	}
+	for _, f := range strings.Split(*serial, ",") {
+		if f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		n, err := strconv.Atoi(kv[0])
+		if err != nil || len(kv) != 2 || n < 1 || n > 7 {
+			log.Fatalf("Bad connection %q in -serial", f)
+		}
+		cfg.Serial[n] = kv[1]
+	}
+	for _, f := range strings.Split(*printer, ",") {
+		if f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		n, err := strconv.Atoi(kv[0])
+		if err != nil || len(kv) != 2 || n < 1 || n > 7 {
+			log.Fatalf("Bad output %q in -printer", f)
+		}
+		cfg.Printers[n] = kv[1]
+	}
+	if cfg.SerialSwitches, err = appleii.ParseSSCSwitches(*ssc); err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range strings.Split(*hd, ",") {
+		if f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		n, err := strconv.Atoi(kv[0])
+		if err != nil || len(kv) != 2 || n < 1 || n > 7 {
+			log.Fatalf("Bad volume %q in -hd", f)
+		}
+		cfg.HardDisks[n] = append(cfg.HardDisks[n], kv[1])
+	}
+
+	var runner sys.Runner = sys.NewRunner(cfg)
+	if *headless || *bench {
+		runner = sys.NewHeadlessRunner(cfg)
+	}
 	runner.Init()
-	err := runner.Run()
-	if err != "" {
+	if err := runner.Run(); err != "" {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,66 @@
+package sys
+
+/* clipboard_windows.go -- Reads text from the Windows clipboard
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+const cfUnicodeText = 13
+
+var (
+	user32           = syscall.NewLazyDLL("user32.dll")
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	openClipboard    = user32.NewProc("OpenClipboard")
+	closeClipboard   = user32.NewProc("CloseClipboard")
+	getClipboardData = user32.NewProc("GetClipboardData")
+	globalLock       = kernel32.NewProc("GlobalLock")
+	globalUnlock     = kernel32.NewProc("GlobalUnlock")
+	lstrlenW         = kernel32.NewProc("lstrlenW")
+	rtlMoveMemory    = kernel32.NewProc("RtlMoveMemory")
+)
+
+//readClipboard the text on the clipboard
+func readClipboard() (string, error) {
+	if r, _, err := openClipboard.Call(0); r == 0 {
+		return "", err
+	}
+	defer closeClipboard.Call()
+
+	h, _, _ := getClipboardData.Call(cfUnicodeText)
+	if h == 0 {
+		return "", errors.New("there is no text on the clipboard")
+	}
+	p, _, err := globalLock.Call(h)
+	if p == 0 {
+		return "", err
+	}
+	defer globalUnlock.Call(h)
+
+	//The text is UTF-16, copy it out of the global memory
+	n, _, _ := lstrlenW.Call(p)
+	if n == 0 {
+		return "", nil
+	}
+	text := make([]uint16, n)
+	rtlMoveMemory.Call(uintptr(unsafe.Pointer(&text[0])), p, n*2)
+	return syscall.UTF16ToString(text), nil
+}
@@ -0,0 +1,359 @@
+package sys
+
+/* evdev_linux.go -- Native Linux keyboard input through evdev (/dev/input/event*)
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//Event types and key codes from linux/input-event-codes.h
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+
+	keyEsc        = 1
+	keyBackspace  = 14
+	keyTab        = 15
+	keyEnter      = 28
+	keyLeftCtrl   = 29
+	keyLeftShift  = 42
+	keyRightShift = 54
+	keyLeftAlt    = 56
+	keyCapsLock   = 58
+	keyRightCtrl  = 97
+	keyRightAlt   = 100
+	keyHome       = 102
+	keyUp         = 103
+	keyPageUp     = 104
+	keyLeft       = 105
+	keyRight      = 106
+	keyEnd        = 107
+	keyDown       = 108
+	keyPageDown   = 109
+	keyDelete     = 111
+	keyLeftMeta   = 125
+	keyRightMeta  = 126
+
+	keyKP7   = 71
+	keyKP8   = 72
+	keyKP9   = 73
+	keyKP4   = 75
+	keyKP5   = 76
+	keyKP6   = 77
+	keyKP1   = 79
+	keyKP2   = 80
+	keyKP3   = 81
+	keyKP0   = 82
+	keyKPDot = 83
+
+	btnLeft    = 0x110
+	btnTrigger = 0x120
+	btnThumb   = 0x121
+	btnThumb2  = 0x122
+	btnSouth   = 0x130
+	btnEast    = 0x131
+	btnNorth   = 0x133
+
+	relX = 0x00
+	relY = 0x01
+
+	absX     = 0x00
+	absY     = 0x01
+	absRX    = 0x03
+	absRY    = 0x04
+	absHat0X = 0x10
+	absHat0Y = 0x11
+)
+
+//eviocgrab is EVIOCGRAB, _IOW('E', 0x90, int)
+const eviocgrab = 0x40044590
+
+//eviocgabs is EVIOCGABS(0), _IOR('E', 0x40 + axis, struct input_absinfo)
+const eviocgabs = 0x80184540
+
+//evdevASCII the characters typed by each key code, unshifted and shifted
+var evdevASCII = map[uint16][2]byte{
+	2: {'1', '!'}, 3: {'2', '@'}, 4: {'3', '#'}, 5: {'4', '$'}, 6: {'5', '%'},
+	7: {'6', '^'}, 8: {'7', '&'}, 9: {'8', '*'}, 10: {'9', '('}, 11: {'0', ')'},
+	12: {'-', '_'}, 13: {'=', '+'}, keyTab: {0x09, 0x09},
+	16: {'q', 'Q'}, 17: {'w', 'W'}, 18: {'e', 'E'}, 19: {'r', 'R'}, 20: {'t', 'T'},
+	21: {'y', 'Y'}, 22: {'u', 'U'}, 23: {'i', 'I'}, 24: {'o', 'O'}, 25: {'p', 'P'},
+	26: {'[', '{'}, 27: {']', '}'},
+	30: {'a', 'A'}, 31: {'s', 'S'}, 32: {'d', 'D'}, 33: {'f', 'F'}, 34: {'g', 'G'},
+	35: {'h', 'H'}, 36: {'j', 'J'}, 37: {'k', 'K'}, 38: {'l', 'L'},
+	39: {';', ':'}, 40: {'\'', '"'}, 41: {'`', '~'}, 43: {'\\', '|'},
+	44: {'z', 'Z'}, 45: {'x', 'X'}, 46: {'c', 'C'}, 47: {'v', 'V'}, 48: {'b', 'B'},
+	49: {'n', 'N'}, 50: {'m', 'M'},
+	51: {',', '<'}, 52: {'.', '>'}, 53: {'/', '?'}, 57: {' ', ' '},
+}
+
+//inputEvent is a struct input_event from the kernel, less the timestamp
+type inputEvent struct {
+	Type  uint16
+	Code  uint16
+	Value int32 //0 released, 1 pressed, 2 auto repeat
+}
+
+//The timestamp is a struct timeval, its size depends on the platform (8 bytes on the Pi Zero)
+var timevalSize = int(unsafe.Sizeof(syscall.Timeval{}))
+
+//readInputEvents reads kernel input events from r and sends each one to handle until r fails
+func readInputEvents(r io.Reader, handle func(inputEvent)) error {
+	buf := make([]byte, timevalSize+8)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		handle(inputEvent{
+			Type:  binary.LittleEndian.Uint16(buf[timevalSize:]),
+			Code:  binary.LittleEndian.Uint16(buf[timevalSize+2:]),
+			Value: int32(binary.LittleEndian.Uint32(buf[timevalSize+4:])),
+		})
+	}
+}
+
+//keySink receives Apple key presses, *appleii.Kbd is the real one
+type keySink interface {
+	KeyType(key int)
+	SysKeyDn(key appleii.SysKey)
+	SysKeyUp(key appleii.SysKey)
+}
+
+//evdevKeyboard turns Linux key events into Apple IIe key presses
+type evdevKeyboard struct {
+	kbd    keySink
+	hotkey func(code uint16, shift bool) //Keys used by the emulator itself (PGUP, PGDN)
+	shift  bool
+	ctrl   bool
+	caps   bool
+}
+
+func (k *evdevKeyboard) handle(ev inputEvent) {
+	if ev.Type != evKey {
+		return
+	}
+	down := ev.Value != 0
+
+	switch ev.Code {
+	case keyLeftShift, keyRightShift:
+		k.shift = down
+	case keyLeftCtrl, keyRightCtrl:
+		k.ctrl = down
+	case keyCapsLock:
+		if ev.Value == 1 {
+			k.caps = !k.caps
+		}
+		return
+	case keyPageUp, keyPageDown:
+		if ev.Value == 1 && k.hotkey != nil {
+			k.hotkey(ev.Code, k.shift)
+		}
+		return
+	}
+
+	if key, ok := getAppleKey(ev.Code); ok {
+		if down {
+			k.kbd.SysKeyDn(key)
+		} else {
+			k.kbd.SysKeyUp(key)
+		}
+		return
+	}
+
+	chars, ok := evdevASCII[ev.Code]
+	if !ok || !down {
+		return
+	}
+	c := chars[0]
+	if k.shift {
+		c = chars[1]
+	}
+	if k.caps && c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if k.ctrl && c >= '@' {
+		c &= 0x1F
+	}
+	k.kbd.KeyType(int(c))
+}
+
+//evdevAxes which paddle each absolute axis moves, the hat moves the stick like the main one
+var evdevAxes = map[uint16]int{absX: 0, absY: 1, absRX: 2, absRY: 3, absHat0X: 0, absHat0Y: 1}
+
+//evdevButtons which push button each gamepad, joystick or keypad button presses
+var evdevButtons = map[uint16]int{
+	btnTrigger: 0, btnSouth: 0, keyKP0: 0,
+	btnThumb: 1, btnEast: 1, keyKPDot: 1,
+	btnThumb2: 2, btnNorth: 2,
+}
+
+//evdevKeypad where each key of the numeric keypad pushes the joystick
+var evdevKeypad = map[uint16][2]int{
+	keyKP7: {0, 0}, keyKP8: {128, 0}, keyKP9: {255, 0},
+	keyKP4: {0, 128}, keyKP5: {128, 128}, keyKP6: {255, 128},
+	keyKP1: {0, 255}, keyKP2: {128, 255}, keyKP3: {255, 255},
+}
+
+//paddleSink receives paddle moves and button presses, *appleii.Paddles is the real one
+type paddleSink interface {
+	SetAxis(n int, v int)
+	SetButton(n int, down bool)
+}
+
+//evdevJoystick turns Linux gamepad, joystick and numeric keypad events into paddle moves,
+//absolute axes must already be scaled to 0-255
+type evdevJoystick struct {
+	paddles paddleSink
+}
+
+func (j *evdevJoystick) handle(ev inputEvent) {
+	switch ev.Type {
+	case evAbs:
+		if n, ok := evdevAxes[ev.Code]; ok {
+			j.paddles.SetAxis(n, int(ev.Value))
+		}
+	case evKey:
+		if n, ok := evdevButtons[ev.Code]; ok {
+			j.paddles.SetButton(n, ev.Value != 0)
+		} else if pos, ok := evdevKeypad[ev.Code]; ok {
+			//The stick springs back to the middle when the key is let go
+			if ev.Value == 0 {
+				pos = evdevKeypad[keyKP5]
+			}
+			j.paddles.SetAxis(0, pos[0])
+			j.paddles.SetAxis(1, pos[1])
+		}
+	}
+}
+
+//mouseSink receives mouse moves and button presses, the machine passes them to the mouse card
+type mouseSink interface {
+	MoveMouse(dx, dy int)
+	SetMouseButton(down bool)
+}
+
+//evdevMouse turns Linux mouse events into moves of the mouse card's mouse, the movement in
+//one report from the mouse is one move
+type evdevMouse struct {
+	mouse  mouseSink
+	dx, dy int
+}
+
+func (mo *evdevMouse) handle(ev inputEvent) {
+	switch {
+	case ev.Type == evRel && ev.Code == relX:
+		mo.dx += int(ev.Value)
+	case ev.Type == evRel && ev.Code == relY:
+		mo.dy += int(ev.Value)
+	case ev.Type == evKey && ev.Code == btnLeft:
+		mo.mouse.SetMouseButton(ev.Value != 0)
+	case ev.Type == evSyn && (mo.dx != 0 || mo.dy != 0):
+		mo.mouse.MoveMouse(mo.dx, mo.dy)
+		mo.dx, mo.dy = 0, 0
+	}
+}
+
+//drainEvents handle every event that has arrived since the last call, without blocking
+func drainEvents(events <-chan inputEvent, handle func(inputEvent)) {
+	for {
+		select {
+		case ev := <-events:
+			handle(ev)
+		default:
+			return
+		}
+	}
+}
+
+//absRanges the minimum and maximum of each of the device's paddle axes
+func absRanges(file *os.File) map[uint16][2]int32 {
+	ranges := make(map[uint16][2]int32)
+	for code := range evdevAxes {
+		var info [6]int32 //value, minimum, maximum, fuzz, flat, resolution
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(eviocgabs)+uintptr(code), uintptr(unsafe.Pointer(&info[0])))
+		if errno == 0 && info[2] > info[1] {
+			ranges[code] = [2]int32{info[1], info[2]}
+		}
+	}
+	return ranges
+}
+
+//scaleAbs scale an absolute axis event from the device's range to 0-255, other events pass through
+func scaleAbs(ev inputEvent, ranges map[uint16][2]int32) inputEvent {
+	if r, ok := ranges[ev.Code]; ok && ev.Type == evAbs {
+		ev.Value = (ev.Value - r[0]) * 255 / (r[1] - r[0])
+	}
+	return ev
+}
+
+//watchInputDevices opens every /dev/input/event* device, including ones plugged in later, grabs
+//it so keys don't leak to the console, and sends its events down the channel with absolute axes
+//scaled to 0-255
+func watchInputDevices(events chan<- inputEvent) {
+	var lock sync.Mutex
+	open := make(map[string]bool)
+
+	for {
+		paths, _ := filepath.Glob("/dev/input/event*")
+		for _, path := range paths {
+			lock.Lock()
+			isOpen := open[path]
+			lock.Unlock()
+			if isOpen {
+				continue
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), eviocgrab, 1); errno != 0 {
+				log.Printf("Couldn't grab %s, keys will reach the console: %v", path, errno)
+			}
+			lock.Lock()
+			open[path] = true
+			lock.Unlock()
+
+			go func(path string, file *os.File) {
+				ranges := absRanges(file)
+				readInputEvents(file, func(ev inputEvent) {
+					events <- scaleAbs(ev, ranges)
+				})
+				//Unplugged, forget about it so it's opened again if it comes back
+				file.Close()
+				lock.Lock()
+				delete(open, path)
+				lock.Unlock()
+			}(path, file)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -0,0 +1,179 @@
+package sys
+
+/* evdev_linux_test.go -- Linux input events fed through the keyboard, joystick and mouse
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//fakeSink records every call the input handlers make, in order
+type fakeSink struct {
+	calls []string
+}
+
+func (f *fakeSink) KeyType(key int) {
+	f.calls = append(f.calls, fmt.Sprintf("type %q", rune(key)))
+}
+
+func (f *fakeSink) SysKeyDn(key appleii.SysKey) {
+	f.calls = append(f.calls, fmt.Sprintf("down %d", key))
+}
+
+func (f *fakeSink) SysKeyUp(key appleii.SysKey) {
+	f.calls = append(f.calls, fmt.Sprintf("up %d", key))
+}
+
+func (f *fakeSink) SetAxis(n int, v int) {
+	f.calls = append(f.calls, fmt.Sprintf("axis %d %d", n, v))
+}
+
+func (f *fakeSink) SetButton(n int, down bool) {
+	f.calls = append(f.calls, fmt.Sprintf("button %d %v", n, down))
+}
+
+func (f *fakeSink) MoveMouse(dx, dy int) {
+	f.calls = append(f.calls, fmt.Sprintf("move %d %d", dx, dy))
+}
+
+func (f *fakeSink) SetMouseButton(down bool) {
+	f.calls = append(f.calls, fmt.Sprintf("mouse %v", down))
+}
+
+//eventStream encodes events the way the kernel writes them, struct input_event with a zero timestamp
+func eventStream(events ...inputEvent) io.Reader {
+	var buf bytes.Buffer
+	for _, ev := range events {
+		buf.Write(make([]byte, timevalSize))
+		binary.Write(&buf, binary.LittleEndian, ev)
+	}
+	return &buf
+}
+
+//feed reads the stream through every handler and returns the calls they made
+func feed(t *testing.T, ranges map[uint16][2]int32, events ...inputEvent) []string {
+	t.Helper()
+	sink := &fakeSink{}
+	keyboard := evdevKeyboard{kbd: sink}
+	joystick := evdevJoystick{paddles: sink}
+	mouse := evdevMouse{mouse: sink}
+	err := readInputEvents(eventStream(events...), func(ev inputEvent) {
+		ev = scaleAbs(ev, ranges)
+		keyboard.handle(ev)
+		joystick.handle(ev)
+		mouse.handle(ev)
+	})
+	if err != io.EOF {
+		t.Fatalf("reading the events ended with %v", err)
+	}
+	return sink.calls
+}
+
+func key(code uint16, value int32) inputEvent {
+	return inputEvent{Type: evKey, Code: code, Value: value}
+}
+
+func checkCalls(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls %q\nwant %q", got, want)
+	}
+}
+
+func TestEvdevKeyRepeat(t *testing.T) {
+	//'a' pressed, auto repeated twice and let go
+	got := feed(t, nil, key(30, 1), key(30, 2), key(30, 2), key(30, 0))
+	checkCalls(t, got, `type 'a'`, `type 'a'`, `type 'a'`)
+}
+
+func TestEvdevShiftCtrl(t *testing.T) {
+	got := feed(t, nil,
+		key(keyLeftShift, 1), key(3, 1), key(3, 0), key(30, 1), key(30, 0), key(keyLeftShift, 0),
+		key(keyRightCtrl, 1), key(46, 1), key(46, 0), key(keyRightCtrl, 0),
+		key(keyCapsLock, 1), key(keyCapsLock, 0), key(30, 1), key(3, 1),
+	)
+	checkCalls(t, got,
+		fmt.Sprintf("down %d", appleii.KeyShift), `type '@'`, `type 'A'`, fmt.Sprintf("up %d", appleii.KeyShift),
+		fmt.Sprintf("down %d", appleii.KeyControl), `type '\x03'`, fmt.Sprintf("up %d", appleii.KeyControl),
+		`type 'A'`, `type '2'`,
+	)
+}
+
+func TestEvdevSystemKeys(t *testing.T) {
+	got := feed(t, nil, key(keyEnter, 1), key(keyEnter, 0), key(keyHome, 1), key(keyHome, 0))
+	checkCalls(t, got,
+		fmt.Sprintf("down %d", appleii.KeyReturn), fmt.Sprintf("up %d", appleii.KeyReturn),
+		fmt.Sprintf("down %d", appleii.KeyReset), fmt.Sprintf("up %d", appleii.KeyReset),
+	)
+}
+
+func TestEvdevMouseBatched(t *testing.T) {
+	rel := func(code uint16, v int32) inputEvent { return inputEvent{Type: evRel, Code: code, Value: v} }
+	syn := inputEvent{Type: evSyn}
+	got := feed(t, nil,
+		//One report is one move however many pieces it comes in
+		rel(relX, 3), rel(relY, -2), rel(relX, 4), syn,
+		//A report with no movement doesn't move
+		key(btnLeft, 1), syn,
+		rel(relY, 5), syn,
+		key(btnLeft, 0), syn,
+	)
+	checkCalls(t, got, "move 7 -2", "mouse true", "move 0 5", "mouse false")
+}
+
+func TestEvdevAbsScaling(t *testing.T) {
+	abs := func(code uint16, v int32) inputEvent { return inputEvent{Type: evAbs, Code: code, Value: v} }
+	ranges := map[uint16][2]int32{absX: {-32768, 32767}, absY: {0, 1023}, absHat0X: {-1, 1}}
+	got := feed(t, ranges,
+		abs(absX, -32768), abs(absX, 32767), abs(absX, 0),
+		abs(absY, 0), abs(absY, 1023), abs(absY, 512),
+		abs(absHat0X, -1), abs(absHat0X, 1),
+		//An axis with no range is already 0-255
+		abs(absRX, 200),
+	)
+	checkCalls(t, got,
+		"axis 0 0", "axis 0 255", "axis 0 127",
+		"axis 1 0", "axis 1 255", "axis 1 127",
+		"axis 0 0", "axis 0 255",
+		"axis 2 200",
+	)
+}
+
+func TestEvdevJoystickButtonsAndKeypad(t *testing.T) {
+	got := feed(t, nil, key(btnSouth, 1), key(btnSouth, 0), key(keyKP9, 1), key(keyKP9, 0))
+	checkCalls(t, got, "button 0 true", "button 0 false", "axis 0 255", "axis 1 0", "axis 0 128", "axis 1 128")
+}
+
+func TestEvdevShortRead(t *testing.T) {
+	//A device unplugged in the middle of an event stops the reader without a partial event
+	var buf bytes.Buffer
+	io.Copy(&buf, eventStream(key(30, 1)))
+	buf.Write(make([]byte, timevalSize+3))
+	var n int
+	err := readInputEvents(&buf, func(inputEvent) { n++ })
+	if err != io.ErrUnexpectedEOF || n != 1 {
+		t.Errorf("got %d events and %v, want 1 and %v", n, err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,124 @@
+package sys
+
+/* headless.go -- Runtime without a display or input for automated tooling
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"time"
+
+	"github.com/cupcakus/appleII-piz/video"
+)
+
+//HeadlessRunner runs the emulator as fast as possible without a display
+type HeadlessRunner struct {
+	cfg Config
+}
+
+//Outputs the benchmark scales to, a 640x480 monitor and a 3" TFT
+var benchOutputs = []struct {
+	size   image.Point
+	format video.PixelFormat
+}{{image.Point{640, 480}, video.FormatBGRA32}, {image.Point{480, 320}, video.FormatRGB565}}
+
+//NewHeadlessRunner returns a new HeadlessRunner
+func NewHeadlessRunner(cfg Config) *HeadlessRunner {
+	runner := HeadlessRunner{cfg: cfg}
+	return &runner
+}
+
+//Init startup the runner
+func (r *HeadlessRunner) Init() {
+}
+
+//Run the runtime
+func (r *HeadlessRunner) Run() string {
+	m := newMachine(video.NewHeadlessRenderer(), r.cfg)
+	for _, n := range r.cfg.Dumps {
+		m.vid.DumpFrame(n, filepath.Join(r.cfg.DumpDir, fmt.Sprintf("frame-%06d.png", n)))
+	}
+	if r.cfg.Record != "" {
+		m.record(r.cfg.Record)
+	}
+	defer m.close()
+	if r.cfg.Bench {
+		r.bench(m)
+		return ""
+	}
+
+	for frame := uint64(0); r.cfg.Frames == 0 || frame < r.cfg.Frames; frame++ {
+		if m.startFrame() {
+			break
+		}
+		m.mem.RunFrame()
+		//Always render, fast mode only matters when someone is watching
+		m.vid.RenderFrame(m.mem.GetGPUMemory())
+	}
+	return ""
+}
+
+//bench reports the average cost of each part of a frame
+func (r *HeadlessRunner) bench(m *machine) {
+	frames := r.cfg.Frames
+	if frames == 0 {
+		frames = 600
+	}
+
+	var emulate, render time.Duration
+	for frame := uint64(0); frame < frames; frame++ {
+		//The sound goes out between frames like a normal run, so it doesn't pile up
+		m.playAudio()
+		start := time.Now()
+		m.mem.RunFrame()
+		emulate += time.Since(start)
+
+		start = time.Now()
+		m.vid.RenderFrame(m.mem.GetGPUMemory())
+		render += time.Since(start)
+	}
+	fmt.Printf("%d frames (a real IIe takes 16.7ms per frame)\n", frames)
+	fmt.Printf("  emulate      %10v/frame (%.1fx a real IIe)\n", emulate/time.Duration(frames),
+		float64(frames)*float64(time.Second)/60/float64(emulate))
+	fmt.Printf("  render       %10v/frame\n", render/time.Duration(frames))
+
+	//Main RAM is read straight from the page table, the I/O page goes through Mem
+	for _, addr := range []uint16{0x0300, 0xC000} {
+		const reads = 1000000
+		start := time.Now()
+		for i := 0; i < reads; i++ {
+			m.bus.Read(addr)
+		}
+		fmt.Printf("  bus read $%04X %9v/read\n", addr, time.Since(start)/reads)
+	}
+
+	img := m.vid.Screenshot(0, 0).(*image.RGBA)
+	for _, output := range benchOutputs {
+		size, bpp := output.size, output.format.Bytes()
+		out := make([]byte, size.X*size.Y*bpp)
+		for mode := video.ScaleNearest; mode <= video.ScaleBilinear; mode++ {
+			scaler := video.NewScaler(mode, output.format, 560, 384, size.X, size.Y)
+			start := time.Now()
+			for frame := uint64(0); frame < frames; frame++ {
+				scaler.Scale(img, out, size.X*bpp)
+			}
+			fmt.Printf("  scale %3dx%d %2dbpp %-9v %10v/frame\n", size.X, size.Y, output.format.Bits, mode, time.Since(start)/time.Duration(frames))
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package sys
+
+/* imagewriter.go -- An ImageWriter II that prints to PNG pages
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+//The paper, US letter at 144 dots per inch (the ImageWriter's line feed unit)
+const (
+	iwDPI        = 144
+	iwPageWidth  = 8*iwDPI + iwDPI/2
+	iwPageLength = 11 * iwDPI
+	iwLineWidth  = 8.0 //Inches the print head can reach
+)
+
+//iwPitches dots per inch for each pitch command, characters are 8 dots wide in all of them
+var iwPitches = map[byte]float64{'n': 72, 'N': 80, 'E': 96, 'e': 107, 'q': 120, 'Q': 136, 'p': 144, 'P': 160}
+
+//iwArgs how many bytes follow each command, commands that aren't here take none. US (line feeds)
+//is kept with the escape commands because it takes an argument too
+var iwArgs = map[byte]int{'G': 4, 'S': 4, 'g': 3, 'V': 5, 'T': 2, 'L': 3, 'H': 4, 'F': 4, 'K': 1, 'R': 4,
+	'Z': 2, 'D': 2, 'l': 1, 'a': 1, 's': 1, 0x1F: 1}
+
+//iwInks the ribbon colors ESC K selects, black, yellow, red, blue, orange, green and purple
+var iwInks = []color.RGBA{{0, 0, 0, 255}, {255, 220, 0, 255}, {220, 20, 60, 255}, {30, 60, 200, 255},
+	{255, 140, 0, 255}, {0, 150, 60, 255}, {120, 40, 160, 255}}
+
+//imageWriter interprets the ImageWriter II's control codes and escape sequences, text is printed in
+//the Apple's own character set and graphics 8 dots high. A carriage return feeds a line as it does
+//with the printer's auto line feed switch on. Each page is saved to DIR/page-NNN.png when it comes out
+type imageWriter struct {
+	dir   string
+	pages int //Pages saved so far
+	font  []byte
+	page  *image.RGBA //nil until something is printed on it
+
+	x          float64 //Print head position in inches
+	y          int     //Paper position in 1/144 inch from the top of the page
+	dpi        float64 //Dots per inch of the current pitch
+	lineFeed   int     //Line feed in 1/144 inch
+	pageLength int     //Form length in 1/144 inch
+	margin     float64 //Left margin in inches
+	reverse    bool    //Line feeds go up the page
+	bold       bool
+	underline  bool
+	ink        color.RGBA
+
+	esc      []byte //Command and arguments collected so far, nil outside of an escape sequence
+	graphics int    //Graphics bytes still to come
+	download bool   //Skipping downloaded characters until ^D
+}
+
+//newImageWriter a printer that saves its pages in dir
+func newImageWriter(dir string) (*imageWriter, error) {
+	font, err := ioutil.ReadFile("./data/video.bin")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := imageWriter{dir: dir, font: font}
+	w.reset()
+	return &w, nil
+}
+
+//reset the printer's settings to the power on defaults, 10 characters an inch and 6 lines
+func (w *imageWriter) reset() {
+	w.dpi, w.lineFeed, w.pageLength, w.margin = 80, 24, iwPageLength, 0
+	w.reverse, w.bold, w.underline = false, false, false
+	w.ink = iwInks[0]
+}
+
+//Print interpret the next byte
+func (w *imageWriter) Print(b uint8) {
+	switch {
+	case w.graphics > 0:
+		w.graphics--
+		w.column(b)
+	case w.download:
+		w.download = b&0x7F != 0x04
+	case w.esc != nil:
+		//Arguments keep all 8 bits, ESC V repeats a graphics byte
+		w.esc = append(w.esc, b)
+		w.esc[0] &= 0x7F
+		if len(w.esc) > iwArgs[w.esc[0]] {
+			esc := w.esc
+			w.esc = nil
+			w.escape(esc[0], esc[1:])
+		}
+	default:
+		w.control(b & 0x7F)
+	}
+}
+
+//control a character outside of an escape sequence
+func (w *imageWriter) control(c uint8) {
+	switch c {
+	case 0x1B, 0x1F:
+		w.esc = []byte{c}
+		if c == 0x1B {
+			w.esc = w.esc[:0]
+		}
+	case '\r':
+		w.x = w.margin
+		w.feed(w.lineFeed)
+	case '\n':
+		w.feed(w.lineFeed)
+	case 0x0C:
+		w.eject()
+	case 0x08:
+		if w.x -= 8 / w.dpi; w.x < w.margin {
+			w.x = w.margin
+		}
+	default:
+		if c >= ' ' && c < 0x7F {
+			w.char(c)
+		}
+	}
+}
+
+//escape carry out ESC cmd (or US) with its arguments
+func (w *imageWriter) escape(cmd uint8, args []byte) {
+	switch cmd {
+	case 'G', 'S':
+		w.graphics = iwNumber(args)
+	case 'g':
+		w.graphics = iwNumber(args) * 8
+	case 'V':
+		for n := iwNumber(args[:4]); n > 0; n-- {
+			w.column(args[4])
+		}
+	case 'R':
+		for n := iwNumber(args[:3]); n > 0; n-- {
+			w.control(args[3] & 0x7F)
+		}
+	case 'T':
+		w.lineFeed = iwNumber(args)
+	case 'A':
+		w.lineFeed = 24
+	case 'B':
+		w.lineFeed = 18
+	case 'f', 'r':
+		w.reverse = cmd == 'r'
+	case 'L':
+		w.margin = float64(iwNumber(args)) * 8 / w.dpi
+	case 'H':
+		w.pageLength = iwNumber(args)
+	case 'F':
+		w.x = w.margin + float64(iwNumber(args))/w.dpi
+	case 'K':
+		if n := iwNumber(args); n < len(iwInks) {
+			w.ink = iwInks[n]
+		}
+	case '!', '"':
+		w.bold = cmd == '!'
+	case 'X', 'Y':
+		w.underline = cmd == 'X'
+	case 'c':
+		w.reset()
+	case 'I':
+		w.download = true
+	case '1', '2', '3', '4', '5', '6':
+		w.x += float64(cmd-'0') / w.dpi
+	case 0x1F:
+		//US 1-9 and : to ? feed 1 to 15 lines
+		for n := int(args[0]&0x7F) - '0'; n > 0; n-- {
+			w.feed(w.lineFeed)
+		}
+	default:
+		if dpi, ok := iwPitches[cmd]; ok {
+			w.dpi = dpi
+		}
+	}
+}
+
+//iwNumber the decimal number sent as ASCII digits
+func iwNumber(digits []byte) int {
+	n := 0
+	for _, d := range digits {
+		if d &= 0x7F; d >= '0' && d <= '9' {
+			n = n*10 + int(d-'0')
+		} else {
+			n *= 10
+		}
+	}
+	return n
+}
+
+//feed move the paper by n/144 inch, on to the next page when it runs off the end
+func (w *imageWriter) feed(n int) {
+	if w.reverse {
+		if w.y -= n; w.y < 0 {
+			w.y = 0
+		}
+		return
+	}
+	if w.y += n; w.y >= w.pageLength {
+		w.save()
+		w.y -= w.pageLength
+	}
+}
+
+//eject the form feed, the next page starts at the top
+func (w *imageWriter) eject() {
+	w.save()
+	w.y = 0
+}
+
+//char print c and move along a character, going on to the next line at the edge of the paper
+func (w *imageWriter) char(c uint8) {
+	if w.x+8/w.dpi > iwLineWidth {
+		w.control('\r')
+	}
+	glyph := int(c|0x80) * 8
+	for row := 0; row < 8 && glyph+row < len(w.font); row++ {
+		for col := 0; col < 7; col++ {
+			//A clear bit is a lit pixel in the video ROM
+			if w.font[glyph+row]&(1<<uint(col)) == 0 {
+				w.dot(w.x+float64(col)/w.dpi, row)
+				if w.bold {
+					w.dot(w.x+(float64(col)+0.5)/w.dpi, row)
+				}
+			}
+		}
+	}
+	if w.underline {
+		for col := 0; col < 8; col++ {
+			w.dot(w.x+float64(col)/w.dpi, 8)
+		}
+	}
+	w.x += 8 / w.dpi
+}
+
+//column print a column of graphics, bit 0 is the top pin
+func (w *imageWriter) column(b uint8) {
+	for pin := 0; pin < 8; pin++ {
+		if b&(1<<uint(pin)) != 0 {
+			w.dot(w.x, pin)
+		}
+	}
+	w.x += 1 / w.dpi
+}
+
+//dot strike pin (1/72 inch apart) at x inches, the ink darkens what is already there
+func (w *imageWriter) dot(x float64, pin int) {
+	if w.page == nil {
+		w.page = image.NewRGBA(image.Rect(0, 0, iwPageWidth, iwPageLength))
+		draw.Draw(w.page, w.page.Bounds(), image.White, image.Point{}, draw.Src)
+	}
+	px, py := int(x*iwDPI), w.y+pin*2
+	for _, p := range [4]image.Point{{px, py}, {px + 1, py}, {px, py + 1}, {px + 1, py + 1}} {
+		if !p.In(w.page.Bounds()) {
+			continue
+		}
+		c := w.page.RGBAAt(p.X, p.Y)
+		c.R = uint8(uint(c.R) * uint(w.ink.R) / 255)
+		c.G = uint8(uint(c.G) * uint(w.ink.G) / 255)
+		c.B = uint8(uint(c.B) * uint(w.ink.B) / 255)
+		w.page.SetRGBA(p.X, p.Y, c)
+	}
+}
+
+//save write the page out if anything was printed on it
+func (w *imageWriter) save() error {
+	if w.page == nil {
+		return nil
+	}
+	w.pages++
+	filename := filepath.Join(w.dir, fmt.Sprintf("page-%03d.png", w.pages))
+	page := w.page
+	w.page = nil
+	file, err := os.Create(filename)
+	if err == nil {
+		err = png.Encode(file, page)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save printed page %s: %v", filename, err)
+	}
+	return err
+}
+
+//Close finish the page in the printer
+func (w *imageWriter) Close() error {
+	return w.save()
+}
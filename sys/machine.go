@@ -0,0 +1,404 @@
+package sys
+
+/* machine.go -- The emulated Apple IIe shared by every runtime
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+	"github.com/cupcakus/appleII-piz/video"
+)
+
+//machine is all the hardware of the emulated Apple IIe
+type machine struct {
+	bus *appleii.Bus
+	cpu *appleii.CPU
+	mem *appleii.Mem
+	dsk *appleii.Dsk
+	kbd *appleii.Kbd
+	vid *video.System
+	//audio mixes the sound of the cards, wav records it, optional
+	audio *appleii.Mixer
+	wav   *wavWriter
+	//serial the connection of the serial card in each slot
+	serial [8]*serialLine
+	//printers the printers plugged into the printer cards
+	printers []printer
+	//tapeOut records the cassette output, optional
+	tapeOut *tapeRecorder
+	//mouse the mouse card, optional
+	mouse *appleii.MouseCard
+	//clock the time (seconds since 1970, local time) the clock cards read at cycle 0
+	clock int64
+	//script drives the machine unattended, optional
+	script *script
+	//movie records every input, player replays a recorded movie, both optional
+	movie  *movieRecorder
+	player *moviePlayer
+}
+
+//cardTypes makes each kind of card the slots can be configured with
+var cardTypes = map[string]func(m *machine, cfg Config, slot int) appleii.Card{
+	//There's one Disk ][ controller, the drives in the scripts and the movies are its drives
+	"disk": func(m *machine, cfg Config, slot int) appleii.Card {
+		for s := 1; s < slot; s++ {
+			if m.mem.Card(s) == appleii.Card(m.dsk) {
+				log.Fatalf("Only one Disk ][ controller is supported, it's already in slot %d", s)
+			}
+		}
+		return m.dsk
+	},
+	"harddisk": func(m *machine, cfg Config, slot int) appleii.Card {
+		hd := appleii.NewHardDisk(m.bus, slot)
+		for _, filename := range cfg.HardDisks[slot] {
+			v, err := appleii.OpenVolume(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hd.Attach(v)
+		}
+		return hd
+	},
+	"serial": func(m *machine, cfg Config, slot int) appleii.Card {
+		ssc := appleii.NewSSC(m.bus, slot, cfg.SerialSwitches)
+		if cfg.Serial[slot] != "" {
+			line, err := openSerial(cfg.Serial[slot])
+			if err != nil {
+				log.Fatal(err)
+			}
+			ssc.Connect(line)
+			m.serial[slot] = line
+		}
+		return ssc
+	},
+	"printer": func(m *machine, cfg Config, slot int) appleii.Card {
+		card := appleii.NewParallelCard(slot)
+		if cfg.Printers[slot] != "" {
+			p, err := openPrinter(cfg.Printers[slot])
+			if err != nil {
+				log.Fatal(err)
+			}
+			card.Printer = p
+			m.printers = append(m.printers, p)
+		}
+		return card
+	},
+	"clock": func(m *machine, cfg Config, slot int) appleii.Card {
+		return appleii.NewClockCard(m.bus, slot, m.clock)
+	},
+	"mouse": func(m *machine, cfg Config, slot int) appleii.Card {
+		m.mouse = appleii.NewMouseCard(m.bus, m.mem, slot)
+		return m.mouse
+	},
+	"softcard": func(m *machine, cfg Config, slot int) appleii.Card {
+		return appleii.NewSoftCard(m.bus)
+	},
+	"saturn": func(m *machine, cfg Config, slot int) appleii.Card {
+		return appleii.NewSaturnCard(m.mem)
+	},
+	"mockingboard": func(m *machine, cfg Config, slot int) appleii.Card {
+		return newMockingboard(m, cfg, slot, false)
+	},
+	"mockingboard-speech": func(m *machine, cfg Config, slot int) appleii.Card {
+		return newMockingboard(m, cfg, slot, true)
+	},
+}
+
+//newMockingboard the sound only goes to the -wav recording, there's no host audio output. Without
+//-wav the PSGs are left quiet so they cost nothing
+func newMockingboard(m *machine, cfg Config, slot int, speech bool) appleii.Card {
+	if cfg.Audio == "" {
+		log.Printf("The Mockingboard in slot %d is silent without -wav, its sound is only recorded to a file", slot)
+	}
+	return appleii.NewMockingboard(m.bus, m.audio, slot, speech)
+}
+
+//newMachine builds an Apple IIe configured by cfg that renders to ren and resets it
+func newMachine(ren video.Renderer, cfg Config) *machine {
+	m := machine{}
+	m.bus = appleii.NewBus()
+	m.cpu = appleii.NewCPU(m.bus)
+	m.mem = appleii.NewMem(m.bus, m.cpu)
+	m.dsk = appleii.NewDsk(m.bus)
+	//The sound is only recorded, there's nothing to mix without a recording
+	m.audio = appleii.NewMixer(m.bus, cfg.Audio != "")
+	m.bus.Add(m.mem, 0, 0xFFFF)
+	m.kbd = appleii.NewKbd(m.mem, m.cpu)
+	m.vid = video.NewVideo(m.bus, ren)
+	now := time.Now().Add(cfg.ClockOffset)
+	_, zone := now.Zone()
+	m.clock = now.Unix() + int64(zone)
+	for slot, name := range cfg.Slots {
+		if name == "" {
+			continue
+		}
+		newCard, ok := cardTypes[name]
+		if !ok {
+			log.Fatalf("Unknown card %q in slot %d", name, slot)
+		}
+		m.mem.InsertCard(slot, newCard(&m, cfg, slot))
+	}
+	for slot, volumes := range cfg.HardDisks {
+		if _, ok := m.mem.Card(slot).(*appleii.HardDisk); len(volumes) > 0 && !ok {
+			log.Fatalf("There is no hard disk card in slot %d", slot)
+		}
+	}
+	for slot, spec := range cfg.Serial {
+		if _, ok := m.mem.Card(slot).(*appleii.SSC); spec != "" && !ok {
+			log.Fatalf("There is no serial card in slot %d", slot)
+		}
+	}
+	for slot, spec := range cfg.Printers {
+		if _, ok := m.mem.Card(slot).(*appleii.ParallelCard); spec != "" && !ok {
+			log.Fatalf("There is no printer card in slot %d", slot)
+		}
+	}
+	if cfg.AuxBanks > 1 {
+		m.mem.SetAuxBanks(cfg.AuxBanks)
+	}
+	m.mem.Paddles.Axes = cfg.Paddles
+	m.kbd.PasteDelay = cfg.PasteDelay
+	m.kbd.Uppercase = cfg.Uppercase
+	m.cpu.Reset()
+
+	var start *appleii.State
+	if cfg.Replay != "" {
+		header, player, err := loadMovie(cfg.Replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := header.Machine.check(cfg); err != nil {
+			log.Fatal(err)
+		}
+		m.kbd.PasteDelay, m.kbd.Uppercase = header.PasteDelay, header.Uppercase
+		m.setClock(header.Clock)
+		if header.Start != nil {
+			if err := m.setState(header.Start); err != nil {
+				log.Fatal(err)
+			}
+		}
+		m.player = player
+	} else if cfg.LoadState != "" {
+		if err := m.loadState(cfg.LoadState); err != nil {
+			log.Fatal(err)
+		}
+		start = m.state()
+	}
+	if cfg.Movie != "" {
+		header := movieHeader{Version: movieVersion, Start: start, PasteDelay: m.kbd.PasteDelay, Uppercase: m.kbd.Uppercase,
+			Clock: m.clock, Machine: newMovieMachine(cfg)}
+		movie, err := newMovieRecorder(cfg.Movie, header)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.movie = movie
+	}
+
+	if cfg.Audio != "" {
+		wav, err := newWavWriter(cfg.Audio)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.wav = wav
+	}
+
+	if cfg.TapeIn != "" {
+		tape, err := loadTape(cfg.TapeIn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.mem.Cassette.Insert(tape)
+	}
+	if cfg.TapeOut != "" {
+		rec, err := newTapeRecorder(cfg.TapeOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.tapeOut = rec
+		m.mem.Cassette.Recorder = rec
+	}
+
+	if cfg.Paste != "" {
+		m.pasteFile(cfg.Paste)
+	}
+	if cfg.Script != "" {
+		s, err := loadScript(cfg.Script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.script = s
+	}
+	return &m
+}
+
+//startFrame feeds in the movie being replayed and runs the script up to its next wait, call it
+//before each frame. It returns true when the script quits
+func (m *machine) startFrame() bool {
+	m.playAudio()
+	if m.player != nil {
+		m.player.play(m)
+		if m.player.done() {
+			log.Printf("Movie finished")
+			m.player = nil
+		}
+	}
+	m.pollSerial()
+	if m.script == nil {
+		return false
+	}
+	quit, err := m.script.step(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return quit
+}
+
+//setClock start the clock cards at epoch
+func (m *machine) setClock(epoch int64) {
+	m.clock = epoch
+	for slot := 1; slot < 8; slot++ {
+		if c, ok := m.mem.Card(slot).(*appleii.ClockCard); ok {
+			c.SetEpoch(epoch)
+		}
+	}
+}
+
+//playAudio send the sound of the last frame out
+func (m *machine) playAudio() {
+	samples := m.audio.Samples()
+	if m.wav == nil {
+		return
+	}
+	if err := m.wav.Write(samples); err != nil {
+		log.Printf("Sound recording failed: %v", err)
+		m.wav.Close()
+		m.wav = nil
+	}
+}
+
+//close finishes any recordings and printing and hangs up the serial ports
+func (m *machine) close() {
+	m.vid.StopRecording()
+	m.playAudio()
+	if m.wav != nil {
+		if err := m.wav.Close(); err != nil {
+			log.Printf("Sound recording failed: %v", err)
+		}
+		m.wav = nil
+	}
+	if m.tapeOut != nil {
+		if err := m.tapeOut.Close(); err != nil {
+			log.Printf("Tape recording failed: %v", err)
+		}
+		m.mem.Cassette.Recorder = nil
+		m.tapeOut = nil
+	}
+	if m.movie != nil {
+		m.movie.Close()
+		m.movie = nil
+	}
+	for slot, line := range m.serial {
+		if line != nil {
+			line.Close()
+			m.serial[slot] = nil
+		}
+	}
+	for _, p := range m.printers {
+		p.Close()
+	}
+	m.printers = nil
+}
+
+//pollSerial pass what came in on the serial lines to the cards, like the keyboard it's an input
+//that's recorded into the movie
+func (m *machine) pollSerial() {
+	for slot, line := range m.serial {
+		if line == nil {
+			continue
+		}
+		if data := line.poll(); len(data) > 0 {
+			m.input(movieEvent{Kind: movieSerial, N: slot, Text: string(data)})
+		}
+	}
+}
+
+//runFrame emulates a single video frame and renders it
+func (m *machine) runFrame() {
+	m.mem.RunFrame()
+	if !m.bus.GetFastMode() {
+		m.vid.RenderFrame(m.mem.GetGPUMemory())
+	}
+}
+
+//captureName a timestamped filename next to the disk image for screenshots and recordings
+func (m *machine) captureName(ext string) string {
+	dir, name := ".", "appleii"
+	if path := m.dsk.DiskPath(); path != "" {
+		dir = filepath.Dir(path)
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return filepath.Join(dir, name+"-"+time.Now().Format("20060102-150405")+ext)
+}
+
+//screenshot saves the last frame as a timestamped PNG next to the disk image
+func (m *machine) screenshot() {
+	filename := m.captureName(".png")
+	if err := m.vid.SaveScreenshot(filename, 0, 0); err != nil {
+		log.Printf("Screenshot failed: %v", err)
+		return
+	}
+	log.Printf("Screenshot saved to %s", filename)
+}
+
+//toggleRecording starts recording an animated GIF next to the disk image, or stops the recording in progress
+func (m *machine) toggleRecording() {
+	if m.vid.Recording() {
+		if err := m.vid.StopRecording(); err != nil {
+			log.Printf("Recording failed: %v", err)
+		}
+		log.Printf("Recording stopped")
+		return
+	}
+	m.record(m.captureName(".gif"))
+}
+
+//record starts recording to filename, the extension picks the format
+func (m *machine) record(filename string) {
+	rec, err := video.NewRecorder(filename)
+	if err != nil {
+		log.Printf("Recording failed: %v", err)
+		return
+	}
+	m.vid.StartRecording(rec)
+	log.Printf("Recording to %s", filename)
+}
+
+//pasteFile types the contents of filename into the emulator
+func (m *machine) pasteFile(filename string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Printf("Paste failed: %v", err)
+		return
+	}
+	m.paste(string(data))
+}
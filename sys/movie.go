@@ -0,0 +1,398 @@
+package sys
+
+/* movie.go -- Records every input with its cycle count and replays it exactly
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+/* Host input only reaches the machine between frames, so a movie made of the inputs and the
+   cycle count they arrived at replays into exactly the same RAM and video every time. A movie
+   file is a gob stream: a movieHeader followed by one movieEvent per input. */
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//movieVersion bump when the header, the events or the save state change meaning
+const movieVersion = 3
+
+//Kinds of movie events
+const (
+	movieKeyType = iota
+	movieSysKeyDn
+	movieSysKeyUp
+	moviePaste
+	movieAxis
+	movieButton
+	movieInsert
+	movieEject
+	movieMouseMove
+	movieMouseButton
+	movieTape
+	movieSerial
+)
+
+//movieHeader starts a movie, Start is nil when the movie starts from a reset
+type movieHeader struct {
+	Version int
+	Start   *appleii.State
+	//Keyboard settings that change how inputs play out
+	PasteDelay uint64
+	Uppercase  bool
+	//Clock the time the clock cards started at
+	Clock int64
+	//Machine the hardware the movie was recorded on
+	Machine movieMachine
+}
+
+//movieMachine the parts of the configuration a movie has to be replayed with
+type movieMachine struct {
+	Slots     [8]string
+	AuxBanks  int
+	HardDisks [8][]string
+	Serial    [8]string
+}
+
+//newMovieMachine the hardware cfg configures
+func newMovieMachine(cfg Config) movieMachine {
+	mm := movieMachine{Slots: cfg.Slots, AuxBanks: cfg.AuxBanks, HardDisks: cfg.HardDisks, Serial: cfg.Serial}
+	if mm.AuxBanks < 1 {
+		mm.AuxBanks = 1
+	}
+	return mm
+}
+
+//check the movie can be replayed on the hardware cfg configures. Different cards or memory can't
+//replay at all, different disk images or serial connections might not replay the same
+func (mm movieMachine) check(cfg Config) error {
+	now := newMovieMachine(cfg)
+	if mm.Slots != now.Slots {
+		return fmt.Errorf("Movie was recorded with the cards %q, replay it with the same -slots", mm.Slots)
+	}
+	if mm.AuxBanks != now.AuxBanks {
+		return fmt.Errorf("Movie was recorded with %dKB of aux memory, replay it with the same -ramworks", mm.AuxBanks*64)
+	}
+	if !reflect.DeepEqual(mm.HardDisks, now.HardDisks) {
+		log.Printf("Movie was recorded with the hard disks %q and may not replay correctly", mm.HardDisks)
+	}
+	if mm.Serial != now.Serial {
+		log.Printf("Movie was recorded with the serial connections %q and may not replay correctly", mm.Serial)
+	}
+	return nil
+}
+
+//movieEvent one input to the machine
+type movieEvent struct {
+	Cycle uint64 //CPU cycle count the input arrived at
+	Kind  int
+	N     int    //Key, paddle, button, drive or slot number, tape control, or how far the mouse moved across
+	Value int    //Axis value, how far the mouse moved down or 1 for a button press
+	Text  string //Pasted text, disk image or the bytes a serial line received
+}
+
+//movieRecorder writes a movie as the inputs happen
+type movieRecorder struct {
+	file *os.File
+	enc  *gob.Encoder
+}
+
+//newMovieRecorder starts a movie described by header
+func newMovieRecorder(filename string, header movieHeader) (*movieRecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := movieRecorder{file: file, enc: gob.NewEncoder(file)}
+	if err := r.enc.Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *movieRecorder) write(ev movieEvent) error {
+	return r.enc.Encode(ev)
+}
+
+//Close finish the movie
+func (r *movieRecorder) Close() error {
+	return r.file.Close()
+}
+
+//moviePlayer feeds a movie's inputs back in at the cycles they were recorded at
+type moviePlayer struct {
+	events []movieEvent
+	next   int
+	synced bool //False once an input had to be applied late
+}
+
+//loadMovie reads a whole movie
+func loadMovie(filename string) (*movieHeader, *moviePlayer, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	dec := gob.NewDecoder(file)
+	var header movieHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, nil, err
+	}
+	if header.Version != movieVersion {
+		return nil, nil, fmt.Errorf("%s was recorded by version %d of the movie format, only version %d can be replayed",
+			filename, header.Version, movieVersion)
+	}
+	p := moviePlayer{synced: true}
+	for {
+		var ev movieEvent
+		if err := dec.Decode(&ev); err == io.EOF || err == io.ErrUnexpectedEOF {
+			//A movie cut short by a crash still plays up to where it stopped
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		p.events = append(p.events, ev)
+	}
+	return &header, &p, nil
+}
+
+//done has every input been played
+func (p *moviePlayer) done() bool {
+	return p.next >= len(p.events)
+}
+
+//play applies every input that's due, call it between frames
+func (p *moviePlayer) play(m *machine) {
+	now := m.cpu.GetCycleCount()
+	for !p.done() && p.events[p.next].Cycle <= now {
+		ev := p.events[p.next]
+		if ev.Cycle != now && p.synced {
+			log.Printf("Movie is out of sync, input for cycle %d played at %d", ev.Cycle, now)
+			p.synced = false
+		}
+		m.apply(ev)
+		p.next++
+	}
+}
+
+//input is the way every host input reaches the machine, it's recorded into the movie or
+//dropped while a movie plays
+func (m *machine) input(ev movieEvent) error {
+	if m.player != nil {
+		return nil
+	}
+	ev.Cycle = m.cpu.GetCycleCount()
+	if m.movie != nil {
+		if err := m.movie.write(ev); err != nil {
+			log.Printf("Movie recording failed: %v", err)
+			m.movie.Close()
+			m.movie = nil
+		}
+	}
+	return m.apply(ev)
+}
+
+//apply an input to the hardware
+func (m *machine) apply(ev movieEvent) error {
+	switch ev.Kind {
+	case movieKeyType:
+		m.kbd.KeyType(ev.N)
+	case movieSysKeyDn:
+		m.kbd.SysKeyDn(appleii.SysKey(ev.N))
+	case movieSysKeyUp:
+		m.kbd.SysKeyUp(appleii.SysKey(ev.N))
+	case moviePaste:
+		m.kbd.Paste(ev.Text)
+	case movieAxis:
+		m.mem.Paddles.SetAxis(ev.N, ev.Value)
+	case movieButton:
+		m.mem.Paddles.SetButton(ev.N, ev.Value != 0)
+	case movieInsert:
+		return m.dsk.Insert(ev.N, ev.Text)
+	case movieEject:
+		m.dsk.Eject(ev.N)
+	case movieMouseMove:
+		if m.mouse != nil {
+			m.mouse.Move(ev.N, ev.Value)
+		}
+	case movieMouseButton:
+		if m.mouse != nil {
+			m.mouse.SetButton(ev.Value != 0)
+		}
+	case movieTape:
+		switch ev.N {
+		case tapePlay:
+			m.mem.Cassette.Play()
+		case tapeStop:
+			m.mem.Cassette.Stop()
+		case tapeRewind:
+			m.mem.Cassette.Rewind()
+		}
+	case movieSerial:
+		if ev.N >= 0 && ev.N < len(m.serial) && m.serial[ev.N] != nil {
+			m.serial[ev.N].deliver([]byte(ev.Text))
+		}
+	}
+	return nil
+}
+
+//KeyType type a character
+func (m *machine) KeyType(key int) {
+	m.input(movieEvent{Kind: movieKeyType, N: key})
+}
+
+//SysKeyDn press a system key
+func (m *machine) SysKeyDn(key appleii.SysKey) {
+	m.input(movieEvent{Kind: movieSysKeyDn, N: int(key)})
+}
+
+//SysKeyUp let go of a system key
+func (m *machine) SysKeyUp(key appleii.SysKey) {
+	m.input(movieEvent{Kind: movieSysKeyUp, N: int(key)})
+}
+
+//SetAxis move a paddle
+func (m *machine) SetAxis(n int, v int) {
+	m.input(movieEvent{Kind: movieAxis, N: n, Value: v})
+}
+
+//SetButton press or let go of a push button
+func (m *machine) SetButton(n int, down bool) {
+	ev := movieEvent{Kind: movieButton, N: n}
+	if down {
+		ev.Value = 1
+	}
+	m.input(ev)
+}
+
+//MoveMouse move the mouse, there's nothing to record without a mouse card
+func (m *machine) MoveMouse(dx, dy int) {
+	if m.mouse != nil && (dx != 0 || dy != 0) {
+		m.input(movieEvent{Kind: movieMouseMove, N: dx, Value: dy})
+	}
+}
+
+//SetMouseButton press or let go of the mouse button
+func (m *machine) SetMouseButton(down bool) {
+	if m.mouse == nil {
+		return
+	}
+	ev := movieEvent{Kind: movieMouseButton}
+	if down {
+		ev.Value = 1
+	}
+	m.input(ev)
+}
+
+//paste type text into the machine
+func (m *machine) paste(text string) {
+	m.input(movieEvent{Kind: moviePaste, Text: text})
+}
+
+//insert put a diskette in a drive
+func (m *machine) insert(drive int, filename string) error {
+	return m.input(movieEvent{Kind: movieInsert, N: drive, Text: filename})
+}
+
+//eject take the diskette out of a drive
+func (m *machine) eject(drive int) {
+	m.input(movieEvent{Kind: movieEject, N: drive})
+}
+
+//tape press one of the cassette player's buttons
+func (m *machine) tape(control int) {
+	m.input(movieEvent{Kind: movieTape, N: control})
+}
+
+//state snapshot the whole machine
+func (m *machine) state() *appleii.State {
+	s := appleii.State{
+		Bus:      m.bus.State(),
+		CPU:      m.cpu.State(),
+		Mem:      m.mem.State(),
+		Dsk:      m.dsk.State(),
+		Kbd:      m.kbd.State(),
+		Paddles:  m.mem.Paddles.State(),
+		Cassette: m.mem.Cassette.State(),
+	}
+	for slot := range s.Cards {
+		if c, ok := m.mem.Card(slot).(appleii.StateCard); ok {
+			data, err := c.CardState()
+			if err != nil {
+				log.Fatalf("Can't save the card in slot %d: %v", slot, err)
+			}
+			s.Cards[slot] = data
+		}
+	}
+	return &s
+}
+
+//setState put the whole machine back to a snapshot, it has to be from a machine with the same memory
+func (m *machine) setState(s *appleii.State) error {
+	//Memory goes first, it's what refuses a snapshot of a different machine
+	if err := m.mem.SetState(s.Mem); err != nil {
+		return err
+	}
+	m.bus.SetState(s.Bus)
+	m.cpu.SetState(s.CPU)
+	m.dsk.SetState(s.Dsk)
+	m.kbd.SetState(s.Kbd)
+	m.mem.Paddles.SetState(s.Paddles)
+	m.mem.Cassette.SetState(s.Cassette)
+	for slot, data := range s.Cards {
+		if c, ok := m.mem.Card(slot).(appleii.StateCard); ok && data != nil {
+			if err := c.SetCardState(data); err != nil {
+				log.Printf("Can't restore the card in slot %d: %v", slot, err)
+			}
+		}
+	}
+	return nil
+}
+
+//saveState write a snapshot of the machine to filename
+func (m *machine) saveState(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := m.state().Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//loadState put the machine back to the snapshot in filename
+func (m *machine) loadState(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s, err := appleii.ReadState(file)
+	if err != nil {
+		return err
+	}
+	return m.setState(s)
+}
@@ -0,0 +1,85 @@
+package sys
+
+/* printer.go -- Where the printer cards' output goes
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//printer an appleii.Printer that has to be closed to finish its output
+type printer interface {
+	appleii.Printer
+	Close() error
+}
+
+//openPrinter open spec, file:NAME for a text file or imagewriter:DIR for PNG pages
+func openPrinter(spec string) (printer, error) {
+	kind, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "file":
+		file, err := os.Create(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &textPrinter{file: file}, nil
+	case "imagewriter":
+		return newImageWriter(arg)
+	}
+	return nil, fmt.Errorf("Unknown printer %q", spec)
+}
+
+//textPrinter writes what's printed to a text file. The high bit is stripped, a carriage return is
+//a new line (a line feed straight after it adds nothing) and other control characters are dropped
+type textPrinter struct {
+	file *os.File
+	cr   bool //Last character was a carriage return
+	err  bool //A write failed and has been reported
+}
+
+//Print write b, a character at a time so the file can be watched
+func (t *textPrinter) Print(b uint8) {
+	b &= 0x7F
+	cr := t.cr
+	t.cr = b == '\r'
+	switch {
+	case b == '\r':
+		b = '\n'
+	case b == '\n' && cr:
+		return
+	case b < ' ' && b != '\n' && b != '\t' && b != '\f', b == 0x7F:
+		return
+	}
+	if _, err := t.file.Write([]byte{b}); err != nil && !t.err {
+		log.Printf("Printing failed: %v", err)
+		t.err = true
+	}
+}
+
+//Close close the file
+func (t *textPrinter) Close() error {
+	return t.file.Close()
+}
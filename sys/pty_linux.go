@@ -0,0 +1,72 @@
+package sys
+
+/* pty_linux.go -- Linux pseudo terminals for the serial card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+//Pseudo terminal ioctls
+const (
+	tiocgptn   = 0x80045430 //Get the pty number
+	tiocsptlck = 0x40045431 //Lock or unlock the pty
+)
+
+//ptyMaster the master side of a pty, reads wait for someone to open the other side instead of failing
+type ptyMaster struct {
+	*os.File
+}
+
+//Read from whoever has the other side open
+func (p ptyMaster) Read(b []byte) (int, error) {
+	for {
+		n, err := p.File.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.EIO {
+			return n, err
+		}
+		//Nobody has the other side open yet (or they closed it)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+//openPty make a new pseudo terminal, returning its master side and the name of the other side
+func openPty() (ptyMaster, string, error) {
+	file, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return ptyMaster{}, "", err
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), tiocsptlck, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		file.Close()
+		return ptyMaster{}, "", errno
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), tiocgptn, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		file.Close()
+		return ptyMaster{}, "", errno
+	}
+	return ptyMaster{file}, fmt.Sprintf("/dev/pts/%d", n), nil
+}
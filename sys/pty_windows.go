@@ -0,0 +1,29 @@
+package sys
+
+/* pty_windows.go -- Windows has no pseudo terminals for the serial card
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"errors"
+	"os"
+)
+
+//openPty there are no pseudo terminals, use a TCP connection
+func openPty() (*os.File, string, error) {
+	return nil, "", errors.New("Pseudo terminals are only on Linux, use listen:ADDR instead")
+}
@@ -0,0 +1,322 @@
+package sys
+
+/* script.go -- Scripted input for unattended runs and repeatable demos
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+/* A script is one command per line, blank lines and lines starting with # are ignored
+   and text arguments are Go quoted strings so "\r" is RETURN:
+
+   wait text "]" [frames]      wait until the text screen shows "]", fail after frames if given
+   wait frames 60              let 60 frames go by
+   wait paste                  wait until everything typed has been read by the software
+   type "CATALOG\r"            type the text, a character each time the software asks for one
+   key openapple+ctrl+reset    press a key combination
+   insert 2 "disks/game.dsk"   put a diskette in drive 1 or 2
+   eject 2                     take the diskette out of drive 1 or 2
+   mouse move 10 -5            move the mouse card's mouse 10 across and 5 up
+   mouse down                  press the mouse button, mouse up lets go of it
+   tape play                   start the cassette player, tape stop and tape rewind work the same way
+   screenshot ["file.png"]     save the screen, next to the disk image if no name is given
+   save "game.state"           save the whole machine, -load starts from it
+   quit                        stop the emulator
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//keyHoldFrames how long a key combination is held down, long enough for the ROM to see the modifiers after a reset
+const keyHoldFrames = 10
+
+//scriptKeys the system keys a key combination can name
+var scriptKeys = map[string]appleii.SysKey{
+	"shift":      appleii.KeyShift,
+	"ctrl":       appleii.KeyControl,
+	"openapple":  appleii.KeyOpenApple,
+	"solidapple": appleii.KeyFilledApple,
+	"reset":      appleii.KeyReset,
+	"left":       appleii.KeyLeft,
+	"right":      appleii.KeyRight,
+	"up":         appleii.KeyUp,
+	"down":       appleii.KeyDown,
+	"esc":        appleii.KeyEscape,
+	"return":     appleii.KeyReturn,
+	"delete":     appleii.KeyDelete,
+}
+
+//scriptLine one command of a script
+type scriptLine struct {
+	num  int      //Line number in the file for error messages
+	cmd  string   //The command, with "wait" joined to its kind ("wait text")
+	args []string //Arguments with quoted strings already unquoted
+}
+
+//script runs a list of commands against the machine, one step per frame
+type script struct {
+	name  string
+	lines []scriptLine
+	next  int              //Index of the line being run
+	frame uint64           //Frames since the current line started
+	held  []appleii.SysKey //Keys held down by the current key combination
+}
+
+//loadScript reads and checks a script file
+func loadScript(filename string) (*script, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s := script{name: filename}
+	scanner := bufio.NewScanner(file)
+	for num := 1; scanner.Scan(); num++ {
+		words, err := splitScriptLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, num, err)
+		}
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		line := scriptLine{num: num, cmd: words[0], args: words[1:]}
+		if line.cmd == "wait" && len(line.args) > 0 {
+			line.cmd += " " + line.args[0]
+			line.args = line.args[1:]
+		}
+		if err := line.check(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, num, err)
+		}
+		s.lines = append(s.lines, line)
+	}
+	return &s, scanner.Err()
+}
+
+//splitScriptLine breaks a line into words, a word starting with " is a Go quoted string
+func splitScriptLine(text string) ([]string, error) {
+	var words []string
+	text = strings.TrimSpace(text)
+	for text != "" {
+		if text[0] == '"' {
+			end := 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string %s", text)
+			}
+			word, err := strconv.Unquote(text[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", text[:end+1])
+			}
+			words = append(words, word)
+			text = text[end+1:]
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			words = append(words, text[:end])
+			text = text[end:]
+		}
+		text = strings.TrimSpace(text)
+	}
+	return words, nil
+}
+
+//check the line is a command the script knows with the right arguments
+func (l *scriptLine) check() error {
+	var min, max int
+	switch l.cmd {
+	case "wait paste", "quit":
+		min, max = 0, 0
+	case "type", "key", "wait frames", "eject", "save", "tape":
+		min, max = 1, 1
+	case "wait text", "insert":
+		min, max = 1, 2
+	case "screenshot":
+		min, max = 0, 1
+	case "mouse":
+		min, max = 1, 3
+	default:
+		return fmt.Errorf("unknown command %q", l.cmd)
+	}
+	if len(l.args) < min || len(l.args) > max {
+		return fmt.Errorf("wrong number of arguments for %s", l.cmd)
+	}
+
+	switch l.cmd {
+	case "wait frames":
+		_, err := strconv.ParseUint(l.args[0], 10, 64)
+		return err
+	case "wait text":
+		if len(l.args) == 2 {
+			_, err := strconv.ParseUint(l.args[1], 10, 64)
+			return err
+		}
+	case "insert", "eject":
+		if l.args[0] != "1" && l.args[0] != "2" {
+			return fmt.Errorf("there is no drive %s", l.args[0])
+		}
+		if l.cmd == "insert" && len(l.args) != 2 {
+			return fmt.Errorf("insert needs a drive and a disk image")
+		}
+	case "mouse":
+		switch {
+		case l.args[0] == "move" && len(l.args) == 3:
+			for _, n := range l.args[1:] {
+				if _, err := strconv.Atoi(n); err != nil {
+					return err
+				}
+			}
+		case (l.args[0] == "down" || l.args[0] == "up") && len(l.args) == 1:
+		default:
+			return fmt.Errorf("mouse takes move DX DY, down or up")
+		}
+	case "tape":
+		if _, ok := tapeControls[l.args[0]]; !ok {
+			return fmt.Errorf("tape takes play, stop or rewind")
+		}
+	case "key":
+		for _, name := range strings.Split(l.args[0], "+") {
+			if _, ok := scriptKeys[strings.ToLower(name)]; !ok && len(name) != 1 {
+				return fmt.Errorf("unknown key %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+//done has the script run every line
+func (s *script) done() bool {
+	return s.next >= len(s.lines)
+}
+
+//step runs the script up to the next line that has to wait for the emulator, call it before
+//every frame. It returns true when the script quits
+func (s *script) step(m *machine) (bool, error) {
+	for !s.done() {
+		line := s.lines[s.next]
+		finished, err := s.run(m, line)
+		if err != nil {
+			return false, fmt.Errorf("%s:%d: %v", s.name, line.num, err)
+		}
+		if !finished {
+			s.frame++
+			return false, nil
+		}
+		if line.cmd == "quit" {
+			return true, nil
+		}
+		s.next++
+		s.frame = 0
+	}
+	return false, nil
+}
+
+//run does one line, returning false while it is still waiting
+func (s *script) run(m *machine, line scriptLine) (bool, error) {
+	switch line.cmd {
+	case "wait text":
+		if strings.Contains(strings.Join(m.mem.TextScreen(), "\n"), line.args[0]) {
+			return true, nil
+		}
+		if len(line.args) == 2 {
+			limit, _ := strconv.ParseUint(line.args[1], 10, 64)
+			if s.frame >= limit {
+				return false, fmt.Errorf("%q didn't appear in %d frames", line.args[0], limit)
+			}
+		}
+		return false, nil
+	case "wait frames":
+		n, _ := strconv.ParseUint(line.args[0], 10, 64)
+		return s.frame >= n, nil
+	case "wait paste":
+		return !m.kbd.Pasting(), nil
+	case "type":
+		m.paste(line.args[0])
+	case "key":
+		return s.pressKeys(m, line.args[0]), nil
+	case "insert":
+		drive, _ := strconv.Atoi(line.args[0])
+		return true, m.insert(drive, line.args[1])
+	case "eject":
+		drive, _ := strconv.Atoi(line.args[0])
+		m.eject(drive)
+	case "mouse":
+		if line.args[0] == "move" {
+			dx, _ := strconv.Atoi(line.args[1])
+			dy, _ := strconv.Atoi(line.args[2])
+			m.MoveMouse(dx, dy)
+		} else {
+			m.SetMouseButton(line.args[0] == "down")
+		}
+	case "tape":
+		if m.mem.Cassette.Tape() == nil {
+			return false, fmt.Errorf("there is no tape in the cassette player")
+		}
+		m.tape(tapeControls[line.args[0]])
+	case "save":
+		return true, m.saveState(line.args[0])
+	case "screenshot":
+		filename := m.captureName(".png")
+		if len(line.args) == 1 {
+			filename = line.args[0]
+		}
+		return true, m.vid.SaveScreenshot(filename, 0, 0)
+	}
+	return true, nil
+}
+
+//pressKeys presses a key combination on the first frame and lets go of it keyHoldFrames later
+func (s *script) pressKeys(m *machine, combo string) bool {
+	if s.frame == 0 {
+		for _, name := range strings.Split(combo, "+") {
+			if key, ok := scriptKeys[strings.ToLower(name)]; ok {
+				m.SysKeyDn(key)
+				s.held = append(s.held, key)
+				continue
+			}
+			c := int(name[0])
+			for _, key := range s.held {
+				if key == appleii.KeyControl {
+					c &= 0x1F
+				}
+			}
+			m.KeyType(c)
+		}
+		return false
+	}
+	if s.frame < keyHoldFrames {
+		return false
+	}
+	for _, key := range s.held {
+		m.SysKeyUp(key)
+	}
+	s.held = nil
+	return true
+}
@@ -0,0 +1,200 @@
+package sys
+
+/* serial.go -- Connects the serial card to a pseudo terminal, a TCP socket or a file
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+//serialBuffer how many bytes can wait in each direction, more are dropped
+const serialBuffer = 4096
+
+//serialLine an appleii.SerialLine to a stream, reading and writing happen on their own goroutines so
+//the emulator never waits on the host. A listening line takes a new connection whenever the last one drops.
+//What comes in waits on in until the machine polls it between frames and delivers it as a movie input
+type serialLine struct {
+	in       chan byte
+	rx       []byte //Delivered bytes the card hasn't taken yet
+	out      chan byte
+	mu       sync.Mutex
+	conn     io.WriteCloser //Current connection, nil when nobody's there
+	listener net.Listener
+}
+
+//openSerial connect to spec, one of pty, listen:ADDR, connect:ADDR or file:NAME
+func openSerial(spec string) (*serialLine, error) {
+	l := serialLine{in: make(chan byte, serialBuffer), out: make(chan byte, serialBuffer)}
+	kind, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "pty":
+		pty, name, err := openPty()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Serial port is %s", name)
+		l.attach(pty)
+	case "listen":
+		listener, err := net.Listen("tcp", arg)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Serial port listening on %s", listener.Addr())
+		l.listener = listener
+		go l.accept()
+	case "connect":
+		conn, err := net.Dial("tcp", arg)
+		if err != nil {
+			return nil, err
+		}
+		l.attach(conn)
+	case "file":
+		file, err := os.Create(arg)
+		if err != nil {
+			return nil, err
+		}
+		//A capture file has nothing to read, so it's only written to
+		l.use(file)
+	default:
+		return nil, fmt.Errorf("Unknown serial connection %q", spec)
+	}
+	go l.write()
+	return &l, nil
+}
+
+//Send queue b to go out
+func (l *serialLine) Send(b uint8) {
+	select {
+	case l.out <- b:
+	default:
+	}
+}
+
+//Receive the next byte that was delivered
+func (l *serialLine) Receive() (uint8, bool) {
+	if len(l.rx) == 0 {
+		return 0, false
+	}
+	b := l.rx[0]
+	l.rx = l.rx[1:]
+	return b, true
+}
+
+//poll take everything that has come in since the last poll
+func (l *serialLine) poll() []byte {
+	var data []byte
+	for {
+		select {
+		case b := <-l.in:
+			data = append(data, b)
+		default:
+			return data
+		}
+	}
+}
+
+//deliver hand data to the card, what doesn't fit in the buffer is dropped
+func (l *serialLine) deliver(data []byte) {
+	if room := serialBuffer - len(l.rx); len(data) > room {
+		data = data[:room]
+	}
+	l.rx = append(l.rx, data...)
+}
+
+//Close hang up
+func (l *serialLine) Close() {
+	if l.listener != nil {
+		l.listener.Close()
+	}
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.mu.Unlock()
+}
+
+//accept take connections until the listener is closed
+func (l *serialLine) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		log.Printf("Serial port connected to %s", conn.RemoteAddr())
+		l.attach(conn)
+	}
+}
+
+//attach make conn the connection and start reading it
+func (l *serialLine) attach(conn io.ReadWriteCloser) {
+	l.use(conn)
+	go l.read(conn)
+}
+
+//use make conn the connection, replacing any connection there was
+func (l *serialLine) use(conn io.WriteCloser) {
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.conn = conn
+	l.mu.Unlock()
+}
+
+//read pass what comes in on conn to the card until the connection drops
+func (l *serialLine) read(conn io.ReadWriteCloser) {
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		for _, b := range buf[:n] {
+			select {
+			case l.in <- b:
+			default:
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	l.mu.Lock()
+	if l.conn == conn {
+		l.conn = nil
+	}
+	l.mu.Unlock()
+}
+
+//write send what the card sends to whoever is connected
+func (l *serialLine) write() {
+	for b := range l.out {
+		l.mu.Lock()
+		conn := l.conn
+		l.mu.Unlock()
+		if conn != nil {
+			conn.Write([]byte{b})
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package sys
+
+/* serial_test.go -- Serial lines over TCP
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+//receive wait for n bytes to come in on l, delivering them the way the machine does between frames
+func receive(t *testing.T, l *serialLine, n int) []byte {
+	t.Helper()
+	var got []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for len(got) < n && time.Now().Before(deadline) {
+		l.deliver(l.poll())
+		if b, ok := l.Receive(); ok {
+			got = append(got, b)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return got
+}
+
+func TestSerialLoopback(t *testing.T) {
+	server, err := openSerial("listen:127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := openSerial("connect:" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//The listener takes the connection on its own goroutine
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		server.mu.Lock()
+		conn := server.conn
+		server.mu.Unlock()
+		if conn != nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the listener never took the connection")
+		}
+	}
+
+	for _, b := range []byte("PR#2") {
+		client.Send(b)
+	}
+	if got := string(receive(t, server, 4)); got != "PR#2" {
+		t.Errorf("the listener received %q, want %q", got, "PR#2")
+	}
+	for _, b := range []byte("OK\r") {
+		server.Send(b)
+	}
+	if got := string(receive(t, client, 3)); got != "OK\r" {
+		t.Errorf("the connection received %q, want %q", got, "OK\r")
+	}
+}
+
+func TestSerialDropsWhenFull(t *testing.T) {
+	//Nobody takes the bytes off the line, the reader drops what doesn't fit and carries on
+	l := serialLine{in: make(chan byte, 2)}
+	host, conn := net.Pipe()
+	go func() {
+		host.Write([]byte("HELLO"))
+		host.Close()
+	}()
+	done := make(chan struct{})
+	go func() {
+		l.read(conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reader blocked on a full buffer")
+	}
+	if got := string(receive(t, &l, 2)); got != "HE" {
+		t.Errorf("received %q, want %q", got, "HE")
+	}
+}
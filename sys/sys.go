@@ -18,6 +18,49 @@ package sys
    Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 */
 
+import (
+	"time"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+	"github.com/cupcakus/appleII-piz/video"
+)
+
+//Config holds the options for the runners
+type Config struct {
+	Scale   video.ScaleMode       //How the display is scaled to the screen
+	Paddles [4]appleii.PaddleAxis //Calibration of the joystick axes
+	Slots   [8]string             //Card in each slot by name (see cardTypes), slot 0 is unused
+	Audio   string                //WAV file to record the sound to
+	//TapeIn the WAV file in the cassette player, TapeOut the WAV file the cassette output is recorded to
+	TapeIn, TapeOut string
+	//AuxBanks the number of 64k aux memory banks, more than 1 is a RamWorks style card
+	AuxBanks int
+	//HardDisks the volumes on the hard disk card in each slot, unit 1 first
+	HardDisks [8][]string
+	//Serial what the serial card in each slot is connected to (see openSerial), SerialSwitches how they're set
+	Serial         [8]string
+	SerialSwitches appleii.SSCSwitches
+	//Printers where the printer card in each slot prints to (see openPrinter)
+	Printers [8]string
+	//ClockOffset how far the clock cards are set from the host's time
+	ClockOffset time.Duration
+	//Pasted text
+	Paste      string //File to type into the emulator at startup
+	PasteDelay uint64 //Least number of cycles between pasted characters
+	Uppercase  bool   //Paste letters as capitals
+	Script     string //Script file that drives the emulator
+	//Save states and movies
+	LoadState string //Save state to start from
+	Movie     string //File to record every input to
+	Replay    string //Movie to play back
+	//Headless only
+	Frames  uint64   //Number of frames to run, 0 runs forever
+	Dumps   []uint64 //Frames to dump to PNG
+	DumpDir string   //Where the dumped frames go
+	Record  string   //File to record the whole run to (.gif, .y4m or .rgb)
+	Bench   bool     //Report how long each part of a frame takes
+}
+
 //Runner specifies a particular runtime for a physical platform
 //it is responsible for running the entire emulator
 type Runner interface {
@@ -26,20 +26,44 @@ import (
 )
 
 //LinuxRunner pi zero specific emulator runtime
-type LinuxRunner struct{}
+type LinuxRunner struct {
+	cfg Config
+}
 
-func getAppleKey(key int) appleii.SysKey {
-	switch key {
-	case 0:
-		return appleii.KeyReset
+func getAppleKey(code uint16) (appleii.SysKey, bool) {
+	switch code {
+	case keyHome:
+		return appleii.KeyReset, true
+	case keyLeftShift, keyRightShift:
+		return appleii.KeyShift, true
+	case keyLeftCtrl, keyRightCtrl:
+		return appleii.KeyControl, true
+	case keyLeftAlt, keyLeftMeta:
+		return appleii.KeyOpenApple, true
+	case keyEnd, keyRightAlt, keyRightMeta:
+		return appleii.KeyFilledApple, true
+	case keyLeft, keyBackspace:
+		return appleii.KeyLeft, true
+	case keyRight:
+		return appleii.KeyRight, true
+	case keyUp:
+		return appleii.KeyUp, true
+	case keyDown:
+		return appleii.KeyDown, true
+	case keyEsc:
+		return appleii.KeyEscape, true
+	case keyEnter:
+		return appleii.KeyReturn, true
+	case keyDelete:
+		return appleii.KeyDelete, true
 	default:
-		return 0
+		return 0, false
 	}
 }
 
 //NewRunner returns a new LinuxRunner
-func NewRunner() *LinuxRunner {
-	runner := LinuxRunner{}
+func NewRunner(cfg Config) *LinuxRunner {
+	runner := LinuxRunner{cfg: cfg}
 	return &runner
 }
 
@@ -49,39 +73,46 @@ func (r *LinuxRunner) Init() {
 
 //Run the runtime
 func (r *LinuxRunner) Run() string {
-	bus := appleii.NewBus()
-	cpu := appleii.NewCPU(bus)
-	mem := appleii.NewMem(bus, cpu)
-	appleii.NewDsk(bus)
-	bus.Add(mem, 0, 0xFFFF)
-	//kbd := appleii.NewKbd(mem, cpu)
-	ren := video.NewRenderer()
-	vid := video.NewVideo(bus, ren)
+	m := newMachine(video.NewRenderer(r.cfg.Scale), r.cfg)
 
-	cpu.Reset()
+	events := make(chan inputEvent, 64)
+	go watchInputDevices(events)
+	keyboard := evdevKeyboard{kbd: m, hotkey: func(code uint16, shift bool) {
+		switch {
+		case code == keyPageDown && shift:
+			//There's no clipboard on the console, so paste the -paste file again
+			if r.cfg.Paste != "" {
+				m.pasteFile(r.cfg.Paste)
+			}
+		case code == keyPageDown:
+			m.vid.ToggleColorMode()
+		case code == keyPageUp && shift:
+			m.toggleRecording()
+		case code == keyPageUp:
+			m.screenshot()
+		}
+	}}
+	joystick := evdevJoystick{paddles: m}
+	mouse := evdevMouse{mouse: m}
 
 	for {
-		i := 0
 		start := time.Now()
-		for i <= 17030 {
-			i += cpu.Tick()
-			if i <= 4550 {
-				mem.VBLANK = true
-			} else {
-				mem.VBLANK = false
-			}
-		}
-		if !bus.GetFastMode() {
-			vid.RenderFrame(mem.GetGPUMemory())
+		drainEvents(events, func(ev inputEvent) {
+			keyboard.handle(ev)
+			joystick.handle(ev)
+			mouse.handle(ev)
+		})
+		if m.startFrame() {
+			m.close()
+			return ""
 		}
+		m.runFrame()
 		end := time.Now()
 		sleepTime := 16 - end.Sub(start).Milliseconds()
 		if sleepTime > 0 {
-			if !bus.GetFastMode() {
-				//time.Sleep(time.Duration(sleepTime) * time.Millisecond)
+			if !m.bus.GetFastMode() {
+				time.Sleep(time.Duration(sleepTime) * time.Millisecond)
 			}
 		}
 	}
-
-	return ""
 }
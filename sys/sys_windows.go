@@ -19,6 +19,9 @@ package sys
 */
 
 import (
+	"image"
+	"log"
+	"os"
 	"time"
 
 	"github.com/cupcakus/appleII-piz/appleii"
@@ -29,107 +32,171 @@ import (
 )
 
 //WindowsRunner windows specific emulator runtime
-type WindowsRunner struct{}
+type WindowsRunner struct {
+	cfg Config
+}
 
-func renderLoop(env gui.Env, vid *video.System, cpu *appleii.CPU, mem *appleii.Mem, bus *appleii.Bus) {
+//renderLoop runs the machine, inputs are applied between frames so movies replay exactly
+func renderLoop(env gui.Env, m *machine, inputs <-chan func()) {
 	for {
 		//	fmt.Println("?")
-		i := 0
 		start := time.Now()
-		for i <= 17030 {
-			i += cpu.Tick()
-			if i <= 4550 {
-				mem.VBLANK = true
-			} else {
-				mem.VBLANK = false
+	drain:
+		for {
+			select {
+			case in := <-inputs:
+				in()
+			default:
+				break drain
 			}
 		}
-		if !bus.GetFastMode() {
-			vid.RenderFrame(mem.GetGPUMemory())
+		if m.startFrame() {
+			m.close()
+			os.Exit(0)
+		}
+		m.runFrame()
+		if !m.bus.GetFastMode() {
 			env.Draw() <- video.WindowsDraw
 		}
 		end := time.Now()
 		sleepTime := 16 - end.Sub(start).Milliseconds()
 		if sleepTime > 0 {
-			if !bus.GetFastMode() {
+			if !m.bus.GetFastMode() {
 				time.Sleep(time.Duration(sleepTime) * time.Millisecond)
 			}
 		}
 	}
 }
 
-func getAppleKey(key win.Key) appleii.SysKey {
+func getAppleKey(key win.Key) (appleii.SysKey, bool) {
 	switch key {
 	case win.KeyHome:
-		return appleii.KeyReset
+		return appleii.KeyReset, true
 	case win.KeyShift:
-		return appleii.KeyShift
+		return appleii.KeyShift, true
 	case win.KeyCtrl:
-		return appleii.KeyControl
+		return appleii.KeyControl, true
 	case win.KeyAlt:
-		return appleii.KeyOpenApple
+		return appleii.KeyOpenApple, true
 	case win.KeyEnd:
-		return appleii.KeyFilledApple
+		return appleii.KeyFilledApple, true
 	case win.KeyLeft, win.KeyBackspace:
-		return appleii.KeyLeft
+		return appleii.KeyLeft, true
 	case win.KeyRight:
-		return appleii.KeyRight
+		return appleii.KeyRight, true
 	case win.KeyUp:
-		return appleii.KeyUp
+		return appleii.KeyUp, true
 	case win.KeyDown:
-		return appleii.KeyDown
+		return appleii.KeyDown, true
 	case win.KeyEscape:
-		return appleii.KeyEscape
+		return appleii.KeyEscape, true
 	case win.KeyEnter:
-		return appleii.KeyReturn
+		return appleii.KeyReturn, true
 	case win.KeyDelete:
-		return appleii.KeyDelete
+		return appleii.KeyDelete, true
+	default:
+		return 0, false
+	}
+}
 
+func getPaddleButton(button win.Button) int {
+	switch button {
+	case win.ButtonRight:
+		return 1
+	case win.ButtonMiddle:
+		return 2
 	default:
 		return 0
 	}
 }
 
-func run() {
+func (r *WindowsRunner) run() {
 	w, err := win.New(win.Title("Apple //e Emulator for Pi-Zero -- Windows Version For DEBUG ONLY"), win.Size(1024, 768))
 	if err != nil {
 		panic(err)
 	}
-	bus := appleii.NewBus()
-	cpu := appleii.NewCPU(bus)
-	mem := appleii.NewMem(bus, cpu)
-	appleii.NewDsk(bus)
-	bus.Add(mem, 0, 0xFFFF)
-	kbd := appleii.NewKbd(mem, cpu)
-	ren := video.NewRenderer(1024, 768)
-	vid := video.NewVideo(bus, ren)
-
-	cpu.Reset()
+	m := newMachine(video.NewRenderer(1024, 768, r.cfg.Scale), r.cfg)
 
 	mux, env := gui.NewMux(w)
-	go renderLoop(mux.MakeEnv(), vid, cpu, mem, bus)
+	inputs := make(chan func(), 256)
+	go renderLoop(mux.MakeEnv(), m, inputs)
+
+	shift := false
+	var mouse image.Point //Last pointer position, the mouse card moves by the difference
 
 	for event := range env.Events() {
-		switch event.(type) {
+		switch event := event.(type) {
 		case win.WiClose:
-			close(env.Draw())
+			//The render loop is still running frames, it shuts the machine down between them
+			inputs <- func() {
+				m.close()
+				os.Exit(0)
+			}
+		case win.MoMove:
+			//The mouse is the joystick, the window spans the whole range, and the mouse card's mouse
+			d := event.Point.Sub(mouse)
+			mouse = event.Point
+			inputs <- func() {
+				m.SetAxis(0, event.X*256/1024)
+				m.SetAxis(1, event.Y*256/768)
+				m.MoveMouse(d.X, d.Y)
+			}
+		case win.MoDown:
+			inputs <- func() {
+				m.SetButton(getPaddleButton(event.Button), true)
+				if event.Button == win.ButtonLeft {
+					m.SetMouseButton(true)
+				}
+			}
+		case win.MoUp:
+			inputs <- func() {
+				m.SetButton(getPaddleButton(event.Button), false)
+				if event.Button == win.ButtonLeft {
+					m.SetMouseButton(false)
+				}
+			}
 		case win.KbType:
-			kbd.KeyType(int(event.(win.KbType).Rune))
+			inputs <- func() { m.KeyType(int(event.Rune)) }
 		case win.KbDown:
-			if event.(win.KbDown).Key == win.KeyPageDown {
-				vid.ToggleColorMode()
-			} else {
-				kbd.SysKeyDn(getAppleKey(event.(win.KbDown).Key))
+			switch event.Key {
+			case win.KeyPageDown:
+				if shift {
+					text, err := readClipboard()
+					if err != nil {
+						log.Printf("Paste failed: %v", err)
+					}
+					inputs <- func() { m.paste(text) }
+				} else {
+					inputs <- m.vid.ToggleColorMode
+				}
+			case win.KeyPageUp:
+				if shift {
+					inputs <- m.toggleRecording
+				} else {
+					inputs <- m.screenshot
+				}
+			default:
+				if event.Key == win.KeyShift {
+					shift = true
+				}
+				if key, ok := getAppleKey(event.Key); ok {
+					inputs <- func() { m.SysKeyDn(key) }
+				}
 			}
 		case win.KbUp:
-			kbd.SysKeyUp(getAppleKey(event.(win.KbUp).Key))
+			if event.Key == win.KeyShift {
+				shift = false
+			}
+			if key, ok := getAppleKey(event.Key); ok {
+				inputs <- func() { m.SysKeyUp(key) }
+			}
 		}
 	}
 }
 
 //NewRunner returns a new WindowsRunner
-func NewRunner() *WindowsRunner {
-	runner := WindowsRunner{}
+func NewRunner(cfg Config) *WindowsRunner {
+	runner := WindowsRunner{cfg: cfg}
 	return &runner
 }
 
@@ -139,6 +206,6 @@ func (r *WindowsRunner) Init() {
 
 //Run the runtime
 func (r *WindowsRunner) Run() string {
-	mainthread.Run(run)
+	mainthread.Run(r.run)
 	return ""
 }
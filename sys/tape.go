@@ -0,0 +1,132 @@
+package sys
+
+/* tape.go -- Cassette tapes as WAV files
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"log"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+const (
+	//tapeAmplitude the level of the recorded square wave
+	tapeAmplitude = 16384
+	//tapeQuiet the recording goes quiet this many cycles after the output last flipped (10ms)
+	tapeQuiet = appleii.ClockHz / 100
+	//tapeMaxGap the longest quiet kept between two recordings, the rest is left out (2s)
+	tapeMaxGap = 2 * appleii.ClockHz
+)
+
+//Tape controls
+const (
+	tapePlay = iota
+	tapeStop
+	tapeRewind
+)
+
+//tapeControls the tape controls by name
+var tapeControls = map[string]int{"play": tapePlay, "stop": tapeStop, "rewind": tapeRewind}
+
+//loadTape read a WAV file into a tape
+func loadTape(filename string) (*appleii.Tape, error) {
+	samples, rate, err := readWav(filename)
+	if err != nil {
+		return nil, err
+	}
+	return appleii.NewTape(samples, rate), nil
+}
+
+//tapeRecorder writes the cassette output to a WAV file as a square wave. It starts at the first
+//flip of the output and leaves out long quiet stretches between saves
+type tapeRecorder struct {
+	wav     *wavWriter
+	started bool
+	base    uint64 //Cycle of the first sample, moved on by the quiet that's left out
+	samples uint64 //Samples written so far
+	last    uint64 //Cycle the output last flipped at
+	high    bool
+}
+
+//newTapeRecorder start recording to filename
+func newTapeRecorder(filename string) (*tapeRecorder, error) {
+	wav, err := newWavWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &tapeRecorder{wav: wav}, nil
+}
+
+//Output write everything up to cycle, the output changes to high from there
+func (t *tapeRecorder) Output(cycle uint64, high bool) {
+	if t.wav == nil {
+		return
+	}
+	if !t.started {
+		t.started, t.base, t.last = true, cycle, cycle
+	}
+	end := cycle
+	if quiet := t.last + tapeQuiet + tapeMaxGap; end > quiet {
+		//Most of a long quiet is left out
+		end = quiet
+	}
+	if err := t.fill(end); err != nil {
+		log.Printf("Tape recording failed: %v", err)
+		t.wav.Close()
+		t.wav = nil
+		return
+	}
+	t.base += cycle - end
+	t.last, t.high = cycle, high
+}
+
+//fill write the samples up to cycle
+func (t *tapeRecorder) fill(cycle uint64) error {
+	end := (cycle - t.base) * appleii.SampleRate / appleii.ClockHz
+	if end <= t.samples {
+		return nil
+	}
+	buf := make([]int16, 0, 2*(end-t.samples))
+	for ; t.samples < end; t.samples++ {
+		var v int16
+		if at := t.base + t.samples*appleii.ClockHz/appleii.SampleRate; at < t.last+tapeQuiet {
+			v = -tapeAmplitude
+			if t.high {
+				v = tapeAmplitude
+			}
+		}
+		buf = append(buf, v, v)
+	}
+	return t.wav.Write(buf)
+}
+
+//Close finish the recording with the output going quiet after the last flip
+func (t *tapeRecorder) Close() error {
+	if t.wav == nil {
+		return nil
+	}
+	var err error
+	if t.started {
+		err = t.fill(t.last + tapeQuiet)
+	}
+	if cerr := t.wav.Close(); err == nil {
+		err = cerr
+	}
+	t.wav = nil
+	return err
+}
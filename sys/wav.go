@@ -0,0 +1,148 @@
+package sys
+
+/* wav.go -- Writes the emulator's sound to WAV files and reads tapes from them
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//wavHeaderSize the RIFF, fmt and data chunk headers
+const wavHeaderSize = 44
+
+//wavWriter writes 16 bit stereo samples at appleii.SampleRate, the sizes in the header are filled in by Close
+type wavWriter struct {
+	file  *os.File
+	out   *bufio.Writer
+	bytes uint32 //Sample data written so far
+}
+
+//newWavWriter create filename and write a header for a file with no samples yet
+func newWavWriter(filename string) (*wavWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := wavWriter{file: file, out: bufio.NewWriter(file)}
+	if err := w.header(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &w, nil
+}
+
+//header the WAV header for the samples written so far
+func (w *wavWriter) header() error {
+	const channels, bits = 2, 16
+	h := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'}, uint32(wavHeaderSize - 8 + w.bytes), [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16), uint16(1), uint16(channels), uint32(appleii.SampleRate),
+		uint32(appleii.SampleRate * channels * bits / 8), uint16(channels * bits / 8), uint16(bits),
+		[4]byte{'d', 'a', 't', 'a'}, w.bytes,
+	}
+	for _, v := range h {
+		if err := binary.Write(w.out, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Write add samples, left then right
+func (w *wavWriter) Write(samples []int16) error {
+	w.bytes += uint32(len(samples) * 2)
+	return binary.Write(w.out, binary.LittleEndian, samples)
+}
+
+//Close fill in the header and close the file
+func (w *wavWriter) Close() error {
+	err := w.out.Flush()
+	if err == nil {
+		_, err = w.file.Seek(0, 0)
+	}
+	if err == nil {
+		err = w.header()
+	}
+	if err == nil {
+		err = w.out.Flush()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//readWav the samples of a PCM WAV file with its channels mixed together, and how many there are a second.
+//Only 8 and 16 bit samples are understood
+func readWav(filename string) ([]int, int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("%s isn't a WAV file", filename)
+	}
+	var channels, rate, bits int
+	for pos := 12; pos+8 <= len(data); {
+		//The size stays unsigned, streaming writers leave it at $FFFFFFFF which is negative as an int on
+		//32 bit machines
+		size := binary.LittleEndian.Uint32(data[pos+4:])
+		chunk := data[pos+8:]
+		if int64(size) < int64(len(chunk)) {
+			chunk = chunk[:size]
+		}
+		switch string(data[pos : pos+4]) {
+		case "fmt ":
+			if len(chunk) < 16 || binary.LittleEndian.Uint16(chunk) != 1 {
+				return nil, 0, fmt.Errorf("%s isn't PCM", filename)
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			rate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+		case "data":
+			if channels == 0 || rate == 0 {
+				return nil, 0, fmt.Errorf("%s has samples before its format", filename)
+			}
+			if bits != 8 && bits != 16 {
+				return nil, 0, fmt.Errorf("%s has %d bit samples, only 8 and 16 bit WAV files can be read", filename, bits)
+			}
+			width := channels * bits / 8
+			samples := make([]int, len(chunk)/width)
+			for i := range samples {
+				frame := chunk[i*width:]
+				for ch := 0; ch < channels; ch++ {
+					if bits == 8 {
+						samples[i] += (int(frame[ch]) - 128) << 8
+					} else {
+						samples[i] += int(int16(binary.LittleEndian.Uint16(frame[ch*2:])))
+					}
+				}
+			}
+			return samples, rate, nil
+		}
+		//Chunks are padded to an even length
+		pos += 8 + len(chunk) + int(size&1)
+	}
+	return nil, 0, fmt.Errorf("%s has no samples", filename)
+}
@@ -0,0 +1,94 @@
+package sys
+
+/* wav_test.go -- WAV files written and read back
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cupcakus/appleII-piz/appleii"
+)
+
+//tempWav a file name in a new directory, and a function to remove it again
+func tempWav(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test.wav"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWavRoundTrip(t *testing.T) {
+	name, remove := tempWav(t)
+	defer remove()
+	w, err := newWavWriter(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]int16{100, 200, -300, -400, 32767, 0})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	samples, rate, err := readWav(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The channels are added together
+	if want := []int{300, -700, 32767}; !reflect.DeepEqual(samples, want) || rate != appleii.SampleRate {
+		t.Errorf("read %v at %dHz, want %v at %dHz", samples, rate, want, appleii.SampleRate)
+	}
+}
+
+func TestWavStreamingSizes(t *testing.T) {
+	//A stream written without going back to fill in the sizes, with an odd sized chunk padded out in front
+	data := []byte("RIFF\xFF\xFF\xFF\xFFWAVE" +
+		"LIST\x03\x00\x00\x00abc\x00" +
+		"fmt \x10\x00\x00\x00\x01\x00\x01\x00\x40\x1F\x00\x00\x40\x1F\x00\x00\x01\x00\x08\x00" +
+		"data\xFF\xFF\xFF\xFF\x80\xFF\x00")
+	name, remove := tempWav(t)
+	defer remove()
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, rate, err := readWav(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 127 << 8, -128 << 8}; !reflect.DeepEqual(samples, want) || rate != 8000 {
+		t.Errorf("read %v at %dHz, want %v at 8000Hz", samples, rate, want)
+	}
+}
+
+func TestWavTruncatedChunk(t *testing.T) {
+	//A chunk that claims to run past the end of the file stops the search instead of wrapping around
+	name, remove := tempWav(t)
+	defer remove()
+	if err := ioutil.WriteFile(name, []byte("RIFF\x00\x00\x00\x00WAVEjunk\xFE\xFF\xFF\xFFxx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readWav(name); err == nil {
+		t.Error("a file with no samples was read")
+	}
+}
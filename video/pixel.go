@@ -0,0 +1,111 @@
+package video
+
+/* pixel.go -- Output device pixel formats
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+//Bitfield is where one color channel lives inside a pixel
+type Bitfield struct {
+	Offset uint //Bit offset from the least significant bit
+	Length uint //Number of bits, 0 if the pixel doesn't have this channel
+}
+
+//PixelFormat describes how a color is packed into a little endian output pixel,
+//this mirrors the fb_var_screeninfo of the Linux framebuffer
+type PixelFormat struct {
+	Bits  int //Bits per pixel: 16, 24 or 32
+	Red   Bitfield
+	Green Bitfield
+	Blue  Bitfield
+	Alpha Bitfield
+}
+
+//Common Pixel Formats
+var (
+	//FormatRGBA32 matches image.RGBA
+	FormatRGBA32 = PixelFormat{Bits: 32, Red: Bitfield{0, 8}, Green: Bitfield{8, 8}, Blue: Bitfield{16, 8}, Alpha: Bitfield{24, 8}}
+	//FormatBGRA32 is what most 32 bit framebuffers use
+	FormatBGRA32 = PixelFormat{Bits: 32, Red: Bitfield{16, 8}, Green: Bitfield{8, 8}, Blue: Bitfield{0, 8}, Alpha: Bitfield{24, 8}}
+	//FormatRGB565 is used by SPI TFT (fbtft) displays
+	FormatRGB565 = PixelFormat{Bits: 16, Red: Bitfield{11, 5}, Green: Bitfield{5, 6}, Blue: Bitfield{0, 5}}
+)
+
+//Bytes the size of a pixel in bytes
+func (f PixelFormat) Bytes() int {
+	return (f.Bits + 7) / 8
+}
+
+func (b Bitfield) pack(v uint8) uint32 {
+	if b.Length == 0 {
+		return 0
+	}
+	return uint32(v>>(8-b.Length)) << b.Offset
+}
+
+func (b Bitfield) unpack(p uint32) uint8 {
+	if b.Length == 0 {
+		return 0
+	}
+	v := uint8((p>>b.Offset)&(1<<b.Length-1)) << (8 - b.Length)
+	//Repeat the top bits so full intensity stays full intensity
+	return v | v>>b.Length
+}
+
+//byteAligned is the channel a whole byte? Returns its byte index in the pixel
+func (b Bitfield) byteAligned() (int, bool) {
+	return int(b.Offset / 8), b.Length == 8 && b.Offset%8 == 0
+}
+
+//Pack an opaque color into a pixel value
+func (f PixelFormat) Pack(r, g, b uint8) uint32 {
+	return f.Red.pack(r) | f.Green.pack(g) | f.Blue.pack(b) | f.Alpha.pack(255)
+}
+
+//Unpack a pixel value back into a color
+func (f PixelFormat) Unpack(p uint32) (uint8, uint8, uint8) {
+	return f.Red.unpack(p), f.Green.unpack(p), f.Blue.unpack(p)
+}
+
+//Put writes a pixel value to the start of buf
+func (f PixelFormat) Put(buf []byte, p uint32) {
+	switch f.Bits {
+	case 16:
+		buf[0] = uint8(p)
+		buf[1] = uint8(p >> 8)
+	case 24:
+		buf[0] = uint8(p)
+		buf[1] = uint8(p >> 8)
+		buf[2] = uint8(p >> 16)
+	default:
+		buf[0] = uint8(p)
+		buf[1] = uint8(p >> 8)
+		buf[2] = uint8(p >> 16)
+		buf[3] = uint8(p >> 24)
+	}
+}
+
+//Get reads a pixel value from the start of buf
+func (f PixelFormat) Get(buf []byte) uint32 {
+	switch f.Bits {
+	case 16:
+		return uint32(buf[0]) | uint32(buf[1])<<8
+	case 24:
+		return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+	default:
+		return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+	}
+}
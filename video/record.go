@@ -0,0 +1,362 @@
+package video
+
+/* record.go -- Record emulator sessions to animated GIF or raw video streams
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Recorder receives every frame the video system renders while recording
+type Recorder interface {
+	WriteFrame(img *image.RGBA) error
+	Close() error
+}
+
+//NewRecorder creates a file and picks the recorder from its extension (.gif, .y4m or .rgb)
+func NewRecorder(filename string) (Recorder, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext != ".gif" && ext != ".y4m" && ext != ".rgb" {
+		return nil, fmt.Errorf("can't record to %s files, use .gif, .y4m or .rgb", ext)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".gif":
+		return NewGIFRecorder(file), nil
+	case ".y4m":
+		return NewY4MRecorder(file), nil
+	default:
+		return NewRGBRecorder(file), nil
+	}
+}
+
+//StartRecording sends every frame rendered from now on to r, any recording in progress is stopped
+func (s *System) StartRecording(r Recorder) {
+	s.StopRecording()
+	s.lock.Lock()
+	s.rec = r
+	s.lock.Unlock()
+}
+
+//StopRecording finishes the recording in progress
+func (s *System) StopRecording() error {
+	s.lock.Lock()
+	r := s.rec
+	s.rec = nil
+	s.lock.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	return r.Close()
+}
+
+//Recording is a recording in progress?
+func (s *System) Recording() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rec != nil
+}
+
+func (s *System) record(img *image.RGBA) {
+	s.lock.Lock()
+	r := s.rec
+	s.lock.Unlock()
+
+	if r == nil {
+		return
+	}
+	if err := r.WriteFrame(img); err != nil {
+		log.Printf("Recording stopped: %v", err)
+		s.StopRecording()
+	}
+}
+
+//GIFRecorder streams an animated GIF, only the part of the screen that changed is stored. The 16
+//Apple colors are the global palette, other colors (the monochrome color) are added as they show up
+//and frames that use them carry a local palette. The GIF runs at 20fps (every 3rd frame) since most
+//viewers won't play anything faster
+type GIFRecorder struct {
+	file    io.WriteCloser
+	w       *bufio.Writer
+	palette color.Palette
+	cache   map[color.RGBA]uint8 //Palette lookups for colors seen so far
+	prev    []uint8              //Palette indexes of the last frame written
+	cur     []uint8
+	frame   int
+	started bool
+}
+
+const (
+	gifFrameSkip   = 3
+	gifGlobalColor = 16  //Colors in the global palette
+	gifMaxColors   = 256 //Colors a GIF palette can hold, more are quantized
+)
+
+//NewGIFRecorder returns a new GIFRecorder writing to file
+func NewGIFRecorder(file io.WriteCloser) *GIFRecorder {
+	r := GIFRecorder{file: file, w: bufio.NewWriter(file), cache: make(map[color.RGBA]uint8)}
+	for _, c := range lowResColors {
+		c.A = 255
+		r.palette = append(r.palette, c)
+	}
+	return &r
+}
+
+func (r *GIFRecorder) writeHeader(width, height int) {
+	r.w.WriteString("GIF89a")
+	binary.Write(r.w, binary.LittleEndian, [2]uint16{uint16(width), uint16(height)})
+	//Global color table of 16 entries, 8 bit color resolution
+	r.w.Write([]byte{0xF3, 0, 0})
+	r.writePalette(r.palette[:gifGlobalColor], 4)
+	//Loop forever
+	r.w.Write([]byte{0x21, 0xFF, 0x0B})
+	r.w.WriteString("NETSCAPE2.0")
+	r.w.Write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+}
+
+//writePalette write a color table of 1<<bits entries, the unused ones are black
+func (r *GIFRecorder) writePalette(palette color.Palette, bits uint) {
+	for i := 0; i < 1<<bits; i++ {
+		var rr, g, b uint32
+		if i < len(palette) {
+			rr, g, b, _ = palette[i].RGBA()
+		}
+		r.w.Write([]byte{uint8(rr >> 8), uint8(g >> 8), uint8(b >> 8)})
+	}
+}
+
+//index the palette index for c, a color that isn't there yet is added while there's room
+func (r *GIFRecorder) index(c color.RGBA) uint8 {
+	if index, ok := r.cache[c]; ok {
+		return index
+	}
+	index := r.palette.Index(c)
+	if r.palette[index] != c && len(r.palette) < gifMaxColors {
+		index = len(r.palette)
+		r.palette = append(r.palette, c)
+	}
+	r.cache[c] = uint8(index)
+	return uint8(index)
+}
+
+//WriteFrame implements the Recorder interface
+func (r *GIFRecorder) WriteFrame(img *image.RGBA) error {
+	r.frame++
+	if (r.frame-1)%gifFrameSkip != 0 {
+		return nil
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if !r.started {
+		r.writeHeader(width, height)
+		r.prev = make([]uint8, width*height)
+		r.cur = make([]uint8, width*height)
+		r.started = true
+	}
+
+	//Quantize and find the rectangle that changed
+	changed := image.Rectangle{}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			index := r.index(img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y))
+			r.cur[y*width+x] = index
+			if index != r.prev[y*width+x] || r.frame == 1 {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if changed.Empty() {
+		changed = image.Rect(0, 0, 1, 1)
+	}
+	r.prev, r.cur = r.cur, r.prev
+
+	//Graphic control extension, 5/100ths of a second per frame
+	r.w.Write([]byte{0x21, 0xF9, 0x04, 0x04, 5, 0, 0, 0})
+	r.w.WriteByte(0x2C)
+	binary.Write(r.w, binary.LittleEndian, [4]uint16{uint16(changed.Min.X), uint16(changed.Min.Y), uint16(changed.Dx()), uint16(changed.Dy())})
+	bits := uint(4)
+	if len(r.palette) > gifGlobalColor {
+		//A local palette with every color seen so far
+		for 1<<bits < len(r.palette) {
+			bits++
+		}
+		r.w.WriteByte(0x80 | uint8(bits-1))
+		r.writePalette(r.palette, bits)
+	} else {
+		r.w.WriteByte(0)
+	}
+
+	//Image data, LZW compressed in 255 byte sub blocks
+	r.w.WriteByte(uint8(bits))
+	blocks := &gifBlockWriter{w: r.w}
+	lw := lzw.NewWriter(blocks, lzw.LSB, int(bits))
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		if _, err := lw.Write(r.prev[y*width+changed.Min.X : y*width+changed.Max.X]); err != nil {
+			return err
+		}
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	blocks.flush()
+	r.w.WriteByte(0)
+	return blocks.err
+}
+
+//Close implements the Recorder interface
+func (r *GIFRecorder) Close() error {
+	if r.started {
+		r.w.WriteByte(0x3B)
+	}
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+//gifBlockWriter splits the LZW stream into GIF sub blocks
+type gifBlockWriter struct {
+	w   *bufio.Writer
+	buf [255]byte
+	n   int
+	err error
+}
+
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	for _, v := range p {
+		b.buf[b.n] = v
+		b.n++
+		if b.n == len(b.buf) {
+			b.flush()
+		}
+	}
+	return len(p), b.err
+}
+
+func (b *gifBlockWriter) flush() {
+	if b.n == 0 {
+		return
+	}
+	b.w.WriteByte(uint8(b.n))
+	_, err := b.w.Write(b.buf[:b.n])
+	if err != nil {
+		b.err = err
+	}
+	b.n = 0
+}
+
+//Y4MRecorder streams uncompressed YUV 4:4:4 in a YUV4MPEG2 container at 60fps,
+//most video tools (ffmpeg, mpv, etc...) read it directly
+type Y4MRecorder struct {
+	file    io.WriteCloser
+	w       *bufio.Writer
+	planes  []uint8
+	started bool
+}
+
+//NewY4MRecorder returns a new Y4MRecorder writing to file
+func NewY4MRecorder(file io.WriteCloser) *Y4MRecorder {
+	return &Y4MRecorder{file: file, w: bufio.NewWriter(file)}
+}
+
+//WriteFrame implements the Recorder interface
+func (r *Y4MRecorder) WriteFrame(img *image.RGBA) error {
+	bounds := img.Bounds()
+	size := bounds.Dx() * bounds.Dy()
+	if !r.started {
+		fmt.Fprintf(r.w, "YUV4MPEG2 W%d H%d F60:1 Ip A1:1 C444\n", bounds.Dx(), bounds.Dy())
+		r.planes = make([]uint8, size*3)
+		r.started = true
+	}
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			r.planes[i], r.planes[size+i], r.planes[2*size+i] = color.RGBToYCbCr(c.R, c.G, c.B)
+			i++
+		}
+	}
+	r.w.WriteString("FRAME\n")
+	_, err := r.w.Write(r.planes)
+	return err
+}
+
+//Close implements the Recorder interface
+func (r *Y4MRecorder) Close() error {
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+//RGBRecorder streams raw 24 bit RGB frames (560x384 at 60fps) with no header, for example
+//ffmpeg -f rawvideo -pix_fmt rgb24 -s 560x384 -r 60 -i session.rgb session.mp4
+type RGBRecorder struct {
+	file io.WriteCloser
+	w    *bufio.Writer
+	line []uint8
+}
+
+//NewRGBRecorder returns a new RGBRecorder writing to file
+func NewRGBRecorder(file io.WriteCloser) *RGBRecorder {
+	return &RGBRecorder{file: file, w: bufio.NewWriter(file)}
+}
+
+//WriteFrame implements the Recorder interface
+func (r *RGBRecorder) WriteFrame(img *image.RGBA) error {
+	bounds := img.Bounds()
+	if len(r.line) != bounds.Dx()*3 {
+		r.line = make([]uint8, bounds.Dx()*3)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			copy(r.line[x*3:x*3+3], pix[x*4:x*4+3])
+		}
+		if _, err := r.w.Write(r.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Close implements the Recorder interface
+func (r *RGBRecorder) Close() error {
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,39 @@
+package video
+
+/* renderer_headless.go -- Renderer for running without a display
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"image"
+)
+
+//RendererHeadless throws frames away, use the System capture functions to look at them
+type RendererHeadless struct{}
+
+//NewHeadlessRenderer makes and returns a new headless renderer
+func NewHeadlessRenderer() *RendererHeadless {
+	return &RendererHeadless{}
+}
+
+//Init set up the renderer
+func (r *RendererHeadless) Init() {
+}
+
+//Render renders the current display buffer
+func (r *RendererHeadless) Render(img *image.RGBA, rows uint32) {
+}
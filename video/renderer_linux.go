@@ -34,23 +34,22 @@ struct fb_var_screeninfo getVarScreenInfo(int fd) {
 */
 import "C"
 import (
+	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"os"
 	"syscall"
-
-	"github.com/nfnt/resize"
 )
 
 //RendererLinux is a PIZero specific renderer
 type RendererLinux struct {
-	blink int
-	dev   *Device
+	blink  int
+	dev    *Device
+	scaler *Scaler
 }
 
-//NewRenderer makes and returns a new renderer
-func NewRenderer() *RendererLinux {
+//NewRenderer makes and returns a new renderer that scales frames to the framebuffer with mode
+func NewRenderer(mode ScaleMode) *RendererLinux {
 	ren := RendererLinux{}
 
 	file, err := os.OpenFile("/dev/fb0", os.O_RDWR, os.ModeDevice)
@@ -61,9 +60,21 @@ func NewRenderer() *RendererLinux {
 	fixInfo := C.getFixScreenInfo(C.int(file.Fd()))
 	varInfo := C.getVarScreenInfo(C.int(file.Fd()))
 
+	format := PixelFormat{
+		Bits:  int(varInfo.bits_per_pixel),
+		Red:   Bitfield{uint(varInfo.red.offset), uint(varInfo.red.length)},
+		Green: Bitfield{uint(varInfo.green.offset), uint(varInfo.green.length)},
+		Blue:  Bitfield{uint(varInfo.blue.offset), uint(varInfo.blue.length)},
+		Alpha: Bitfield{uint(varInfo.transp.offset), uint(varInfo.transp.length)},
+	}
+	if format.Bits != 16 && format.Bits != 24 && format.Bits != 32 {
+		file.Close()
+		panic(fmt.Sprintf("unsupported framebuffer depth %d bpp", format.Bits))
+	}
+
 	pixels, err := syscall.Mmap(
 		int(file.Fd()),
-		0, int(varInfo.xres*varInfo.yres*varInfo.bits_per_pixel),
+		0, int(fixInfo.line_length*varInfo.yres),
 		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
 	)
 	if err != nil {
@@ -76,6 +87,13 @@ func NewRenderer() *RendererLinux {
 		pixels,
 		int(fixInfo.line_length),
 		image.Rect(0, 0, int(varInfo.xres), int(varInfo.yres)),
+		format,
+	}
+	ren.scaler = NewScaler(mode, format, 560, 384, int(varInfo.xres), int(varInfo.yres))
+
+	//Clear the screen, the scaler never touches the borders
+	for i := range pixels {
+		pixels[i] = 0
 	}
 
 	return &ren
@@ -91,6 +109,7 @@ type Device struct {
 	pixels []byte
 	pitch  int
 	bounds image.Rectangle
+	format PixelFormat
 }
 
 // Close unmaps the framebuffer memory and closes the device file. Call this
@@ -111,8 +130,9 @@ func (d *Device) At(x, y int) color.Color {
 		y < d.bounds.Min.Y || y >= d.bounds.Max.Y {
 		return color.RGBA{0, 0, 0, 0}
 	}
-	i := y*d.pitch + 4*x
-	return color.RGBA{d.pixels[i], d.pixels[i+1], d.pixels[i+2], 255}
+	i := y*d.pitch + d.format.Bytes()*x
+	r, g, b := d.format.Unpack(d.format.Get(d.pixels[i:]))
+	return color.RGBA{r, g, b, 255}
 }
 
 // ColorModel implements the image.Image (and draw.Image) interface.
@@ -126,17 +146,15 @@ func (d *Device) Set(x, y int, c color.Color) {
 	if x >= 0 && x < d.bounds.Max.X &&
 		y >= 0 && y < d.bounds.Max.Y {
 		r, g, b, _ := c.RGBA()
-		i := y*d.pitch + 4*x
-		d.pixels[i] = byte(b)
-		d.pixels[i+1] = byte(g)
-		d.pixels[i+2] = byte(r)
-		d.pixels[i+3] = byte(255)
+		i := y*d.pitch + d.format.Bytes()*x
+		d.format.Put(d.pixels[i:], d.format.Pack(uint8(r>>8), uint8(g>>8), uint8(b>>8)))
 	}
 }
 
 //Render renders the current display buffer
-func (r *RendererLinux) Render(src draw.Image) {
-	rect := image.Rect(0, 0, r.dev.bounds.Max.X, r.dev.bounds.Max.Y)
-	img := resize.Resize(uint(rect.Size().X), uint(rect.Size().Y), src, resize.Lanczos3)
-	draw.Draw(r.dev, rect, img, image.Point{0, 0}, draw.Src)
+func (r *RendererLinux) Render(img *image.RGBA, rows uint32) {
+	if rows == 0 {
+		return
+	}
+	r.scaler.ScaleBands(img, r.dev.pixels, r.dev.pitch, rows)
 }
@@ -3,8 +3,6 @@ package video
 import (
 	"image"
 	"image/draw"
-
-	"github.com/nfnt/resize"
 )
 
 //RendererWindows is a windows specific renderer
@@ -12,14 +10,19 @@ type RendererWindows struct {
 	blink  int
 	width  int
 	height int
+	scaler *Scaler
 }
 
-var gImg draw.Image
+var gImg *image.RGBA
 
-//NewRenderer makes and returns a new renderer, specify the device width & height
-func NewRenderer(w, h int) *RendererWindows {
+//NewRenderer makes and returns a new renderer, specify the device width & height and how frames are scaled to it
+func NewRenderer(w, h int, mode ScaleMode) *RendererWindows {
 	ren := RendererWindows{width: w, height: h}
 	gImg = image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 3; i < len(gImg.Pix); i += 4 {
+		gImg.Pix[i] = 255 //Opaque black borders
+	}
+	ren.scaler = NewScaler(mode, FormatRGBA32, 560, 384, w, h)
 	return &ren
 }
 
@@ -35,8 +38,9 @@ func (r *RendererWindows) Init() {
 }
 
 //Render renders the current display buffer
-func (r *RendererWindows) Render(src draw.Image) {
-	rect := image.Rect(0, 0, r.width, r.height)
-	img := resize.Resize(uint(rect.Size().X), uint(rect.Size().Y), src, resize.Lanczos3)
-	draw.Draw(gImg, rect, img, image.Point{0, 0}, draw.Src)
+func (r *RendererWindows) Render(img *image.RGBA, rows uint32) {
+	if rows == 0 {
+		return
+	}
+	r.scaler.ScaleBands(img, gImg.Pix, gImg.Stride, rows)
 }
@@ -0,0 +1,286 @@
+package video
+
+/* scale.go -- Fast scaling of the Apple display to the output device
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"fmt"
+	"image"
+)
+
+//ScaleMode how the frame is scaled to fit the output device
+type ScaleMode int
+
+//Scale Modes
+const (
+	//ScaleNearest stretch to fill the output using the nearest pixel
+	ScaleNearest ScaleMode = iota
+	//ScaleInteger the largest whole multiple of the frame that fits, centered
+	ScaleInteger
+	//ScaleLetterbox keep the 4:3 aspect ratio of a real monitor, centered with black bars
+	ScaleLetterbox
+	//ScaleBilinear stretch to fill the output, smoothed with a precomputed lookup table
+	ScaleBilinear
+)
+
+var scaleModeNames = [...]string{"nearest", "integer", "letterbox", "bilinear"}
+
+func (m ScaleMode) String() string {
+	if int(m) < len(scaleModeNames) {
+		return scaleModeNames[m]
+	}
+	return "unknown"
+}
+
+//ParseScaleMode turns a scale mode name back into a ScaleMode
+func ParseScaleMode(name string) (ScaleMode, error) {
+	for i, n := range scaleModeNames {
+		if n == name {
+			return ScaleMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scale mode %q", name)
+}
+
+//Scaler copies frames of a fixed size onto an output of a fixed size and pixel format.  All of
+//the lookup tables are built up front so scaling a frame never allocates
+type Scaler struct {
+	mode       ScaleMode
+	format     PixelFormat
+	srcW, srcH int
+	rect       image.Rectangle //Area of the output covered by the frame, the rest stays black
+	xs         []int           //Source byte offset in the row for each output column
+	ys         []int           //Source row for each output row
+	xs1        []int           //Bilinear only, the second source column
+	ys1        []int           //Bilinear only, the second source row
+	xw         []uint32        //Bilinear only, weight of the second column (0-256)
+	yw         []uint32        //Bilinear only, weight of the second row (0-256)
+	bands      []uint32        //Which 16 line bands of the source each output row reads from
+	//Formats that aren't a byte per channel (RGB565, etc...) pack each channel through a lookup
+	//table, three loads and two ORs a pixel. packed is nil for the others
+	packed *[3][256]uint32
+}
+
+//AllBands is a band mask that redraws the whole output
+const AllBands = ^uint32(0)
+
+//NewScaler builds the lookup tables to scale a srcW x srcH frame onto a dstW x dstH output in format
+func NewScaler(mode ScaleMode, format PixelFormat, srcW, srcH, dstW, dstH int) *Scaler {
+	s := Scaler{mode: mode, format: format, srcW: srcW, srcH: srcH, rect: image.Rect(0, 0, dstW, dstH)}
+	_, rok := format.Red.byteAligned()
+	_, gok := format.Green.byteAligned()
+	_, bok := format.Blue.byteAligned()
+	if !rok || !gok || !bok {
+		s.packed = new([3][256]uint32)
+		for i := range s.packed[0] {
+			s.packed[0][i] = format.Red.pack(uint8(i)) | format.Alpha.pack(255)
+			s.packed[1][i] = format.Green.pack(uint8(i))
+			s.packed[2][i] = format.Blue.pack(uint8(i))
+		}
+	}
+
+	switch mode {
+	case ScaleInteger:
+		k := dstW / srcW
+		if dstH/srcH < k {
+			k = dstH / srcH
+		}
+		if k > 0 {
+			s.rect = centered(srcW*k, srcH*k, dstW, dstH)
+		} else {
+			//The output is smaller than the frame, the best we can do is letterbox
+			s.mode = ScaleLetterbox
+			s.rect = letterbox(dstW, dstH)
+		}
+	case ScaleLetterbox:
+		s.rect = letterbox(dstW, dstH)
+	}
+
+	w, h := s.rect.Dx(), s.rect.Dy()
+	s.xs = make([]int, w)
+	s.ys = make([]int, h)
+	if s.mode != ScaleBilinear {
+		for x := range s.xs {
+			s.xs[x] = (x * srcW / w) * 4
+		}
+		for y := range s.ys {
+			s.ys[y] = y * srcH / h
+		}
+		s.buildBands()
+		return &s
+	}
+
+	s.xs1 = make([]int, w)
+	s.ys1 = make([]int, h)
+	s.xw = make([]uint32, w)
+	s.yw = make([]uint32, h)
+	for x := range s.xs {
+		x0, x1, f := bilinearTap(x, w, srcW)
+		s.xs[x], s.xs1[x], s.xw[x] = x0*4, x1*4, f
+	}
+	for y := range s.ys {
+		s.ys[y], s.ys1[y], s.yw[y] = bilinearTap(y, h, srcH)
+	}
+	s.buildBands()
+	return &s
+}
+
+func (s *Scaler) buildBands() {
+	s.bands = make([]uint32, len(s.ys))
+	for y, sy := range s.ys {
+		s.bands[y] = 1 << uint(sy/16)
+		if s.ys1 != nil {
+			s.bands[y] |= 1 << uint(s.ys1[y]/16)
+		}
+	}
+}
+
+//bilinearTap the two source pixels and the weight of the second for output pixel d
+func bilinearTap(d, dstSize, srcSize int) (int, int, uint32) {
+	pos := ((2*d+1)*srcSize*256)/(2*dstSize) - 128 //Pixel centers in 1/256ths
+	if pos < 0 {
+		pos = 0
+	}
+	p0 := pos >> 8
+	p1 := p0 + 1
+	if p1 >= srcSize {
+		p1 = srcSize - 1
+	}
+	return p0, p1, uint32(pos & 0xFF)
+}
+
+func centered(w, h, dstW, dstH int) image.Rectangle {
+	x := (dstW - w) / 2
+	y := (dstH - h) / 2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func letterbox(dstW, dstH int) image.Rectangle {
+	if dstW*3 > dstH*4 {
+		return centered(dstH*4/3, dstH, dstW, dstH)
+	}
+	return centered(dstW, dstW*3/4, dstW, dstH)
+}
+
+//Rect the area of the output the frame is drawn to
+func (s *Scaler) Rect() image.Rectangle {
+	return s.rect
+}
+
+//Scale draws src into an output buffer with the given pitch (bytes per row)
+func (s *Scaler) Scale(src *image.RGBA, dst []byte, pitch int) {
+	s.ScaleBands(src, dst, pitch, AllBands)
+}
+
+//ScaleBands is Scale for only the parts of src that changed, bit N of bands set means
+//source lines N*16 to N*16+15 need to be redrawn (one Apple text row)
+func (s *Scaler) ScaleBands(src *image.RGBA, dst []byte, pitch int, bands uint32) {
+	if s.packed != nil {
+		s.scalePacked(src, dst, pitch, bands)
+		return
+	}
+	bpp := s.format.Bytes()
+	ri, _ := s.format.Red.byteAligned()
+	gi, _ := s.format.Green.byteAligned()
+	bi, _ := s.format.Blue.byteAligned()
+	ai, aok := s.format.Alpha.byteAligned()
+	if !aok {
+		ai = -1
+	}
+
+	for dy := range s.ys {
+		if s.bands[dy]&bands == 0 {
+			continue
+		}
+		row := dst[(s.rect.Min.Y+dy)*pitch+s.rect.Min.X*bpp:]
+		if s.mode == ScaleBilinear {
+			r0, r1, fy := s.bilinearRows(src, dy)
+			for dx, sx := range s.xs {
+				sx1, fx := s.xs1[dx], s.xw[dx]
+				o := row[dx*bpp : dx*bpp+bpp]
+				o[ri] = lerp2(r0[sx], r0[sx1], r1[sx], r1[sx1], fx, fy)
+				o[gi] = lerp2(r0[sx+1], r0[sx1+1], r1[sx+1], r1[sx1+1], fx, fy)
+				o[bi] = lerp2(r0[sx+2], r0[sx1+2], r1[sx+2], r1[sx1+2], fx, fy)
+				if ai >= 0 {
+					o[ai] = 255
+				}
+			}
+			continue
+		}
+		srow := src.Pix[s.ys[dy]*src.Stride:]
+		for dx, sx := range s.xs {
+			p := srow[sx : sx+3]
+			o := row[dx*bpp : dx*bpp+bpp]
+			o[ri] = p[0]
+			o[gi] = p[1]
+			o[bi] = p[2]
+			if ai >= 0 {
+				o[ai] = 255
+			}
+		}
+	}
+}
+
+//scalePacked is the slower path for formats that aren't a byte per channel (RGB565, etc...)
+func (s *Scaler) scalePacked(src *image.RGBA, dst []byte, pitch int, bands uint32) {
+	format := s.format
+	rl, gl, bl := &s.packed[0], &s.packed[1], &s.packed[2]
+	bpp := format.Bytes()
+	for dy := range s.ys {
+		if s.bands[dy]&bands == 0 {
+			continue
+		}
+		row := dst[(s.rect.Min.Y+dy)*pitch+s.rect.Min.X*bpp:]
+		if s.mode == ScaleBilinear {
+			r0, r1, fy := s.bilinearRows(src, dy)
+			for dx, sx := range s.xs {
+				sx1, fx := s.xs1[dx], s.xw[dx]
+				p := rl[lerp2(r0[sx], r0[sx1], r1[sx], r1[sx1], fx, fy)] |
+					gl[lerp2(r0[sx+1], r0[sx1+1], r1[sx+1], r1[sx1+1], fx, fy)] |
+					bl[lerp2(r0[sx+2], r0[sx1+2], r1[sx+2], r1[sx1+2], fx, fy)]
+				format.Put(row[dx*bpp:], p)
+			}
+			continue
+		}
+		srow := src.Pix[s.ys[dy]*src.Stride:]
+		if bpp == 2 {
+			for dx, sx := range s.xs {
+				p := rl[srow[sx]] | gl[srow[sx+1]] | bl[srow[sx+2]]
+				o := row[dx*2 : dx*2+2]
+				o[0] = uint8(p)
+				o[1] = uint8(p >> 8)
+			}
+			continue
+		}
+		for dx, sx := range s.xs {
+			format.Put(row[dx*bpp:], rl[srow[sx]]|gl[srow[sx+1]]|bl[srow[sx+2]])
+		}
+	}
+}
+
+//bilinearRows the two source rows and the weight of the second for output row dy
+func (s *Scaler) bilinearRows(src *image.RGBA, dy int) ([]uint8, []uint8, uint32) {
+	return src.Pix[s.ys[dy]*src.Stride:], src.Pix[s.ys1[dy]*src.Stride:], s.yw[dy]
+}
+
+//lerp2 blends four pixels, fx and fy are the weights of the second column and row (0-256)
+func lerp2(a, b, c, d uint8, fx, fy uint32) uint8 {
+	top := uint32(a)*(256-fx) + uint32(b)*fx
+	bot := uint32(c)*(256-fx) + uint32(d)*fx
+	return uint8((top*(256-fy) + bot*fy) >> 16)
+}
@@ -0,0 +1,104 @@
+package video
+
+/* scale_test.go -- Scaler tests and frame cost benchmarks
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"image"
+	"testing"
+)
+
+//testFrame a 560x384 frame of lo-res color bars
+func testFrame() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 560, 384))
+	for y := 0; y < 384; y++ {
+		for x := 0; x < 560; x++ {
+			img.SetRGBA(x, y, lowResColors[x/35])
+		}
+	}
+	return img
+}
+
+func TestScaleFormats(t *testing.T) {
+	img := testFrame()
+	for _, format := range []PixelFormat{FormatRGBA32, FormatBGRA32, FormatRGB565} {
+		bpp := format.Bytes()
+		out := make([]byte, 1120*768*bpp)
+		NewScaler(ScaleNearest, format, 560, 384, 1120, 768).Scale(img, out, 1120*bpp)
+		for x := 0; x < 1120; x += 70 {
+			c := lowResColors[x/70]
+			want := format.Red.pack(c.R) | format.Green.pack(c.G) | format.Blue.pack(c.B) | format.Alpha.pack(255)
+			var got uint32
+			for i := bpp - 1; i >= 0; i-- {
+				got = got<<8 | uint32(out[(400*1120+x)*bpp+i])
+			}
+			if got != want {
+				t.Errorf("%d bpp column %d: got %08X, want %08X", format.Bits, x, got, want)
+			}
+		}
+	}
+}
+
+func TestScaleLetterboxBorders(t *testing.T) {
+	s := NewScaler(ScaleLetterbox, FormatRGBA32, 560, 384, 800, 480)
+	if want := image.Rect(80, 0, 720, 480); s.Rect() != want {
+		t.Fatalf("letterbox is %v, want %v", s.Rect(), want)
+	}
+	out := make([]byte, 800*480*4)
+	s.Scale(testFrame(), out, 800*4)
+	for _, x := range []int{0, 79, 720, 799} {
+		if p := out[(240*800+x)*4 : (240*800+x)*4+4]; p[0]|p[1]|p[2]|p[3] != 0 {
+			t.Errorf("border column %d was drawn on: %v", x, p)
+		}
+	}
+}
+
+func benchmarkScale(b *testing.B, mode ScaleMode, format PixelFormat, w, h int) {
+	img := testFrame()
+	bpp := format.Bytes()
+	out := make([]byte, w*h*bpp)
+	s := NewScaler(mode, format, 560, 384, w, h)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Scale(img, out, w*bpp)
+	}
+}
+
+func BenchmarkScaleNearestRGBA32(b *testing.B) {
+	benchmarkScale(b, ScaleNearest, FormatRGBA32, 1280, 720)
+}
+
+func BenchmarkScaleNearestBGRA32(b *testing.B) {
+	benchmarkScale(b, ScaleNearest, FormatBGRA32, 1280, 720)
+}
+
+func BenchmarkScaleNearestRGB565(b *testing.B) {
+	benchmarkScale(b, ScaleNearest, FormatRGB565, 480, 320)
+}
+
+func BenchmarkScaleBilinearRGBA32(b *testing.B) {
+	benchmarkScale(b, ScaleBilinear, FormatRGBA32, 1280, 720)
+}
+
+func BenchmarkScaleBilinearRGB565(b *testing.B) {
+	benchmarkScale(b, ScaleBilinear, FormatRGB565, 480, 320)
+}
+
+func BenchmarkScaleLetterboxRGB565(b *testing.B) {
+	benchmarkScale(b, ScaleLetterbox, FormatRGB565, 480, 320)
+}
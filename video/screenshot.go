@@ -0,0 +1,103 @@
+package video
+
+/* screenshot.go -- Frame capture for screenshots and bug reports
+   Copyright (C) 2020 Cupcakus
+
+   This program is free software; you can redistribute it and/or
+   modify it under the terms of the GNU General Public License
+   as published by the Free Software Foundation; Version 2
+   of the License ONLY.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"log"
+	"os"
+
+	"github.com/nfnt/resize"
+)
+
+//present hands the finished frame to the renderer and any captures
+func (s *System) present(rows uint32) {
+	s.ren.Render(s.img, rows)
+	s.record(s.img)
+
+	s.lock.Lock()
+	s.frameCount++
+	filename, ok := s.dumps[s.frameCount]
+	if ok {
+		delete(s.dumps, s.frameCount)
+	}
+	s.lock.Unlock()
+
+	if ok {
+		if err := savePNG(filename, s.Screenshot(0, 0)); err != nil {
+			log.Printf("Failed to dump frame %d: %v", s.frameCount, err)
+		}
+	}
+}
+
+//FrameCount the number of frames rendered since the video system was created
+func (s *System) FrameCount() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.frameCount
+}
+
+//Screenshot returns a copy of the last rendered frame, a width and height of 0
+//returns the frame at the native 560x384 resolution
+func (s *System) Screenshot(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 560, 384))
+	s.lock.Lock()
+	copy(img.Pix, s.img.Pix)
+	s.lock.Unlock()
+	//The palette's white is transparent, captures should always be opaque
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	if width > 0 && height > 0 {
+		return resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+	}
+	return img
+}
+
+//SaveScreenshot writes the last rendered frame to a PNG file, see Screenshot for width and height
+func (s *System) SaveScreenshot(filename string, width, height int) error {
+	if s.FrameCount() == 0 {
+		return errors.New("no frame has been rendered yet")
+	}
+	return savePNG(filename, s.Screenshot(width, height))
+}
+
+//DumpFrame writes frame number n (counting from 1) to a PNG file once it has been rendered
+func (s *System) DumpFrame(n uint64, filename string) {
+	s.lock.Lock()
+	if s.dumps == nil {
+		s.dumps = make(map[uint64]string)
+	}
+	s.dumps[n] = filename
+	s.lock.Unlock()
+}
+
+func savePNG(filename string, img image.Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
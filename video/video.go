@@ -21,9 +21,9 @@ package video
 import (
 	"image"
 	"image/color"
-	"image/draw"
 	"io/ioutil"
 	"log"
+	"sync"
 
 	"github.com/cupcakus/appleII-piz/appleii"
 )
@@ -31,7 +31,8 @@ import (
 //Renderer device specific renderer, framebuffer on Pi-Zero, GLFW window on Windows
 type Renderer interface {
 	Init()
-	Render(draw.Image)
+	//Render a 560x384 frame, only the text rows (16 lines each) set in rows have changed
+	Render(img *image.RGBA, rows uint32)
 }
 
 //System Apple IIe Generic video system
@@ -42,6 +43,12 @@ type System struct {
 	bus         *appleii.Bus //Memory module holds the status flags
 	ren         Renderer
 	screen      [][]uint8
+	img         *image.RGBA       //Colorized frame, only dirty rows are updated
+	full        bool              //Redraw everything on the next frame
+	lock        sync.Mutex        //Guards the frame, captures can come from another goroutine
+	frameCount  uint64            //Frames rendered so far
+	dumps       map[uint64]string //Frame numbers to write to disk
+	rec         Recorder          //Recording in progress
 }
 
 var rowOffsets = [24]uint16{0x0, 0x80, 0x100, 0x180, 0x200, 0x280, 0x300, 0x380, 0x28, 0xA8, 0x128, 0x1A8, 0x228, 0x2A8, 0x328, 0x3A8, 0x50, 0xD0, 0x150, 0x1D0, 0x250, 0x2D0, 0x350, 0x3D0}
@@ -79,8 +86,13 @@ func NewVideo(b *appleii.Bus, r Renderer) *System {
 		log.Fatal("Failed to video ROM")
 	}
 
-	sys := System{rom: data, bus: b, ren: r, renderColor: true}
+	sys := System{rom: data, bus: b, ren: r, renderColor: true, full: true}
 	sys.monoColor = lowResColors[lightgreen]
+	sys.screen = make([][]uint8, 560)
+	for i := 0; i < 560; i++ {
+		sys.screen[i] = make([]uint8, 384)
+	}
+	sys.img = image.NewRGBA(image.Rect(0, 0, 560, 384))
 
 	r.Init()
 	return &sys
@@ -89,11 +101,13 @@ func NewVideo(b *appleii.Bus, r Renderer) *System {
 //ToggleColorMode Set color or monochrome rendering mode
 func (s *System) ToggleColorMode() {
 	s.renderColor = !s.renderColor
+	s.full = true
 }
 
 //SetMonochromeColor sets the color for monochrome mode, default is lightgreen
 func (s *System) SetMonochromeColor(color color.RGBA) {
 	s.monoColor = color
+	s.full = true
 }
 
 func (s *System) drawHiResGlyph(aX, aY, addr int, mem []uint8) {
@@ -258,15 +272,17 @@ var doubleHiResBlockTo = [16][16]int{
 	{0x0007, 0x0007, 0x0007, 0x0007, 0x0007, 0x0007, 0x0007, 0x0007, 0x000F, 0x000F, 0x000F, 0x000F, 0x000F, 0x000F, 0x000F, 0x000F},
 }
 
-func (s *System) colorizeDblHiResDisplay() *image.RGBA {
+func (s *System) colorizeDblHiResDisplay(rows uint32) {
 	if !s.renderColor {
-		return s.renderDisplay()
+		s.renderDisplay(rows)
+		return
 	}
 
-	rect := image.Rect(0, 0, 560, 384)
-	ret := image.NewRGBA(rect)
-
+	ret := s.img
 	for y := 0; y < 384; y += 2 {
+		if rows&(1<<uint(y/16)) == 0 {
+			continue
+		}
 		for x := 0; x < 560; x += 4 {
 			f1, f2, f3, f4 := uint8(0), uint8(0), uint8(0), uint8(0)
 			if x > 0 {
@@ -343,14 +359,14 @@ func (s *System) colorizeDblHiResDisplay() *image.RGBA {
 			ret.SetRGBA(x+3, y+1, lowResColors[hiResColors[resultColor&0xF]])
 		}
 	}
-	return ret
 }
 
-func (s *System) renderDisplay() *image.RGBA {
-	rect := image.Rect(0, 0, 560, 384)
-	ret := image.NewRGBA(rect)
-
+func (s *System) renderDisplay(rows uint32) {
+	ret := s.img
 	for y := 0; y < 384; y += 2 {
+		if rows&(1<<uint(y/16)) == 0 {
+			continue
+		}
 		for x := 0; x < 560; x += 2 {
 			c := s.screen[x][y]
 			color := lowResColors[c]
@@ -363,17 +379,19 @@ func (s *System) renderDisplay() *image.RGBA {
 			ret.SetRGBA(x+1, y+1, color)
 		}
 	}
-	return ret
 }
 
-func (s *System) colorizeDisplay() *image.RGBA {
+func (s *System) colorizeDisplay(rows uint32) {
 	if !s.renderColor {
-		return s.renderDisplay()
+		s.renderDisplay(rows)
+		return
 	}
-	rect := image.Rect(0, 0, 560, 384)
-	ret := image.NewRGBA(rect)
 
+	ret := s.img
 	for y := 0; y < 384; y += 2 {
+		if rows&(1<<uint(y/16)) == 0 {
+			continue
+		}
 		for x := 0; x < 560; x += 2 {
 			cBef := false
 			cAt := false
@@ -421,22 +439,53 @@ func (s *System) colorizeDisplay() *image.RGBA {
 			ret.SetRGBA(x+1, y+1, color)
 		}
 	}
-	return ret
+}
+
+//clearRows blank the rows of the screen that are about to be redrawn
+func (s *System) clearRows(rows uint32) {
+	for y := 0; y < 24; y++ {
+		if rows&(1<<uint(y)) == 0 {
+			continue
+		}
+		for x := 0; x < 560; x++ {
+			col := s.screen[x][y*16 : y*16+16]
+			for i := range col {
+				col[i] = 0
+			}
+		}
+	}
 }
 
 //RenderFrame render the current display screen based on the current graphics mode
-func (s *System) RenderFrame(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16, textMode bool, hiRes bool, col80 bool, mixed bool, dblhires bool) {
+//only the text rows set in dirty are redrawn, if nothing changed the frame is skipped
+func (s *System) RenderFrame(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16, textMode bool, hiRes bool, col80 bool, mixed bool, dblhires bool, dirty uint32) {
 	//fmt.Printf("RENDER: START: 0x%x | TXT: %t | HIRES: %t | 80COL: %t | MIX: %t | DBLHIRES %t\n", gpuStart, textMode, hiRes, col80, mixed, dblhires)
-	//Clear Screen Slice
-	s.screen = make([][]uint8, 560)
-	for i := 0; i < 560; i++ {
-		s.screen[i] = make([]uint8, 384)
+	if s.full {
+		dirty = appleii.AllRows
+		s.full = false
+	}
+	if dirty == 0 {
+		s.present(0)
+		return
 	}
+
+	s.lock.Lock()
+	s.drawRows(gpuMem, gpuAuxMem, gpuStart, textMode, hiRes, col80, mixed, dblhires, dirty)
+	s.lock.Unlock()
+	s.present(dirty)
+}
+
+//drawRows draws the dirty text rows into the screen and colorizes them into the frame
+func (s *System) drawRows(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16, textMode bool, hiRes bool, col80 bool, mixed bool, dblhires bool, dirty uint32) {
+	s.clearRows(dirty)
 	if !col80 {
 		for y := 0; y < 24; y++ {
 			if mixed && hiRes && !textMode && y == 20 {
 				gpuStart -= 0x1C00
 			}
+			if dirty&(1<<uint(y)) == 0 {
+				continue
+			}
 			for x := 0; x < 40; x++ {
 				if hiRes && !textMode && (!mixed || y <= 19) {
 					addr := gpuStart + uint16(x) + rowOffsets[y]
@@ -452,8 +501,7 @@ func (s *System) RenderFrame(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16,
 			}
 		}
 		if hiRes && !textMode {
-			img := s.colorizeDisplay()
-			s.ren.Render(img)
+			s.colorizeDisplay(dirty)
 			return
 		}
 	} else {
@@ -462,6 +510,9 @@ func (s *System) RenderFrame(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16,
 			if mixed && hiRes && !textMode && y == 20 {
 				gpuStart -= 0x1C00
 			}
+			if dirty&(1<<uint(y)) == 0 {
+				continue
+			}
 			for x := 0; x < 80; x += 2 {
 				if hiRes && !textMode && (!mixed || y <= 19) {
 					if dblhires {
@@ -492,16 +543,12 @@ func (s *System) RenderFrame(gpuMem []uint8, gpuAuxMem []uint8, gpuStart uint16,
 		}
 		if hiRes && !textMode {
 			if dblhires {
-				img := s.colorizeDblHiResDisplay()
-				s.ren.Render(img)
+				s.colorizeDblHiResDisplay(dirty)
 				return
 			}
-			img := s.colorizeDisplay()
-			s.ren.Render(img)
+			s.colorizeDisplay(dirty)
 			return
 		}
 	}
-	img := s.renderDisplay()
-	s.ren.Render(img)
-	return
+	s.renderDisplay(dirty)
 }